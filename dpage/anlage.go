@@ -3,24 +3,23 @@ package dpage
 import (
 	"fmt"
 	"github.com/pkg/errors"
-	"github.com/rismaster/allris-common/application"
 	"github.com/rismaster/allris-common/common"
-	"github.com/rismaster/allris-common/common/files"
+	"github.com/rismaster/allris-common/common/slog"
 	"github.com/rismaster/allris-common/downloader"
 )
 
 type Anlage struct {
-	app          *application.AppContext
+	app          *App
 	webRessource *downloader.RisRessource
-	file         *files.File
+	file         *File
 }
 
-func NewAnlage(app *application.AppContext, ris *downloader.RisRessource) *Anlage {
+func NewAnlage(app *App, ris *downloader.RisRessource) *Anlage {
 
 	return &Anlage{
 		app:          app,
 		webRessource: ris,
-		file:         files.NewFile(app, ris),
+		file:         NewFile(app, ris),
 	}
 }
 
@@ -28,17 +27,35 @@ func (a *Anlage) GetPath() string {
 	return a.file.GetPath()
 }
 
+// GetTextPath is the path of the text extracted from the PDF
+func (a *Anlage) GetTextPath() string {
+	return textPath(a.GetPath())
+}
+
+func (a *Anlage) shareFetches(fetches *fetchCache) {
+	a.file.fetches = fetches
+}
+
 func (a *Anlage) GetUrl() string {
 	return a.webRessource.GetUrl()
 }
 
 func (a *Anlage) Download() error {
-	err := a.file.Fetch(files.HttpGet, a.webRessource, "*")
+	err := a.file.Fetch(httpGet, a.webRessource, "*")
 	if err != nil {
 		return errors.Wrap(err,
 			fmt.Sprintf("error downloading Vorlagenliste from %s, Error: %v", a.webRessource.GetUrl(), err))
 	}
 
 	mewHash := common.Md5HashB(a.file.GetContent())
-	return a.file.WriteIfMoreActualAndDifferent(mewHash)
+	err = a.file.WriteIfMoreActualAndDifferent(mewHash)
+	if err != nil {
+		return err
+	}
+
+	err = writePdfText(a.app, a.file)
+	if err != nil {
+		slog.Warn("error writing text of %s: %v", a.GetPath(), err)
+	}
+	return nil
 }
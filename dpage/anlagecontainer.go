@@ -5,10 +5,8 @@ import (
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/pkg/errors"
-	"github.com/rismaster/allris-common/application"
 	"github.com/rismaster/allris-common/common"
 	"github.com/rismaster/allris-common/common/domtools"
-	"github.com/rismaster/allris-common/common/files"
 	"github.com/rismaster/allris-common/common/slog"
 	"github.com/rismaster/allris-common/downloader"
 	"net/url"
@@ -20,32 +18,38 @@ import (
 )
 
 type AnlageContainer struct {
-	app          *application.AppContext
+	app          *App
 	webRessource *downloader.RisRessource
-	file         *files.File
+	file         *File
+	children     []downloader.RisRessource
 }
 
-func NewVorlage(app *application.AppContext, ris *downloader.RisRessource) *AnlageContainer {
+func NewVorlage(app *App, ris *downloader.RisRessource) *AnlageContainer {
 
 	return NewAnlageContainer(app, ris)
 }
 
-func NewSitzung(app *application.AppContext, ris *downloader.RisRessource) *AnlageContainer {
+func NewSitzung(app *App, ris *downloader.RisRessource) *AnlageContainer {
 
 	return NewAnlageContainer(app, ris)
 }
 
-func NewTop(app *application.AppContext, ris *downloader.RisRessource) *AnlageContainer {
+func NewTop(app *App, ris *downloader.RisRessource) *AnlageContainer {
 
 	return NewAnlageContainer(app, ris)
 }
 
-func NewAnlageContainer(app *application.AppContext, ris *downloader.RisRessource) *AnlageContainer {
+func NewPerson(app *App, ris *downloader.RisRessource) *AnlageContainer {
+
+	return NewAnlageContainer(app, ris)
+}
+
+func NewAnlageContainer(app *App, ris *downloader.RisRessource) *AnlageContainer {
 
 	return &AnlageContainer{
 		app:          app,
 		webRessource: ris,
-		file:         files.NewFile(app, ris),
+		file:         NewFile(app, ris),
 	}
 }
 
@@ -65,11 +69,25 @@ func (a *AnlageContainer) GetUrl() string {
 	return a.webRessource.GetUrl()
 }
 
+// GetModelPath is the path of the parsed model stored as json next to the html
+func (a *AnlageContainer) GetModelPath() string {
+	return a.GetFolder() + a.GetName() + jsonEnding
+}
+
+func (a *AnlageContainer) Children() []downloader.RisRessource {
+	return a.children
+}
+
 func (a *AnlageContainer) Download() error {
 
 	dom, err := a.downloadAndSave()
 	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("error downloading: %s %+v", a.GetPath()))
+		return errors.Wrap(err, fmt.Sprintf("error downloading: %s", a.GetPath()))
+	}
+
+	err = a.writeModel()
+	if err != nil {
+		slog.Warn("error writing model of %s: %v", a.GetPath(), err)
 	}
 
 	existingAnlagen := make(map[string]bool)
@@ -83,11 +101,13 @@ func (a *AnlageContainer) Download() error {
 		for _, anlageRis := range risAnlagen {
 			anlage := NewAnlage(a.app, &anlageRis)
 			existingAnlagen[anlage.GetPath()] = true
+			existingAnlagen[anlage.GetTextPath()] = true
 			risToDownload = append(risToDownload, anlageRis)
 		}
 		for _, ad := range risAnlageDocs {
 			anlageDoc := NewAnlageDocument(a.app, &ad)
 			existingAnlagen[anlageDoc.GetPath()] = true
+			existingAnlagen[anlageDoc.GetTextPath()] = true
 			risToDownload = append(risToDownload, ad)
 		}
 	})
@@ -100,30 +120,32 @@ func (a *AnlageContainer) Download() error {
 		tops = a.extractTops(dom)
 		for _, top := range tops {
 			existingTops[top.GetPath()] = true
+			existingTops[top.GetModelPath()] = true
 			risToDownload = append(risToDownload, *top.webRessource)
 		}
 	}
 
 	childFolders := []string{}
-	err = files.DeleteFilesIfNotInAndAfter(a.app, a.app.Config.GetAnlagenFolder()+a.GetName()+"-anlage-", existingAnlagen, childFolders, time.Time{})
+	err = deleteFilesIfNotInAndAfter(a.app, a.app.Config.GetAnlagenFolder()+a.GetName()+"-anlage-", existingAnlagen, childFolders, time.Time{}, a.GetPath())
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("error deleting %s", a.app.Config.GetAnlagenFolder()+a.GetName()))
 	}
 
 	if a.GetFolder() == a.app.Config.GetSitzungenFolder() {
 		childFolders = []string{a.app.Config.GetAnlagenFolder()}
-		err = files.DeleteFilesIfNotInAndAfter(a.app, a.app.Config.GetTopFolder()+a.GetName()+"-top-", existingTops, childFolders, time.Time{})
+		err = deleteFilesIfNotInAndAfter(a.app, a.app.Config.GetTopFolder()+a.GetName()+"-top-", existingTops, childFolders, time.Time{}, a.GetPath())
 		if err != nil {
 			return errors.Wrap(err, fmt.Sprintf("error deleting %s", a.app.Config.GetTopFolder()+a.GetName()))
 		}
 	}
 
-	return PublishRisDownload(a.app, risToDownload)
+	a.children = risToDownload
+	return nil
 }
 
 func (a *AnlageContainer) downloadAndSave() (*goquery.Document, error) {
 
-	err := a.file.Fetch(files.HttpGet, a.webRessource, "text/html")
+	err := a.file.Fetch(httpGet, a.webRessource, "text/html")
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("error downloading file from %s, Error: %+v", a.GetUrl(), err))
 	}
@@ -151,6 +173,62 @@ func (a *AnlageContainer) downloadAndSave() (*goquery.Document, error) {
 	return doc, nil
 }
 
+func (a *AnlageContainer) writeModel() error {
+
+	dates, err := a.app.Dates()
+	if err != nil {
+		return err
+	}
+
+	var model interface{}
+	switch a.GetFolder() {
+	case a.app.Config.GetVorlagenFolder():
+		vorlage, err := ParseVorlage(a.file.GetContent(), dates)
+		if err != nil {
+			return err
+		}
+		if vorlage.VOLFDNR == 0 {
+			vorlage.VOLFDNR = idFromName(a.GetName())
+		}
+		vorlage.Fraktionen = a.app.fraktionen.fraktionen(a.app, vorlage.VOLFDNR)
+		model = vorlage
+	case a.app.Config.GetSitzungenFolder():
+		sitzung, err := ParseSitzung(a.file.GetContent(), dates)
+		if err != nil {
+			return err
+		}
+		if sitzung.SILFDNR == 0 {
+			sitzung.SILFDNR = idFromName(a.GetName())
+		}
+		if sitzung.GremiumID == 0 {
+			sitzung.GremiumID = a.app.gremien.id(a.app, sitzung.Gremium)
+		}
+		model = sitzung
+	case a.app.Config.GetTopFolder():
+		top, err := ParseTop(a.file.GetContent())
+		if err != nil {
+			return err
+		}
+		if top.TOLFDNR == 0 {
+			top.TOLFDNR = idFromName(a.GetName())
+		}
+		model = top
+	case GetPersonenFolder(a.app):
+		person, err := ParsePerson(a.file.GetContent(), dates)
+		if err != nil {
+			return err
+		}
+		if person.KPLFDNR == 0 {
+			person.KPLFDNR = idFromName(a.GetName())
+		}
+		model = person
+	default:
+		return nil
+	}
+
+	return writeJson(a.app, a.GetModelPath(), a.webRessource.GetCreated(), model)
+}
+
 func (a *AnlageContainer) extractTops(dom *goquery.Document) (tops []*AnlageContainer) {
 
 	if a.GetFolder() != a.app.Config.GetSitzungenFolder() {
@@ -190,43 +268,15 @@ func (a *AnlageContainer) extractTops(dom *goquery.Document) (tops []*AnlageCont
 
 func (a *AnlageContainer) extractAnlagen(dom *goquery.Selection) (docs []downloader.RisRessource) {
 
-	theAnlagenTables := dom.Find("table.tk1")
-	if theAnlagenTables.Size() <= 1 {
-		return docs
-	}
-
-	trs := theAnlagenTables.Last().Find("tr")
-	if trs.Size() < 2 || trs.Next().Children().Size() < 2 {
-		return docs
-	}
-
-	trs.Each(func(i int, selection *goquery.Selection) {
-		tds := selection.Find("td")
-		if i > 2 && tds.Size() >= 3 {
-
-			lnk := tds.Get(2).FirstChild
-			if lnk != nil {
-				href := domtools.GetAttrFromNode(lnk, "href")
-
-				description := domtools.GetChildTextFromNode(lnk)
-				rp := regexp.MustCompile("(.*)[(]([0-9]+ KB)[)]")
-				groups := rp.FindAllStringSubmatch(description, -1) // ["a
-				var size = "0 kb"
-				if groups != nil && len(groups) > 0 && len(groups[0]) > 2 {
-					size = domtools.CleanText(groups[0][2])
-				}
-
-				name := fmt.Sprintf("%s-%s-%s-%s", a.webRessource.GetName(), a.app.Config.GetAnlageType(), size, filepath.Base(href))
-				ending := "" //filename contains ending
-				created := a.webRessource.GetCreated()
-				uri, err := url.Parse(a.app.Config.GetTargetToParse() + href)
-				if err == nil {
-					doc := downloader.NewRisRessource(a.app.Config.GetAnlagenFolder(), name, ending, created, uri, &url.Values{}, a.webRessource.RedownloadChildren, a.webRessource.RedownloadChildren)
-					docs = append(docs, *doc)
-				}
-			}
+	for _, anlage := range parseLinkedAnlagen(dom) {
+		name, ending := anlageName(a.app, a.webRessource.GetName(), anlage)
+		created := a.webRessource.GetCreated()
+		uri, err := url.Parse(a.app.Config.GetTargetToParse() + anlage.Href)
+		if err == nil {
+			doc := downloader.NewRisRessource(a.app.Config.GetAnlagenFolder(), name, ending, created, uri, &url.Values{}, a.webRessource.RedownloadChildren, a.webRessource.RedownloadChildren)
+			docs = append(docs, *doc)
 		}
-	})
+	}
 	return docs
 }
 
@@ -244,8 +294,7 @@ func (a *AnlageContainer) extractBasisAnlagen(dom *goquery.Selection) (docs []do
 		formData.Add("DOLFDNR", strconv.Itoa(dolfdnr))
 		formData.Add("annots", strconv.Itoa(annots))
 
-		name := fmt.Sprintf("%s-%s-%d-%d", a.webRessource.GetName(), a.app.Config.GetAnlageDocumentType(), dolfdnr, dolfdnr%100)
-		ending := ".pdf"
+		name, ending := anlageName(a.app, a.webRessource.GetName(), AnlageInfo{DOLFDNR: dolfdnr})
 		created := a.webRessource.GetCreated()
 		uri, err := url.Parse(a.app.Config.GetTargetToParse() + a.app.Config.GetUrlAnlagedoc())
 
@@ -257,3 +306,26 @@ func (a *AnlageContainer) extractBasisAnlagen(dom *goquery.Selection) (docs []do
 	}
 	return docs
 }
+
+// anlageName is the name and ending of an Anlage stored for the parent, the name contains the size and
+// filename of a linked Anlage or the DOLFDNR of a Basis-Anlage
+func anlageName(app *App, parentName string, anlage AnlageInfo) (name string, ending string) {
+
+	if anlage.DOLFDNR > 0 {
+		return fmt.Sprintf("%s-%s-%d-%d", parentName, app.Config.GetAnlageDocumentType(), anlage.DOLFDNR, anlage.DOLFDNR%100), ".pdf"
+	}
+
+	var size = "0 kb"
+	if anlage.Size != "" {
+		size = anlage.Size
+	}
+	return fmt.Sprintf("%s-%s-%s-%s", parentName, app.Config.GetAnlageType(), size, filepath.Base(anlage.Href)), "" //filename contains ending
+}
+
+// anlagePath is the storage path of an Anlage of the parent
+func anlagePath(app *App, parentName string, anlage AnlageInfo) string {
+
+	name, ending := anlageName(app, parentName, anlage)
+	ris := downloader.NewRisRessource(app.Config.GetAnlagenFolder(), name, ending, time.Time{}, nil, &url.Values{}, false, false)
+	return ris.GetFolder() + ris.GetName() + ris.GetEnding()
+}
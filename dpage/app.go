@@ -0,0 +1,231 @@
+package dpage
+
+import (
+	"cloud.google.com/go/pubsub"
+	"context"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/slog"
+	"io"
+	"path/filepath"
+)
+
+const storeTypeGcs = "gcs"
+const storeTypeLocal = "local"
+const storeTypeMemory = "memory"
+const storeTypeS3 = "s3"
+
+// StoreConfig can be implemented by the Config to use another storage than Cloud Storage. The store type is
+// gcs (default), local, memory or s3, the buckets of the Config are subfolders of the local root or S3 buckets.
+// The memory stores of a bucket are kept for the lifetime of the process.
+type StoreConfig interface {
+	GetStoreType() string
+	GetStoreRoot() string
+	GetS3Endpoint() string
+	GetS3AccessKey() string
+	GetS3SecretKey() string
+	GetS3UseSSL() bool
+}
+
+// App is the context of a sync: the Config, the fetcher of the RIS and the stores of the fetched documents and
+// their backups. ConfirmDeletions allows deletions above the threshold of the DeletionConfig. The changes of
+// the fetched store are emitted to Events if set, indexed in the Index if set, recorded in the Catalog if set,
+// and sent as digest with the Mailer after a sync.
+type App struct {
+	Config           allris_common.Config
+	Fetched          Store
+	Backup           Store
+	ConfirmDeletions bool
+	Events           EventSink
+	Mailer           Mailer
+	Index            *SearchIndex
+	Catalog          *Catalog
+	ctx              context.Context
+	fetcher          *Fetcher
+	plan             *Plan
+	digest           *digestCollector
+	ical             *modelCollector
+	beratungen       *modelCollector
+	gremien          *gremienDirectory
+	fraktionen       *fraktionenDirectory
+	dates            *RisDates
+	datesErr         error
+	// keys of the shared resources released by Close
+	sharedKeys []string
+}
+
+func NewApp(ctx context.Context, conf allris_common.Config, fetched Store, backup Store) *App {
+	app := &App{
+		Config:     conf,
+		Fetched:    fetched,
+		Backup:     backup,
+		ctx:        ctx,
+		digest:     &digestCollector{},
+		ical:       newModelCollector(DocumentSitzung, DocumentKalender),
+		beratungen: newModelCollector(DocumentVorlage, DocumentSitzung, DocumentTop),
+		gremien:    &gremienDirectory{},
+		fraktionen: &fraktionenDirectory{},
+	}
+	app.fetcher = shared.fetcher(conf)
+	app.dates, app.datesErr = NewRisDates(conf)
+	return app
+}
+
+// NewAppFromContext uses the Cloud Storage buckets of an allris-common AppContext
+func NewAppFromContext(appContext *application.AppContext) *App {
+	return NewApp(appContext.Ctx(), appContext.Config,
+		NewGcsStore(appContext.Ctx(), appContext.Store(), appContext.Config.GetBucketFetched()),
+		NewGcsStore(appContext.Ctx(), appContext.Store(), appContext.Config.GetBucketBackup()))
+}
+
+// NewAppWithConfig creates the stores of the StoreConfig, the event sink of the EventConfig, the mailer of the
+// DigestConfig and the index of the SearchConfig, without StoreConfig an AppContext with Cloud Storage is created
+func NewAppWithConfig(ctx context.Context, conf allris_common.Config) (*App, error) {
+
+	storeType := storeTypeGcs
+	sc, ok := conf.(StoreConfig)
+	if ok && sc.GetStoreType() != "" {
+		storeType = sc.GetStoreType()
+	}
+
+	var app *App
+	var publisher *pubsub.Client
+	switch storeType {
+	case storeTypeGcs:
+		appContext, err := application.NewAppContextWithContext(ctx, conf)
+		if err != nil {
+			return nil, errors.Wrap(err, "error init appContext")
+		}
+		app = NewAppFromContext(appContext)
+		publisher = appContext.Publisher()
+
+	case storeTypeLocal:
+		app = NewApp(ctx, conf,
+			NewLocalStore(filepath.Join(sc.GetStoreRoot(), conf.GetBucketFetched())),
+			NewLocalStore(filepath.Join(sc.GetStoreRoot(), conf.GetBucketBackup())))
+
+	case storeTypeMemory:
+		app = NewApp(ctx, conf, shared.memoryStore(conf.GetBucketFetched()), shared.memoryStore(conf.GetBucketBackup()))
+
+	case storeTypeS3:
+		client, err := minio.New(sc.GetS3Endpoint(), &minio.Options{
+			Creds:  credentials.NewStaticV4(sc.GetS3AccessKey(), sc.GetS3SecretKey(), ""),
+			Secure: sc.GetS3UseSSL(),
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("error creating s3 client for %s", sc.GetS3Endpoint()))
+		}
+		app = NewApp(ctx, conf,
+			NewS3Store(ctx, client, conf.GetBucketFetched()),
+			NewS3Store(ctx, client, conf.GetBucketBackup()))
+
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown store type %s", storeType))
+	}
+
+	events, err := newEventSink(ctx, conf, publisher)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating event sink")
+	}
+	app.Events = events
+
+	mailer, err := newMailer(conf)
+	if err != nil {
+		return nil, closeOnError(app, errors.Wrap(err, "error creating mailer"))
+	}
+	app.Mailer = mailer
+
+	index, indexKey, err := newSearchIndex(conf)
+	if err != nil {
+		return nil, closeOnError(app, errors.Wrap(err, "error opening search index"))
+	}
+	if index != nil {
+		app.Index = index
+		app.sharedKeys = append(app.sharedKeys, indexKey)
+	}
+
+	catalog, err := newCatalog(conf)
+	if err != nil {
+		return nil, closeOnError(app, errors.Wrap(err, "error opening catalog"))
+	}
+	app.Catalog = catalog
+	return app, nil
+}
+
+// closeOnError closes what is created of the App so far and returns err
+func closeOnError(app *App, err error) error {
+	if errClose := app.Close(); errClose != nil {
+		slog.Warn("error closing app after %v: %v", err, errClose)
+	}
+	return err
+}
+
+// Close flushes the events, closes a Mailer implementing io.Closer, releases the shared index and closes the
+// catalog
+func (app *App) Close() error {
+
+	var err error
+	if app.Events != nil {
+		err = app.Events.Close()
+	}
+	if closer, ok := app.Mailer.(io.Closer); ok {
+		errMailer := closer.Close()
+		if err == nil {
+			err = errMailer
+		}
+	}
+	for _, key := range app.sharedKeys {
+		errRelease := shared.release(key)
+		if err == nil {
+			err = errRelease
+		}
+	}
+	if app.Catalog != nil {
+		errCatalog := app.Catalog.Close()
+		if err == nil {
+			err = errCatalog
+		}
+	}
+	return err
+}
+
+// DryRun returns an App which fetches and parses like app, but only records the changes of the fetched
+// store in its Plan
+func (app *App) DryRun() *App {
+	plan := NewPlan()
+	return &App{
+		Config:           app.Config,
+		Fetched:          NewPlanStore(app.Fetched, plan),
+		Backup:           NewPlanStore(app.Backup, nil),
+		ConfirmDeletions: app.ConfirmDeletions,
+		ctx:              app.ctx,
+		fetcher:          app.fetcher,
+		plan:             plan,
+		gremien:          app.gremien,
+		fraktionen:       app.fraktionen,
+		dates:            app.dates,
+		datesErr:         app.datesErr,
+	}
+}
+
+// Plan is the Plan of a dry run or nil
+func (app *App) Plan() *Plan {
+	return app.plan
+}
+
+func (app *App) Ctx() context.Context {
+	return app.ctx
+}
+
+func (app *App) Fetcher() *Fetcher {
+	return app.fetcher
+}
+
+// Dates reads the dates of the RIS in the timezone of the Config, the error is the error loading the timezone
+func (app *App) Dates() (*RisDates, error) {
+	return app.dates, app.datesErr
+}
@@ -3,24 +3,23 @@ package dpage
 import (
 	"fmt"
 	"github.com/pkg/errors"
-	"github.com/rismaster/allris-common/application"
 	"github.com/rismaster/allris-common/common"
-	"github.com/rismaster/allris-common/common/files"
+	"github.com/rismaster/allris-common/common/slog"
 	"github.com/rismaster/allris-common/downloader"
 )
 
 type AnlageDocument struct {
-	app          *application.AppContext
+	app          *App
 	webRessource *downloader.RisRessource
-	file         *files.File
+	file         *File
 }
 
-func NewAnlageDocument(app *application.AppContext, ris *downloader.RisRessource) *AnlageDocument {
+func NewAnlageDocument(app *App, ris *downloader.RisRessource) *AnlageDocument {
 
 	return &AnlageDocument{
 		app:          app,
 		webRessource: ris,
-		file:         files.NewFile(app, ris),
+		file:         NewFile(app, ris),
 	}
 }
 
@@ -28,17 +27,35 @@ func (d *AnlageDocument) GetPath() string {
 	return d.file.GetPath()
 }
 
+// GetTextPath is the path of the text extracted from the PDF
+func (d *AnlageDocument) GetTextPath() string {
+	return textPath(d.GetPath())
+}
+
+func (d *AnlageDocument) shareFetches(fetches *fetchCache) {
+	d.file.fetches = fetches
+}
+
 func (d *AnlageDocument) GetUrl() string {
 	return d.webRessource.GetUrl()
 }
 
 func (d *AnlageDocument) Download() error {
 
-	err := d.file.Fetch(files.HttpPost, d.webRessource, "application/pdf")
+	err := d.file.Fetch(httpPost, d.webRessource, "application/pdf")
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("error downloading Vorlagenliste from %s, Error: %+v", d.webRessource.GetUrl(), err))
 	}
 
 	mewHash := common.Md5HashB(d.file.GetContent())
-	return d.file.WriteIfMoreActualAndDifferent(mewHash)
+	err = d.file.WriteIfMoreActualAndDifferent(mewHash)
+	if err != nil {
+		return err
+	}
+
+	err = writePdfText(d.app, d.file)
+	if err != nil {
+		slog.Warn("error writing text of %s: %v", d.GetPath(), err)
+	}
+	return nil
 }
@@ -0,0 +1,418 @@
+package dpage
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/slog"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultBeratungsgraphPath = "beratungsfolgen.json"
+
+// BeratungsgraphConfig can be implemented by the Config to change the path of the stored Beratungsgraph
+type BeratungsgraphConfig interface {
+	GetBeratungsgraphPath() string
+}
+
+// Beratungsgraph links the Vorlagen with the Sitzungen and TOPs of their Beratungsfolge
+type Beratungsgraph struct {
+	Vorlagen map[int][]Beratungsschritt `json:"vorlagen"`
+	Tops     map[int]int                `json:"tops"`
+}
+
+// Beratungsschritt is the Beratung of a Vorlage in a Sitzung, TOLFDNR is 0 for a planned Beratung without TOP
+type Beratungsschritt struct {
+	SILFDNR       int         `json:"silfdnr,omitempty"`
+	TOLFDNR       int         `json:"tolfdnr,omitempty"`
+	GremiumID     int         `json:"gremiumId,omitempty"`
+	Gremium       string      `json:"gremium"`
+	Datum         time.Time   `json:"datum"`
+	Top           string      `json:"top,omitempty"`
+	Rolle         string      `json:"rolle,omitempty"`
+	Beschlussart  string      `json:"beschlussart,omitempty"`
+	Beschlusstext string      `json:"beschlusstext,omitempty"`
+	Abstimmung    *Abstimmung `json:"abstimmung,omitempty"`
+}
+
+func GetBeratungsgraphPath(app *App) string {
+	if bc, ok := app.Config.(BeratungsgraphConfig); ok && bc.GetBeratungsgraphPath() != "" {
+		return bc.GetBeratungsgraphPath()
+	}
+	return defaultBeratungsgraphPath
+}
+
+// Beratungsfolge returns the Beratungen of the Vorlage ordered by date
+func (g *Beratungsgraph) Beratungsfolge(volfdnr int) []Beratungsschritt {
+	return g.Vorlagen[volfdnr]
+}
+
+// VorlageOfTop returns the VOLFDNR of the Vorlage of the TOP, 0 if the TOP has none
+func (g *Beratungsgraph) VorlageOfTop(tolfdnr int) int {
+	return g.Tops[tolfdnr]
+}
+
+// WriteJson exports the Beratungsgraph as json
+func (g *Beratungsgraph) WriteJson(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(g)
+}
+
+// LoadBeratungsgraph reads the Beratungsgraph stored by the last sync
+func LoadBeratungsgraph(app *App) (*Beratungsgraph, error) {
+
+	g := &Beratungsgraph{}
+	err := readJson(app, GetBeratungsgraphPath(app), g)
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// BuildBeratungsgraph links the stored Vorlagen with the TOPs of the stored Sitzungen, adds the planned
+// Beratungen of the Vorlagen and stores the Beratungsgraph. Unreadable models are skipped.
+func BuildBeratungsgraph(app *App) (*Beratungsgraph, error) {
+
+	m := newBeratungsgraphModels()
+	objects, err := listJsons(app, app.Config.GetSitzungenFolder())
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing sitzungen")
+	}
+	for _, attrs := range objects {
+		m.readSitzung(app, attrs.Name)
+	}
+
+	objects, err = listJsons(app, app.Config.GetTopFolder())
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing tops")
+	}
+	for _, attrs := range objects {
+		m.readTop(app, attrs.Name)
+	}
+
+	objects, err = listJsons(app, app.Config.GetVorlagenFolder())
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing vorlagen")
+	}
+	for _, attrs := range objects {
+		m.readVorlage(app, attrs.Name)
+	}
+
+	g := &Beratungsgraph{
+		Vorlagen: make(map[int][]Beratungsschritt),
+		Tops:     make(map[int]int),
+	}
+	g.link(app, m, nil)
+
+	err = writeJson(app, GetBeratungsgraphPath(app), time.Now(), g)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error writing %s", GetBeratungsgraphPath(app)))
+	}
+	return g, nil
+}
+
+// updateBeratungsgraph relinks the Vorlagen of the Sitzungen, TOPs and Vorlagen written since the last update
+// in the stored Beratungsgraph, it is built from all stored models if none is stored
+func updateBeratungsgraph(app *App) error {
+
+	events := app.beratungen.take()
+	if len(events) == 0 {
+		return nil
+	}
+
+	g, err := LoadBeratungsgraph(app)
+	if err != nil {
+		slog.Info("building beratungsgraph from all models: %v", err)
+		_, err = BuildBeratungsgraph(app)
+		return err
+	}
+	if g.Vorlagen == nil {
+		g.Vorlagen = make(map[int][]Beratungsschritt)
+	}
+	if g.Tops == nil {
+		g.Tops = make(map[int]int)
+	}
+
+	// the Vorlagen linked before or now with the changed models
+	m := newBeratungsgraphModels()
+	affected := make(map[int]bool)
+	for _, event := range events {
+		switch event.DocumentType {
+		case DocumentVorlage:
+			affected[risIdOf(event.Path, app.Config.GetVorlageType())] = true
+		case DocumentSitzung:
+			silfdnr := risIdOf(event.Path, app.Config.GetSitzungType())
+			for volfdnr, schritte := range g.Vorlagen {
+				for _, schritt := range schritte {
+					if schritt.SILFDNR == silfdnr {
+						affected[volfdnr] = true
+					}
+				}
+			}
+			m.readSitzungWithTops(app, silfdnr)
+		case DocumentTop:
+			if volfdnr := g.Tops[risIdOf(event.Path, app.Config.GetTopType())]; volfdnr > 0 {
+				affected[volfdnr] = true
+			}
+			if t := m.readTop(app, event.Path); t != nil {
+				m.readSitzungWithTops(app, t.SILFDNR)
+			}
+		}
+	}
+	for _, s := range m.sitzungen {
+		for _, info := range s.Tops {
+			affected[info.VOLFDNR] = true
+		}
+	}
+	for _, t := range m.tops {
+		affected[t.VOLFDNR] = true
+	}
+	delete(affected, 0)
+
+	// the other Sitzungen of the affected Vorlagen are needed to relink them
+	for volfdnr := range affected {
+		for _, schritt := range g.Vorlagen[volfdnr] {
+			m.readSitzungWithTops(app, schritt.SILFDNR)
+		}
+		path := fmt.Sprintf("%s%s-%d%s", app.Config.GetVorlagenFolder(), app.Config.GetVorlageType(), volfdnr, jsonEnding)
+		if _, err = app.Fetched.Attrs(path); err == nil {
+			m.readVorlage(app, path)
+		}
+		delete(g.Vorlagen, volfdnr)
+	}
+	for tolfdnr, volfdnr := range g.Tops {
+		if affected[volfdnr] {
+			delete(g.Tops, tolfdnr)
+		}
+	}
+	g.link(app, m, affected)
+
+	err = writeJson(app, GetBeratungsgraphPath(app), time.Now(), g)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error writing %s", GetBeratungsgraphPath(app)))
+	}
+	slog.Info("updated beratungsfolgen of %d vorlagen", len(affected))
+	return nil
+}
+
+// beratungsgraphModels are the read models of the Sitzungen, TOPs and Vorlagen to link
+type beratungsgraphModels struct {
+	sitzungen map[int]*Sitzung
+	tops      map[int]*Top
+	vorlagen  []*Vorlage
+	read      map[string]bool
+}
+
+func newBeratungsgraphModels() *beratungsgraphModels {
+	return &beratungsgraphModels{
+		sitzungen: make(map[int]*Sitzung),
+		tops:      make(map[int]*Top),
+		read:      make(map[string]bool),
+	}
+}
+
+// readModel reads the json at the path once, unreadable models are logged and skipped
+func (m *beratungsgraphModels) readModel(app *App, path string, v interface{}) bool {
+
+	if m.read[path] {
+		return false
+	}
+	m.read[path] = true
+
+	err := readJson(app, path, v)
+	if err != nil {
+		slog.Warn("ignore %s in beratungsgraph: %v", path, err)
+		return false
+	}
+	return true
+}
+
+func (m *beratungsgraphModels) readSitzung(app *App, path string) {
+
+	s := &Sitzung{}
+	if !m.readModel(app, path, s) {
+		return
+	}
+	if s.SILFDNR == 0 {
+		s.SILFDNR = risIdOf(path, app.Config.GetSitzungType())
+	}
+	m.sitzungen[s.SILFDNR] = s
+}
+
+// readSitzungWithTops reads the stored Sitzung and its TOPs if they exist
+func (m *beratungsgraphModels) readSitzungWithTops(app *App, silfdnr int) {
+
+	name := fmt.Sprintf("%s-%d", app.Config.GetSitzungType(), silfdnr)
+	if silfdnr == 0 || m.read[name] {
+		return
+	}
+	m.read[name] = true
+
+	_, err := app.Fetched.Attrs(app.Config.GetSitzungenFolder() + name + jsonEnding)
+	if err == nil {
+		m.readSitzung(app, app.Config.GetSitzungenFolder()+name+jsonEnding)
+	}
+
+	objects, err := listJsons(app, app.Config.GetTopFolder()+name+"-"+app.Config.GetTopType()+"-")
+	if err != nil {
+		slog.Warn("ignore tops of %s in beratungsgraph: %v", name, err)
+		return
+	}
+	for _, attrs := range objects {
+		m.readTop(app, attrs.Name)
+	}
+}
+
+func (m *beratungsgraphModels) readTop(app *App, path string) *Top {
+
+	t := &Top{}
+	if !m.readModel(app, path, t) {
+		return nil
+	}
+	if t.TOLFDNR == 0 {
+		t.TOLFDNR = risIdOf(path, app.Config.GetTopType())
+	}
+	if t.SILFDNR == 0 {
+		t.SILFDNR = risIdOf(path, app.Config.GetSitzungType())
+	}
+	m.tops[t.TOLFDNR] = t
+	return t
+}
+
+func (m *beratungsgraphModels) readVorlage(app *App, path string) {
+
+	v := &Vorlage{}
+	if !m.readModel(app, path, v) {
+		return
+	}
+	if v.VOLFDNR == 0 {
+		v.VOLFDNR = risIdOf(path, app.Config.GetVorlageType())
+	}
+	m.vorlagen = append(m.vorlagen, v)
+}
+
+// link adds the Beratungen of the models to the Vorlagen in only, all Vorlagen if only is nil
+func (g *Beratungsgraph) link(app *App, m *beratungsgraphModels, only map[int]bool) {
+
+	linked := make(map[int]bool)
+	for _, s := range m.sitzungen {
+		for _, info := range s.Tops {
+			volfdnr := info.VOLFDNR
+			t := m.tops[info.TOLFDNR]
+			if t != nil {
+				linked[t.TOLFDNR] = true
+				if volfdnr == 0 {
+					volfdnr = t.VOLFDNR
+				}
+			}
+			if volfdnr == 0 || (only != nil && !only[volfdnr]) {
+				continue
+			}
+			g.addTop(volfdnr, s, info.TOLFDNR, info.Nummer, t)
+		}
+	}
+	for _, t := range m.tops {
+		if linked[t.TOLFDNR] || t.VOLFDNR == 0 || (only != nil && !only[t.VOLFDNR]) {
+			continue
+		}
+		g.addTop(t.VOLFDNR, m.sitzungen[t.SILFDNR], t.TOLFDNR, t.Nummer, t)
+	}
+
+	for _, v := range m.vorlagen {
+		if only != nil && !only[v.VOLFDNR] {
+			continue
+		}
+		for _, b := range v.Beratungsfolge {
+			g.addBeratung(v.VOLFDNR, b, app.gremien.id(app, b.Gremium))
+		}
+	}
+
+	for volfdnr, schritte := range g.Vorlagen {
+		if only != nil && !only[volfdnr] {
+			continue
+		}
+		sort.Slice(schritte, func(i, j int) bool {
+			if !schritte[i].Datum.Equal(schritte[j].Datum) {
+				return schritte[i].Datum.Before(schritte[j].Datum)
+			}
+			if schritte[i].SILFDNR != schritte[j].SILFDNR {
+				return schritte[i].SILFDNR < schritte[j].SILFDNR
+			}
+			return schritte[i].TOLFDNR < schritte[j].TOLFDNR
+		})
+		g.Vorlagen[volfdnr] = schritte
+	}
+}
+
+// addTop adds the Beratung of the Vorlage in the TOP of the Sitzung, the Sitzung and the Top may be nil if
+// they are not stored
+func (g *Beratungsgraph) addTop(volfdnr int, s *Sitzung, tolfdnr int, nummer string, t *Top) {
+
+	schritt := Beratungsschritt{
+		TOLFDNR: tolfdnr,
+		Top:     nummer,
+	}
+	if s != nil {
+		schritt.SILFDNR = s.SILFDNR
+		schritt.GremiumID = s.GremiumID
+		schritt.Gremium = s.Gremium
+		schritt.Datum = s.Start
+	}
+	if t != nil {
+		if schritt.SILFDNR == 0 {
+			schritt.SILFDNR = t.SILFDNR
+		}
+		schritt.Beschlussart = t.Beschlussart
+		schritt.Beschlusstext = t.Beschlusstext
+		schritt.Abstimmung = t.Abstimmung
+	}
+
+	if tolfdnr > 0 {
+		g.Tops[tolfdnr] = volfdnr
+	}
+	g.Vorlagen[volfdnr] = append(g.Vorlagen[volfdnr], schritt)
+}
+
+// addBeratung adds the Beratung of the Beratungsfolge of the Vorlage or completes the TOP of its Sitzung
+func (g *Beratungsgraph) addBeratung(volfdnr int, b Beratung, gremiumID int) {
+
+	schritte := g.Vorlagen[volfdnr]
+	for i := range schritte {
+		s := &schritte[i]
+		sameSitzung := b.SILFDNR > 0 && s.SILFDNR == b.SILFDNR
+		sameDay := b.SILFDNR == 0 && strings.EqualFold(s.Gremium, b.Gremium) && sameDate(s.Datum, b.Datum)
+		if sameSitzung || sameDay {
+			s.Rolle = b.Rolle
+			if s.Beschlussart == "" {
+				s.Beschlussart = b.Beschlussart
+			}
+			if s.Gremium == "" {
+				s.Gremium = b.Gremium
+				s.GremiumID = gremiumID
+			}
+			if s.Datum.IsZero() {
+				s.Datum = b.Datum
+			}
+			return
+		}
+	}
+
+	g.Vorlagen[volfdnr] = append(schritte, Beratungsschritt{
+		SILFDNR:      b.SILFDNR,
+		GremiumID:    gremiumID,
+		Gremium:      b.Gremium,
+		Datum:        b.Datum,
+		Rolle:        b.Rolle,
+		Beschlussart: b.Beschlussart,
+	})
+}
+
+func sameDate(a time.Time, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
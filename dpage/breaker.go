@@ -0,0 +1,144 @@
+package dpage
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-common/common/slog"
+	"sync"
+	"time"
+)
+
+const defaultBreakerThreshold = 5
+const defaultBreakerPause = 5 * time.Minute
+const defaultBreakerMaxOpen = 3
+const breakerProbeWait = 100 * time.Millisecond
+
+var ErrCircuitOpen = errors.New("circuit breaker open, the RIS seems to be down")
+
+// CircuitBreakerConfig can be implemented by the Config to set after how many consecutive failed requests
+// the sync is paused, how long, and after how many pauses without a successful request the fetches fail
+type CircuitBreakerConfig interface {
+	GetCircuitBreakerThreshold() int
+	GetCircuitBreakerPause() time.Duration
+	GetCircuitBreakerMaxOpen() int
+}
+
+// circuitBreaker pauses all fetches after threshold consecutive failures. After the pause a single probe
+// request is let through, if it fails the breaker opens again. After maxOpen pauses the fetches fail with
+// ErrCircuitOpen instead of waiting, each pause a probe is still let through, so the Fetcher shared by the
+// process recovers when the RIS is back.
+type circuitBreaker struct {
+	mutex     sync.Mutex
+	threshold int
+	pause     time.Duration
+	maxOpen   int
+	failures  int
+	opens     int
+	openUntil time.Time
+	probing   bool
+}
+
+func newCircuitBreaker(conf allris_common.Config) *circuitBreaker {
+
+	cb := &circuitBreaker{
+		threshold: defaultBreakerThreshold,
+		pause:     defaultBreakerPause,
+		maxOpen:   defaultBreakerMaxOpen,
+	}
+	if bc, ok := conf.(CircuitBreakerConfig); ok {
+		if bc.GetCircuitBreakerThreshold() > 0 {
+			cb.threshold = bc.GetCircuitBreakerThreshold()
+		}
+		if bc.GetCircuitBreakerPause() > 0 {
+			cb.pause = bc.GetCircuitBreakerPause()
+		}
+		if bc.GetCircuitBreakerMaxOpen() > 0 {
+			cb.maxOpen = bc.GetCircuitBreakerMaxOpen()
+		}
+	}
+	return cb
+}
+
+// wait blocks while the breaker is open or another request probes the RIS, after maxOpen pauses it fails
+// instead of blocking
+func (cb *circuitBreaker) wait(ctx context.Context) error {
+
+	for {
+		cb.mutex.Lock()
+		var delay time.Duration
+		if now := time.Now(); now.Before(cb.openUntil) {
+			delay = cb.openUntil.Sub(now)
+		} else if cb.probing {
+			delay = breakerProbeWait
+		} else {
+			cb.probing = cb.opens > 0
+			cb.mutex.Unlock()
+			return nil
+		}
+		if cb.opens > cb.maxOpen {
+			cb.mutex.Unlock()
+			return ErrCircuitOpen
+		}
+		cb.mutex.Unlock()
+
+		err := sleep(ctx, delay)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (cb *circuitBreaker) success() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.opens > 0 {
+		slog.Info("circuit breaker closed, RIS is reachable again")
+	}
+	cb.failures = 0
+	cb.opens = 0
+	cb.probing = false
+}
+
+func (cb *circuitBreaker) failure() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.failures++
+	if cb.probing || cb.failures >= cb.threshold {
+		if cb.opens <= cb.maxOpen {
+			cb.opens++
+		}
+		cb.failures = 0
+		cb.probing = false
+		cb.openUntil = time.Now().Add(cb.pause)
+		if cb.opens > cb.maxOpen {
+			slog.Warn("circuit breaker open, failing all requests for %s until a probe succeeds", cb.pause)
+		} else {
+			slog.Warn("circuit breaker open (%d/%d), pausing all requests for %s", cb.opens, cb.maxOpen, cb.pause)
+		}
+	}
+}
+
+// cancel ends a probe which neither reached the RIS nor failed, e.g. because its context is done
+func (cb *circuitBreaker) cancel() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.probing = false
+}
+
+// sleep waits for d or until the context is done
+func sleep(ctx context.Context, d time.Duration) error {
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,71 @@
+package dpage_test
+
+import (
+	"context"
+	"github.com/rismaster/allris-dpage/dpage"
+	"github.com/rismaster/allris-dpage/dpage/dpagetest"
+	"testing"
+	"time"
+)
+
+const testBreakerPause = 50 * time.Millisecond
+
+// breakerConfig opens the circuit breaker after two failures for a short pause and fails after one pause
+type breakerConfig struct {
+	*dpagetest.Config
+}
+
+func (c *breakerConfig) GetCircuitBreakerThreshold() int       { return 2 }
+func (c *breakerConfig) GetCircuitBreakerPause() time.Duration { return testBreakerPause }
+func (c *breakerConfig) GetCircuitBreakerMaxOpen() int         { return 1 }
+
+// assertWait checks the result of a wait which has to return within timeout
+func assertWait(t *testing.T, step string, cb dpage.CircuitBreaker, timeout time.Duration, want error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := cb.Wait(ctx); err != want {
+		t.Fatalf("%s: wait returns %v, want %v", step, err, want)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+
+	cb := dpage.NewCircuitBreaker(&breakerConfig{Config: dpagetest.NewConfig("")})
+
+	assertWait(t, "closed", cb, time.Second, nil)
+	cb.Failure()
+	assertWait(t, "below threshold", cb, time.Second, nil)
+
+	cb.Failure()
+	assertWait(t, "open", cb, testBreakerPause/5, context.DeadlineExceeded)
+
+	start := time.Now()
+	assertWait(t, "probe after pause", cb, time.Second, nil)
+	if waited := time.Since(start); waited < testBreakerPause/2 {
+		t.Errorf("probe waited %s for the pause of %s", waited, testBreakerPause)
+	}
+	assertWait(t, "while probing", cb, testBreakerPause/5, context.DeadlineExceeded)
+
+	// the failed probe opens the breaker beyond maxOpen, now the fetches fail instead of waiting
+	cb.Failure()
+	assertWait(t, "after max open", cb, time.Second, dpage.ErrCircuitOpen)
+
+	time.Sleep(testBreakerPause + 10*time.Millisecond)
+	assertWait(t, "half-open probe", cb, time.Second, nil)
+	assertWait(t, "while half-open", cb, time.Second, dpage.ErrCircuitOpen)
+
+	// a cancelled probe lets the next request probe
+	cb.Cancel()
+	assertWait(t, "after cancelled probe", cb, time.Second, nil)
+
+	cb.Success()
+	assertWait(t, "closed again", cb, time.Second, nil)
+	assertWait(t, "closed without probe", cb, time.Second, nil)
+
+	// after success the breaker counts the failures and pauses from the start
+	cb.Failure()
+	assertWait(t, "first failure after success", cb, time.Second, nil)
+	cb.Failure()
+	assertWait(t, "open again", cb, testBreakerPause/5, context.DeadlineExceeded)
+}
@@ -0,0 +1,351 @@
+package dpage
+
+import (
+	"database/sql"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-common/common/slog"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CatalogConfig can be implemented by the Config to record the documents of the syncs in a local SQLite
+// catalog
+type CatalogConfig interface {
+	GetCatalogPath() string
+}
+
+const catalogSchema = `
+CREATE TABLE IF NOT EXISTS documents (
+	path         TEXT PRIMARY KEY,
+	type         TEXT NOT NULL,
+	ris_id       INTEGER NOT NULL,
+	parent       TEXT NOT NULL,
+	url          TEXT NOT NULL,
+	hash         TEXT NOT NULL,
+	size         INTEGER NOT NULL,
+	created      DATETIME NOT NULL,
+	first_seen   DATETIME NOT NULL,
+	last_seen    DATETIME NOT NULL,
+	last_changed DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS documents_type_ris_id ON documents (type, ris_id);
+CREATE INDEX IF NOT EXISTS documents_parent ON documents (parent);
+`
+
+const catalogColumns = "path, type, ris_id, parent, url, hash, size, created, first_seen, last_seen, last_changed"
+
+// Catalog records every page and Anlage written or confirmed by a sync with its RIS id and parent, the times
+// are stored in UTC
+type Catalog struct {
+	mutex sync.Mutex
+	db    *sql.DB
+}
+
+// CatalogEntry is a document of the Catalog, Parent is the path of the page it was linked from
+type CatalogEntry struct {
+	Path        string
+	Type        string
+	RisId       int
+	Parent      string
+	Url         string
+	Hash        string
+	Size        int64
+	Created     time.Time
+	FirstSeen   time.Time
+	LastSeen    time.Time
+	LastChanged time.Time
+}
+
+// CatalogStats are the number and size of the documents of a type
+type CatalogStats struct {
+	Type        string
+	Count       int
+	Size        int64
+	LastChanged time.Time
+}
+
+// CatalogCheck lists the differences of the Catalog and the fetched store: documents missing in the store,
+// stored documents missing in the Catalog and documents with another hash in the store
+type CatalogCheck struct {
+	Missing      []string
+	Unrecorded   []string
+	HashMismatch []string
+}
+
+// OK is true if the Catalog matches the fetched store
+func (check *CatalogCheck) OK() bool {
+	return len(check.Missing) == 0 && len(check.Unrecorded) == 0 && len(check.HashMismatch) == 0
+}
+
+// OpenCatalog opens or creates the SQLite catalog at the path
+func OpenCatalog(dbPath string) (*Catalog, error) {
+
+	db, err := sql.Open("sqlite3", dbPath+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error opening catalog %s", dbPath))
+	}
+	// the writes are serialized by the mutex, one connection keeps an in-memory catalog alive
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(catalogSchema)
+	if err != nil {
+		_ = db.Close()
+		return nil, errors.Wrap(err, fmt.Sprintf("error creating schema of catalog %s", dbPath))
+	}
+	return &Catalog{db: db}, nil
+}
+
+// NewMemCatalog creates a catalog in memory, e.g. for a one time consistency check
+func NewMemCatalog() (*Catalog, error) {
+	return OpenCatalog(":memory:")
+}
+
+func newCatalog(conf allris_common.Config) (*Catalog, error) {
+	cc, ok := conf.(CatalogConfig)
+	if !ok || cc.GetCatalogPath() == "" {
+		return nil, nil
+	}
+	return OpenCatalog(cc.GetCatalogPath())
+}
+
+func (c *Catalog) Close() error {
+	return c.db.Close()
+}
+
+// transaction runs f in a transaction which is committed if f returns no error
+func (c *Catalog) transaction(f func(tx *sql.Tx) error) error {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "error starting catalog transaction")
+	}
+	err = f(tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// record notes the file as seen by the sync, changed is true if its content was written. Files which are no
+// documents like the json models or the list pages are not recorded.
+func (c *Catalog) record(app *App, file *File, changed bool) {
+
+	if c == nil || !catalogued(app, file.GetPath()) {
+		return
+	}
+
+	documentType, typeName := documentType(app, file.GetPath())
+	now := time.Now().UTC()
+	err := c.transaction(func(tx *sql.Tx) error {
+
+		var lastChanged time.Time
+		row := tx.QueryRow("SELECT last_changed FROM documents WHERE path = ?", file.GetPath())
+		err := row.Scan(&lastChanged)
+		if err == sql.ErrNoRows || changed {
+			lastChanged = now
+		} else if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO documents (`+catalogColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (path) DO UPDATE SET type = excluded.type, ris_id = excluded.ris_id, parent = excluded.parent,
+				url = CASE WHEN excluded.url = '' THEN documents.url ELSE excluded.url END, hash = excluded.hash,
+				size = excluded.size, created = excluded.created, last_seen = excluded.last_seen,
+				last_changed = excluded.last_changed`,
+			file.GetPath(), documentType, documentRisId(app, file.GetPath(), typeName), parentOf(app, file.GetPath()), file.url,
+			file.hash, len(file.content), file.risTime.UTC(), now, now, lastChanged.UTC())
+		return err
+	})
+	if err != nil {
+		slog.Error("error recording %s in catalog: %v", file.GetPath(), err)
+	}
+}
+
+// remove deletes the document moved to the tombstones from the catalog
+func (c *Catalog) remove(filePath string) {
+
+	if c == nil {
+		return
+	}
+
+	err := c.transaction(func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM documents WHERE path = ?", filePath)
+		return err
+	})
+	if err != nil {
+		slog.Error("error removing %s from catalog: %v", filePath, err)
+	}
+}
+
+// Get returns the document at the path, nil if it is not in the catalog
+func (c *Catalog) Get(filePath string) (*CatalogEntry, error) {
+
+	entries, err := c.query("SELECT "+catalogColumns+" FROM documents WHERE path = ?", filePath)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	return entries[0], nil
+}
+
+// Find returns the documents of the type with the RIS id, e.g. the page of a Sitzung with its SILFDNR
+func (c *Catalog) Find(documentType string, risId int) ([]*CatalogEntry, error) {
+	return c.query("SELECT "+catalogColumns+" FROM documents WHERE type = ? AND ris_id = ? ORDER BY path", documentType, risId)
+}
+
+// Children returns the documents linked from the page at the parent path
+func (c *Catalog) Children(parent string) ([]*CatalogEntry, error) {
+	return c.query("SELECT "+catalogColumns+" FROM documents WHERE parent = ? ORDER BY path", parent)
+}
+
+// Descendants returns the documents of the type linked from the page at the parent path or its children,
+// e.g. the Anlagen of a Sitzung and its TOPs. An empty documentType returns all types.
+func (c *Catalog) Descendants(parent string, documentType string) ([]*CatalogEntry, error) {
+	return c.query(`WITH RECURSIVE tree(path) AS (
+			SELECT path FROM documents WHERE parent = ?
+			UNION SELECT d.path FROM documents d JOIN tree ON d.parent = tree.path
+		)
+		SELECT `+catalogColumns+` FROM documents WHERE path IN (SELECT path FROM tree) AND (? = '' OR type = ?)
+		ORDER BY path`, parent, documentType, documentType)
+}
+
+// ChangedSince returns the documents whose content changed after the time
+func (c *Catalog) ChangedSince(since time.Time) ([]*CatalogEntry, error) {
+	return c.query("SELECT "+catalogColumns+" FROM documents WHERE last_changed > ? ORDER BY last_changed", since.UTC())
+}
+
+// Stats returns the number and size of the documents per type
+func (c *Catalog) Stats() ([]CatalogStats, error) {
+
+	rows, err := c.db.Query("SELECT type, COUNT(*), SUM(size), MAX(last_changed) FROM documents GROUP BY type ORDER BY type")
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying catalog stats")
+	}
+	defer rows.Close()
+
+	var result []CatalogStats
+	for rows.Next() {
+		var stats CatalogStats
+		var lastChanged string
+		err = rows.Scan(&stats.Type, &stats.Count, &stats.Size, &lastChanged)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading catalog stats")
+		}
+		stats.LastChanged = parseCatalogTime(lastChanged)
+		result = append(result, stats)
+	}
+	return result, rows.Err()
+}
+
+// Check compares the catalog with the pages and Anlagen of the fetched store
+func (c *Catalog) Check(app *App) (*CatalogCheck, error) {
+
+	stored, err := app.Fetched.List("")
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing fetched store")
+	}
+	entries, err := c.query("SELECT " + catalogColumns + " FROM documents ORDER BY path")
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := make(map[string]*CatalogEntry)
+	for _, e := range entries {
+		recorded[e.Path] = e
+	}
+
+	check := &CatalogCheck{}
+	for _, attrs := range stored {
+		if !catalogued(app, attrs.Name) {
+			continue
+		}
+		e, ok := recorded[attrs.Name]
+		if !ok {
+			check.Unrecorded = append(check.Unrecorded, attrs.Name)
+			continue
+		}
+		delete(recorded, attrs.Name)
+		if e.Hash != attrs.Hash {
+			check.HashMismatch = append(check.HashMismatch, attrs.Name)
+		}
+	}
+	for _, e := range entries {
+		if _, ok := recorded[e.Path]; ok {
+			check.Missing = append(check.Missing, e.Path)
+		}
+	}
+	return check, nil
+}
+
+func (c *Catalog) query(query string, args ...interface{}) ([]*CatalogEntry, error) {
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying catalog")
+	}
+	defer rows.Close()
+
+	var result []*CatalogEntry
+	for rows.Next() {
+		e := &CatalogEntry{}
+		err = rows.Scan(&e.Path, &e.Type, &e.RisId, &e.Parent, &e.Url, &e.Hash, &e.Size, &e.Created, &e.FirstSeen, &e.LastSeen, &e.LastChanged)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading catalog entry")
+		}
+		result = append(result, e)
+	}
+	return result, rows.Err()
+}
+
+// parseCatalogTime reads a time of an aggregate, which is returned as text by sqlite
+func parseCatalogTime(value string) time.Time {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999-07:00", time.RFC3339Nano} {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// catalogued is true for the stored files recorded in the catalog: the pages and Anlagen, not the list pages
+// and the models, texts and Kalender written from them
+func catalogued(app *App, filePath string) bool {
+	documentType, _ := documentType(app, filePath)
+	return documentType != "" && documentType != DocumentKalender && !strings.HasSuffix(filePath, jsonEnding)
+}
+
+// parentOf is the path of the page a TOP or Anlage was linked from, its name is the prefix of their names,
+// e.g. sitzung-1001-top-20002.html for sitzung-1001-top-20002-anlagedoc-55501-1.pdf
+func parentOf(app *App, filePath string) string {
+
+	_, name := path.Split(filePath)
+	end := -1
+	for _, typeName := range []string{app.Config.GetTopType(), app.Config.GetAnlageType(), app.Config.GetAnlageDocumentType()} {
+		if i := strings.LastIndex(name, "-"+typeName+"-"); i > end {
+			end = i
+		}
+	}
+	if end <= 0 {
+		return ""
+	}
+
+	parent := name[:end]
+	switch {
+	case strings.Contains(parent, "-"+app.Config.GetTopType()+"-"):
+		return app.Config.GetTopFolder() + parent + htmlEnding
+	case strings.HasPrefix(parent, app.Config.GetVorlageType()+"-"):
+		return app.Config.GetVorlagenFolder() + parent + htmlEnding
+	case strings.HasPrefix(parent, app.Config.GetSitzungType()+"-"):
+		return app.Config.GetSitzungenFolder() + parent + htmlEnding
+	}
+	return ""
+}
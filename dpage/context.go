@@ -0,0 +1,47 @@
+package dpage
+
+import (
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/downloader"
+)
+
+// The constructors of the package take an *App instead of the allris-common AppContext. Callers of the old
+// constructors, e.g. NewVorlagenliste(appContext), switch to the constructors below with the suffix FromContext,
+// they use the Cloud Storage buckets of the AppContext like NewAppFromContext. New code should create an App
+// once with NewApp or NewAppWithConfig, pass it and Close it after the sync.
+
+func NewVorlagenlisteFromContext(appContext *application.AppContext) Vorlagenliste {
+	return NewVorlagenliste(NewAppFromContext(appContext))
+}
+
+func NewSitzungslisteFromContext(appContext *application.AppContext) Sitzungsliste {
+	return NewSitzungsliste(NewAppFromContext(appContext))
+}
+
+func PublishRisDownloadFromContext(appContext *application.AppContext, risArr []downloader.RisRessource) error {
+	return PublishRisDownload(NewAppFromContext(appContext), risArr)
+}
+
+func NewVorlageFromContext(appContext *application.AppContext, ris *downloader.RisRessource) *AnlageContainer {
+	return NewVorlage(NewAppFromContext(appContext), ris)
+}
+
+func NewSitzungFromContext(appContext *application.AppContext, ris *downloader.RisRessource) *AnlageContainer {
+	return NewSitzung(NewAppFromContext(appContext), ris)
+}
+
+func NewTopFromContext(appContext *application.AppContext, ris *downloader.RisRessource) *AnlageContainer {
+	return NewTop(NewAppFromContext(appContext), ris)
+}
+
+func NewAnlageContainerFromContext(appContext *application.AppContext, ris *downloader.RisRessource) *AnlageContainer {
+	return NewAnlageContainer(NewAppFromContext(appContext), ris)
+}
+
+func NewAnlageFromContext(appContext *application.AppContext, ris *downloader.RisRessource) *Anlage {
+	return NewAnlage(NewAppFromContext(appContext), ris)
+}
+
+func NewAnlageDocumentFromContext(appContext *application.AppContext, ris *downloader.RisRessource) *AnlageDocument {
+	return NewAnlageDocument(NewAppFromContext(appContext), ris)
+}
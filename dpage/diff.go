@@ -0,0 +1,368 @@
+package dpage
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+// the kinds of a Change
+const ChangeTopAdded = "top_added"
+const ChangeTopRemoved = "top_removed"
+const ChangeTopMoved = "top_moved"
+const ChangeTopBetreff = "top_betreff"
+const ChangeBetreff = "betreff"
+const ChangeStart = "start"
+const ChangeEnde = "ende"
+const ChangeOrt = "ort"
+const ChangeRaum = "raum"
+const ChangeStatus = "status"
+const ChangeArt = "art"
+const ChangeAnlageAdded = "anlage_added"
+const ChangeAnlageRemoved = "anlage_removed"
+const ChangeBeschlussart = "beschlussart"
+const ChangeBeschlusstext = "beschlusstext"
+const ChangeAbstimmung = "abstimmung"
+const ChangeBeratungAdded = "beratung_added"
+const ChangeBeratungRemoved = "beratung_removed"
+const ChangeBeratungBeschluss = "beratung_beschluss"
+
+const diffDateFormat = "02.01.2006"
+const diffTimeFormat = "15:04"
+
+// Change is a single difference between two revisions. Subject is the changed part of the document like
+// the number of a TOP or the name of an Anlage.
+type Change struct {
+	Kind    string `json:"kind"`
+	Subject string `json:"subject,omitempty"`
+	Old     string `json:"old,omitempty"`
+	New     string `json:"new,omitempty"`
+}
+
+// Summary is the change as German text, e.g. "TOP 7 neu: Haushalt 2022"
+func (c Change) Summary() string {
+
+	switch c.Kind {
+	case ChangeTopAdded:
+		return fmt.Sprintf("TOP %s neu: %s", c.Subject, c.New)
+	case ChangeTopRemoved:
+		return fmt.Sprintf("TOP %s entfernt: %s", c.Subject, c.Old)
+	case ChangeTopMoved:
+		return fmt.Sprintf("TOP %s verschoben auf TOP %s: %s", c.Old, c.New, c.Subject)
+	case ChangeTopBetreff:
+		return fmt.Sprintf("TOP %s Betreff geändert von „%s“ auf „%s“", c.Subject, c.Old, c.New)
+	case ChangeBetreff:
+		return fmt.Sprintf("Betreff geändert von „%s“ auf „%s“", c.Old, c.New)
+	case ChangeStart:
+		return fmt.Sprintf("Sitzung verschoben von %s auf %s", c.Old, c.New)
+	case ChangeEnde:
+		return fmt.Sprintf("Ende geändert von %s auf %s", orNone(c.Old), orNone(c.New))
+	case ChangeOrt:
+		return fmt.Sprintf("Ort geändert von „%s“ auf „%s“", c.Old, c.New)
+	case ChangeRaum:
+		return fmt.Sprintf("Raum geändert von „%s“ auf „%s“", c.Old, c.New)
+	case ChangeStatus:
+		return fmt.Sprintf("Status geändert von „%s“ auf „%s“", c.Old, c.New)
+	case ChangeArt:
+		return fmt.Sprintf("Vorlagenart geändert von „%s“ auf „%s“", c.Old, c.New)
+	case ChangeAnlageAdded:
+		return fmt.Sprintf("Anlage neu: %s", c.Subject)
+	case ChangeAnlageRemoved:
+		return fmt.Sprintf("Anlage entfernt: %s", c.Subject)
+	case ChangeBeschlussart:
+		return fmt.Sprintf("%sBeschluss geändert von „%s“ auf „%s“", topPrefix(c.Subject), c.Old, c.New)
+	case ChangeBeschlusstext:
+		return fmt.Sprintf("%sBeschlusstext geändert", topPrefix(c.Subject))
+	case ChangeAbstimmung:
+		return fmt.Sprintf("%sAbstimmung geändert von %s auf %s", topPrefix(c.Subject), orNone(c.Old), orNone(c.New))
+	case ChangeBeratungAdded:
+		return fmt.Sprintf("Beratung neu: %s", c.Subject)
+	case ChangeBeratungRemoved:
+		return fmt.Sprintf("Beratung entfernt: %s", c.Subject)
+	case ChangeBeratungBeschluss:
+		return fmt.Sprintf("Beratung %s: Beschluss geändert von „%s“ auf „%s“", c.Subject, c.Old, c.New)
+	}
+	return fmt.Sprintf("%s geändert", c.Kind)
+}
+
+func topPrefix(nummer string) string {
+	if nummer == "" {
+		return ""
+	}
+	return "TOP " + nummer + ": "
+}
+
+func orNone(text string) string {
+	if text == "" {
+		return "keine Angabe"
+	}
+	return text
+}
+
+// ChangeSet are the changes of a document between two revisions
+type ChangeSet struct {
+	Path        string   `json:"path"`
+	OldRevision int      `json:"oldRevision"`
+	NewRevision int      `json:"newRevision"`
+	Changes     []Change `json:"changes"`
+}
+
+func (cs *ChangeSet) Empty() bool {
+	return len(cs.Changes) == 0
+}
+
+// Summary is the German text of all changes, one per line
+func (cs *ChangeSet) Summary() string {
+	lines := make([]string, 0, len(cs.Changes))
+	for _, c := range cs.Changes {
+		lines = append(lines, c.Summary())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// DiffRevisions compares two revisions of a stored Sitzung, TOP or Vorlage
+func DiffRevisions(app *App, path string, oldNumber int, newNumber int) (*ChangeSet, error) {
+
+	oldContent, _, err := ReadRevision(app, path, oldNumber)
+	if err != nil {
+		return nil, err
+	}
+	newContent, _, err := ReadRevision(app, path, newNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := diffDocuments(app, path, oldContent, newContent)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error comparing revisions %d and %d of %s", oldNumber, newNumber, path))
+	}
+	return &ChangeSet{
+		Path:        path,
+		OldRevision: oldNumber,
+		NewRevision: newNumber,
+		Changes:     changes,
+	}, nil
+}
+
+// diffDocuments parses both html pages by the folder of path and compares them
+func diffDocuments(app *App, path string, oldContent []byte, newContent []byte) ([]Change, error) {
+
+	dates, err := app.Dates()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.HasPrefix(path, app.Config.GetSitzungenFolder()):
+		oldSitzung, err := ParseSitzung(oldContent, dates)
+		if err != nil {
+			return nil, err
+		}
+		newSitzung, err := ParseSitzung(newContent, dates)
+		if err != nil {
+			return nil, err
+		}
+		return DiffSitzung(oldSitzung, newSitzung), nil
+
+	case strings.HasPrefix(path, app.Config.GetTopFolder()):
+		oldTop, err := ParseTop(oldContent)
+		if err != nil {
+			return nil, err
+		}
+		newTop, err := ParseTop(newContent)
+		if err != nil {
+			return nil, err
+		}
+		return DiffTop(oldTop, newTop), nil
+
+	case strings.HasPrefix(path, app.Config.GetVorlagenFolder()):
+		oldVorlage, err := ParseVorlage(oldContent, dates)
+		if err != nil {
+			return nil, err
+		}
+		newVorlage, err := ParseVorlage(newContent, dates)
+		if err != nil {
+			return nil, err
+		}
+		return DiffVorlage(oldVorlage, newVorlage), nil
+	}
+
+	return nil, errors.Wrap(ErrUnknownFolder, path)
+}
+
+// DiffSitzung compares the date, place, Tagesordnung and Anlagen of two versions of a Sitzung
+func DiffSitzung(old *Sitzung, new *Sitzung) (changes []Change) {
+
+	if !old.Start.Equal(new.Start) {
+		oldText, newText := formatMoved(old.Start, new.Start)
+		changes = append(changes, Change{Kind: ChangeStart, Old: oldText, New: newText})
+	}
+	if !old.Ende.Equal(new.Ende) {
+		changes = append(changes, Change{Kind: ChangeEnde, Old: formatOptionalTime(old.Ende, new.Ende), New: formatOptionalTime(new.Ende, old.Ende)})
+	}
+	changes = appendIfChanged(changes, ChangeBetreff, old.Bezeichnung, new.Bezeichnung)
+	changes = appendIfChanged(changes, ChangeOrt, old.Ort, new.Ort)
+	changes = appendIfChanged(changes, ChangeRaum, old.Raum, new.Raum)
+	changes = appendIfChanged(changes, ChangeStatus, old.Status, new.Status)
+	changes = append(changes, diffTops(old.Tops, new.Tops)...)
+	return append(changes, diffAnlagen(old.Anlagen, new.Anlagen)...)
+}
+
+// DiffTop compares the Betreff, the Beschluss and the Anlagen of two versions of a TOP
+func DiffTop(old *Top, new *Top) (changes []Change) {
+
+	changes = appendIfChanged(changes, ChangeBetreff, old.Betreff, new.Betreff)
+	if old.Beschlussart != new.Beschlussart {
+		changes = append(changes, Change{Kind: ChangeBeschlussart, Subject: new.Nummer, Old: old.Beschlussart, New: new.Beschlussart})
+	}
+	if old.Beschlusstext != new.Beschlusstext {
+		changes = append(changes, Change{Kind: ChangeBeschlusstext, Subject: new.Nummer, Old: old.Beschlusstext, New: new.Beschlusstext})
+	}
+	if formatAbstimmung(old.Abstimmung) != formatAbstimmung(new.Abstimmung) {
+		changes = append(changes, Change{Kind: ChangeAbstimmung, Subject: new.Nummer, Old: formatAbstimmung(old.Abstimmung), New: formatAbstimmung(new.Abstimmung)})
+	}
+	return append(changes, diffAnlagen(old.Anlagen, new.Anlagen)...)
+}
+
+// DiffVorlage compares the Betreff, the Beratungsfolge and the Anlagen of two versions of a Vorlage
+func DiffVorlage(old *Vorlage, new *Vorlage) (changes []Change) {
+
+	changes = appendIfChanged(changes, ChangeBetreff, old.Betreff, new.Betreff)
+	changes = appendIfChanged(changes, ChangeArt, old.Art, new.Art)
+	changes = appendIfChanged(changes, ChangeStatus, old.Status, new.Status)
+
+	oldBeratungen := make(map[string]Beratung)
+	for _, b := range old.Beratungsfolge {
+		oldBeratungen[beratungKey(b)] = b
+	}
+	newBeratungen := make(map[string]bool)
+	for _, b := range new.Beratungsfolge {
+		key := beratungKey(b)
+		newBeratungen[key] = true
+		previous, ok := oldBeratungen[key]
+		if !ok {
+			changes = append(changes, Change{Kind: ChangeBeratungAdded, Subject: key, New: b.Rolle})
+		} else if previous.Beschlussart != b.Beschlussart {
+			changes = append(changes, Change{Kind: ChangeBeratungBeschluss, Subject: key, Old: previous.Beschlussart, New: b.Beschlussart})
+		}
+	}
+	for _, b := range old.Beratungsfolge {
+		if !newBeratungen[beratungKey(b)] {
+			changes = append(changes, Change{Kind: ChangeBeratungRemoved, Subject: beratungKey(b), Old: b.Rolle})
+		}
+	}
+
+	return append(changes, diffAnlagen(old.Anlagen, new.Anlagen)...)
+}
+
+// diffTops finds added, removed, renumbered and renamed TOPs. TOPs are identified by TOLFDNR, without
+// by Betreff.
+func diffTops(old []TopInfo, new []TopInfo) (changes []Change) {
+
+	oldTops := make(map[string]TopInfo)
+	for _, t := range old {
+		oldTops[topKey(t)] = t
+	}
+	newTops := make(map[string]bool)
+	for _, t := range new {
+		key := topKey(t)
+		newTops[key] = true
+		previous, ok := oldTops[key]
+		if !ok {
+			changes = append(changes, Change{Kind: ChangeTopAdded, Subject: t.Nummer, New: t.Betreff})
+			continue
+		}
+		if previous.Nummer != t.Nummer {
+			changes = append(changes, Change{Kind: ChangeTopMoved, Subject: t.Betreff, Old: previous.Nummer, New: t.Nummer})
+		}
+		if previous.Betreff != t.Betreff {
+			changes = append(changes, Change{Kind: ChangeTopBetreff, Subject: t.Nummer, Old: previous.Betreff, New: t.Betreff})
+		}
+	}
+	for _, t := range old {
+		if !newTops[topKey(t)] {
+			changes = append(changes, Change{Kind: ChangeTopRemoved, Subject: t.Nummer, Old: t.Betreff})
+		}
+	}
+	return changes
+}
+
+func topKey(t TopInfo) string {
+	if t.TOLFDNR > 0 {
+		return fmt.Sprintf("%d", t.TOLFDNR)
+	}
+	return t.Betreff
+}
+
+func beratungKey(b Beratung) string {
+	if b.Datum.IsZero() {
+		return b.Gremium
+	}
+	return fmt.Sprintf("%s am %s", b.Gremium, b.Datum.Format(diffDateFormat))
+}
+
+func diffAnlagen(old []AnlageInfo, new []AnlageInfo) (changes []Change) {
+
+	oldAnlagen := make(map[string]bool)
+	for _, a := range old {
+		oldAnlagen[anlageKey(a)] = true
+	}
+	newAnlagen := make(map[string]bool)
+	for _, a := range new {
+		newAnlagen[anlageKey(a)] = true
+		if !oldAnlagen[anlageKey(a)] {
+			changes = append(changes, Change{Kind: ChangeAnlageAdded, Subject: a.Name})
+		}
+	}
+	for _, a := range old {
+		if !newAnlagen[anlageKey(a)] {
+			changes = append(changes, Change{Kind: ChangeAnlageRemoved, Subject: a.Name})
+		}
+	}
+	return changes
+}
+
+func anlageKey(a AnlageInfo) string {
+	if a.DOLFDNR > 0 {
+		return fmt.Sprintf("%d", a.DOLFDNR)
+	}
+	if a.Href != "" {
+		return a.Href
+	}
+	return a.Name
+}
+
+func appendIfChanged(changes []Change, kind string, old string, new string) []Change {
+	if old == new {
+		return changes
+	}
+	return append(changes, Change{Kind: kind, Old: old, New: new})
+}
+
+// formatMoved formats the times with date only if the day changed
+func formatMoved(old time.Time, new time.Time) (string, string) {
+	if old.Year() == new.Year() && old.YearDay() == new.YearDay() {
+		return old.Format(diffTimeFormat), new.Format(diffTimeFormat)
+	}
+	return old.Format(diffDateFormat + " " + diffTimeFormat), new.Format(diffDateFormat + " " + diffTimeFormat)
+}
+
+// formatOptionalTime formats t like formatMoved compared to other, empty for the zero time
+func formatOptionalTime(t time.Time, other time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	if other.IsZero() {
+		return t.Format(diffTimeFormat)
+	}
+	text, _ := formatMoved(t, other)
+	return text
+}
+
+func formatAbstimmung(a *Abstimmung) string {
+	if a == nil {
+		return ""
+	}
+	return fmt.Sprintf("Ja %d / Nein %d / Enthaltung %d", a.Ja, a.Nein, a.Enthaltung)
+}
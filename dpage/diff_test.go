@@ -0,0 +1,128 @@
+package dpage_test
+
+import (
+	"github.com/rismaster/allris-dpage/dpage"
+	"strings"
+	"testing"
+)
+
+func TestDiffSitzung(t *testing.T) {
+
+	page := string(fixture(t, "si010-1001.html"))
+
+	tests := []struct {
+		name    string
+		oldPage string
+		newPage string
+		want    []dpage.Change
+	}{
+		{
+			name:    "unchanged",
+			oldPage: page,
+			newPage: page,
+		},
+		{
+			name:    "moved",
+			oldPage: page,
+			newPage: strings.Replace(page, "Mo, 12.04.2021", "Di, 13.04.2021", 1),
+			want: []dpage.Change{
+				{Kind: dpage.ChangeStart, Old: "12.04.2021 18:00", New: "13.04.2021 18:00"},
+				{Kind: dpage.ChangeEnde, Old: "12.04.2021 20:15", New: "13.04.2021 20:15"},
+			},
+		},
+		{
+			name:    "raum",
+			oldPage: page,
+			newPage: strings.Replace(page, "Ratssaal", "Sitzungssaal", 1),
+			want:    []dpage.Change{{Kind: dpage.ChangeRaum, Old: "Ratssaal", New: "Sitzungssaal"}},
+		},
+		{
+			name:    "top removed",
+			oldPage: page,
+			newPage: strings.Replace(page, `<tr class="zl12"><td>N 3</td><td><a href="to020.asp?TOLFDNR=20003">Grundstücksangelegenheiten</a></td><td></td></tr>`, "", 1),
+			want:    []dpage.Change{{Kind: dpage.ChangeTopRemoved, Subject: "3", Old: "Grundstücksangelegenheiten"}},
+		},
+		{
+			name:    "top added",
+			oldPage: strings.Replace(page, `<tr class="zl12"><td>N 3</td><td><a href="to020.asp?TOLFDNR=20003">Grundstücksangelegenheiten</a></td><td></td></tr>`, "", 1),
+			newPage: page,
+			want:    []dpage.Change{{Kind: dpage.ChangeTopAdded, Subject: "3", New: "Grundstücksangelegenheiten"}},
+		},
+		{
+			name:    "top betreff",
+			oldPage: page,
+			newPage: strings.Replace(page, ">Eröffnung der Sitzung<", ">Eröffnung und Begrüßung<", 1),
+			want:    []dpage.Change{{Kind: dpage.ChangeTopBetreff, Subject: "1", Old: "Eröffnung der Sitzung", New: "Eröffnung und Begrüßung"}},
+		},
+	}
+
+	dates := testDates(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, err := dpage.ParseSitzung([]byte(tt.oldPage), dates)
+			if err != nil {
+				t.Fatalf("error parsing old page: %v", err)
+			}
+			new, err := dpage.ParseSitzung([]byte(tt.newPage), dates)
+			if err != nil {
+				t.Fatalf("error parsing new page: %v", err)
+			}
+			changes := dpage.DiffSitzung(old, new)
+			if len(changes) != len(tt.want) {
+				t.Fatalf("changes are %+v, want %+v", changes, tt.want)
+			}
+			for i := range changes {
+				if changes[i] != tt.want[i] {
+					t.Errorf("change %d is %+v, want %+v", i, changes[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDiffVorlage(t *testing.T) {
+
+	dates := testDates(t)
+	old, err := dpage.ParseVorlage(fixture(t, "vo020-4711.html"), dates)
+	if err != nil {
+		t.Fatalf("error parsing: %v", err)
+	}
+
+	new := *old
+	new.Betreff = "Neubau Radweg Hauptstraße, 2. Bauabschnitt"
+	new.Beratungsfolge = []dpage.Beratung{
+		{SILFDNR: 1001, Gremium: "Bau- und Umweltausschuss", Datum: berlin(t, "12.04.2021 00:00"), Rolle: "Vorberatung", Beschlussart: "geändert beschlossen"},
+		{Gremium: "Finanzausschuss", Rolle: "Vorberatung"},
+	}
+	new.Anlagen = []dpage.AnlageInfo{old.Anlagen[0], old.Anlagen[2], {Name: "Stellungnahme", Href: "ydocs/stellungnahme.pdf"}}
+
+	want := []dpage.Change{
+		{Kind: dpage.ChangeBetreff, Old: "Neubau Radweg Hauptstraße", New: "Neubau Radweg Hauptstraße, 2. Bauabschnitt"},
+		{Kind: dpage.ChangeBeratungBeschluss, Subject: "Bau- und Umweltausschuss am 12.04.2021", Old: "ungeändert beschlossen", New: "geändert beschlossen"},
+		{Kind: dpage.ChangeBeratungAdded, Subject: "Finanzausschuss", New: "Vorberatung"},
+		{Kind: dpage.ChangeBeratungRemoved, Subject: "Rat der Stadt am 29.04.2021", Old: "Entscheidung"},
+		{Kind: dpage.ChangeAnlageAdded, Subject: "Stellungnahme"},
+		{Kind: dpage.ChangeAnlageRemoved, Subject: "Kostenaufstellung"},
+	}
+	assertModel(t, dpage.DiffVorlage(old, &new), want)
+}
+
+func TestDiffTop(t *testing.T) {
+
+	old, err := dpage.ParseTop(fixture(t, "to020-20002.html"))
+	if err != nil {
+		t.Fatalf("error parsing: %v", err)
+	}
+
+	new := *old
+	new.Beschlussart = "geändert beschlossen"
+	new.Abstimmung = &dpage.Abstimmung{Ja: 10, Nein: 1, Enthaltung: 1}
+	new.Anlagen = nil
+
+	want := []dpage.Change{
+		{Kind: dpage.ChangeBeschlussart, Subject: "2", Old: "ungeändert beschlossen", New: "geändert beschlossen"},
+		{Kind: dpage.ChangeAbstimmung, Subject: "2", Old: "Ja 9 / Nein 2 / Enthaltung 1", New: "Ja 10 / Nein 1 / Enthaltung 1"},
+		{Kind: dpage.ChangeAnlageRemoved, Subject: "Lageplan"},
+	}
+	assertModel(t, dpage.DiffTop(old, &new), want)
+}
@@ -0,0 +1,421 @@
+package dpage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common"
+	"github.com/rismaster/allris-common/common/slog"
+	htmltemplate "html/template"
+	"net/mail"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const defaultSubscriptionsFolder = "subscriptions/"
+
+// DigestConfig can be implemented by the Config to send a digest mail to the subscribers after each sync.
+// The mailer is mailgun, smtp or file, the target is the address of the smtp server or the directory of the
+// mail files. The subscriptions are kept in the subscriptions folder of the backup store.
+type DigestConfig interface {
+	GetDigestMailer() string
+	GetDigestTarget() string
+	GetDigestFrom() string
+	GetSubscriptionsFolder() string
+}
+
+// Subscription selects the Vorlagen and Sitzungen of the digest of a subscriber: of the Gremien, with one
+// of the keywords in the Betreff or the Vorlagen with the VOLFDNRs
+type Subscription struct {
+	Email    string   `json:"email"`
+	Name     string   `json:"name,omitempty"`
+	Gremien  []string `json:"gremien,omitempty"`
+	Keywords []string `json:"keywords,omitempty"`
+	Vorlagen []int    `json:"vorlagen,omitempty"`
+}
+
+// DigestItem is a new or changed Vorlage or upcoming Sitzung of a digest
+type DigestItem struct {
+	Title   string
+	Gremium string
+	Datum   time.Time
+	Url     string
+	New     bool
+	Changes []string
+}
+
+// Digest is the content of the mail of a subscriber
+type Digest struct {
+	Subscription *Subscription
+	Vorlagen     []*DigestItem
+	Sitzungen    []*DigestItem
+}
+
+// digestCollector keeps the created and updated Vorlagen and Sitzungen of a sync for the digest
+type digestCollector struct {
+	mutex  sync.Mutex
+	events []*ChangeEvent
+}
+
+func (d *digestCollector) add(event *ChangeEvent) {
+
+	if d == nil || event.Type == EventDeleted {
+		return
+	}
+	if event.DocumentType != DocumentVorlage && event.DocumentType != DocumentSitzung {
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.events = append(d.events, event)
+}
+
+func (d *digestCollector) take() []*ChangeEvent {
+
+	if d == nil {
+		return nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	events := d.events
+	d.events = nil
+	return events
+}
+
+func subscriptionsFolder(app *App) string {
+	if dc, ok := app.Config.(DigestConfig); ok && dc.GetSubscriptionsFolder() != "" {
+		return dc.GetSubscriptionsFolder()
+	}
+	return defaultSubscriptionsFolder
+}
+
+func subscriptionPath(app *App, email string) string {
+	return subscriptionsFolder(app) + url.PathEscape(strings.ToLower(email)) + jsonEnding
+}
+
+// Subscribe stores the subscription, an existing one of the same email is replaced. The email has to be a
+// valid address, the display name of an address like "Erika Mustermann <erika@example.org>" is used as Name
+// if the subscription has none.
+func Subscribe(app *App, subscription *Subscription) error {
+
+	if subscription.Email == "" {
+		return errors.New("subscription without email")
+	}
+	address, err := mail.ParseAddress(subscription.Email)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("invalid email %q", subscription.Email))
+	}
+	valid := *subscription
+	valid.Email = address.Address
+	if valid.Name == "" {
+		valid.Name = address.Name
+	}
+	subscription = &valid
+
+	content, err := json.MarshalIndent(subscription, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error marshalling subscription of %s", subscription.Email))
+	}
+
+	err = app.Backup.Write(subscriptionPath(app, subscription.Email), content, &ObjectAttrs{
+		ContentType: "application/json",
+		Hash:        common.Md5HashB(content),
+		Metadata:    map[string]string{},
+	})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error writing subscription of %s", subscription.Email))
+	}
+	return nil
+}
+
+// Unsubscribe deletes the subscription of email
+func Unsubscribe(app *App, email string) error {
+
+	err := app.Backup.Delete(subscriptionPath(app, email))
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error deleting subscription of %s", email))
+	}
+	return nil
+}
+
+func ListSubscriptions(app *App) ([]*Subscription, error) {
+
+	objects, err := app.Backup.List(subscriptionsFolder(app))
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing subscriptions")
+	}
+
+	var result []*Subscription
+	for _, attrs := range objects {
+		content, errRead := app.Backup.Read(attrs.Name)
+		if errRead != nil {
+			return nil, errors.Wrap(errRead, fmt.Sprintf("error reading subscription %s", attrs.Name))
+		}
+		subscription := &Subscription{}
+		errRead = json.Unmarshal(content, subscription)
+		if errRead != nil {
+			return nil, errors.Wrap(errRead, fmt.Sprintf("error unmarshalling subscription %s", attrs.Name))
+		}
+		result = append(result, subscription)
+	}
+	return result, nil
+}
+
+func (s *Subscription) matchesGremium(gremium string) bool {
+	for _, g := range s.Gremien {
+		if strings.EqualFold(strings.TrimSpace(g), strings.TrimSpace(gremium)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Subscription) matchesBetreff(betreff string) bool {
+	betreff = strings.ToLower(betreff)
+	for _, k := range s.Keywords {
+		if k != "" && strings.Contains(betreff, strings.ToLower(k)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Subscription) matchesVolfdnr(volfdnr int) bool {
+	for _, v := range s.Vorlagen {
+		if volfdnr != 0 && v == volfdnr {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Subscription) matchesVorlage(v *Vorlage) bool {
+
+	if s.matchesVolfdnr(v.VOLFDNR) || s.matchesBetreff(v.Betreff) || s.matchesGremium(v.Federfuehrend) {
+		return true
+	}
+	for _, b := range v.Beratungsfolge {
+		if s.matchesGremium(b.Gremium) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Subscription) matchesSitzung(si *Sitzung) bool {
+
+	if s.matchesGremium(si.Gremium) {
+		return true
+	}
+	for _, t := range si.Tops {
+		if s.matchesVolfdnr(t.VOLFDNR) || s.matchesBetreff(t.Betreff) {
+			return true
+		}
+	}
+	return false
+}
+
+// SendDigests sends the Vorlagen and upcoming Sitzungen created or updated since the last digest to their
+// subscribers and returns the number of sent mails. Without Mailer the changes are dropped.
+func SendDigests(app *App) (int, error) {
+
+	events := app.digest.take()
+	if app.Mailer == nil || len(events) == 0 {
+		return 0, nil
+	}
+
+	vorlagen, sitzungen := digestDocuments(app, events)
+	if len(vorlagen) == 0 && len(sitzungen) == 0 {
+		return 0, nil
+	}
+
+	subscriptions, err := ListSubscriptions(app)
+	if err != nil {
+		return 0, err
+	}
+
+	from := ""
+	if dc, ok := app.Config.(DigestConfig); ok {
+		from = dc.GetDigestFrom()
+	}
+
+	sent := 0
+	var failed []string
+	for _, s := range subscriptions {
+
+		digest := &Digest{Subscription: s}
+		for _, v := range vorlagen {
+			if s.matchesVorlage(v.vorlage) {
+				digest.Vorlagen = append(digest.Vorlagen, v.item)
+			}
+		}
+		for _, si := range sitzungen {
+			if s.matchesSitzung(si.sitzung) {
+				digest.Sitzungen = append(digest.Sitzungen, si.item)
+			}
+		}
+		if len(digest.Vorlagen) == 0 && len(digest.Sitzungen) == 0 {
+			continue
+		}
+
+		mail, errRender := digest.render(from)
+		if errRender == nil {
+			errRender = app.Mailer.Send(mail)
+		}
+		if errRender != nil {
+			failed = append(failed, errRender.Error())
+			continue
+		}
+		slog.Info("sent digest to %s", s.Email)
+		sent++
+	}
+
+	if len(failed) > 0 {
+		return sent, errors.New(fmt.Sprintf("error sending %d digests: %s", len(failed), strings.Join(failed, "; ")))
+	}
+	return sent, nil
+}
+
+type digestVorlage struct {
+	vorlage *Vorlage
+	item    *DigestItem
+}
+
+type digestSitzung struct {
+	sitzung *Sitzung
+	item    *DigestItem
+}
+
+// digestDocuments loads the models of the changed documents, the changes of a document are found in the
+// events of its html, the model is stored next to it as json
+func digestDocuments(app *App, events []*ChangeEvent) (vorlagen []*digestVorlage, sitzungen []*digestSitzung) {
+
+	type document struct {
+		documentType string
+		created      bool
+		changes      []string
+	}
+	documents := make(map[string]*document)
+	var order []string
+	for _, e := range events {
+		modelPath := strings.TrimSuffix(e.Path, path.Ext(e.Path)) + jsonEnding
+		d, ok := documents[modelPath]
+		if !ok {
+			d = &document{documentType: e.DocumentType}
+			documents[modelPath] = d
+			order = append(order, modelPath)
+		}
+		d.created = d.created || e.Type == EventCreated
+		for _, c := range e.Changes {
+			d.changes = append(d.changes, c.Summary())
+		}
+	}
+
+	now := time.Now()
+	for _, modelPath := range order {
+		d := documents[modelPath]
+		switch d.documentType {
+		case DocumentVorlage:
+			v := &Vorlage{}
+			if err := readJson(app, modelPath, v); err != nil {
+				slog.Error("error reading vorlage of digest: %v", err)
+				continue
+			}
+			vorlagen = append(vorlagen, &digestVorlage{vorlage: v, item: &DigestItem{
+				Title:   strings.TrimSpace(v.Nummer + " " + v.Betreff),
+				Gremium: v.Federfuehrend,
+				Datum:   v.Datum,
+				Url:     app.Config.GetTargetToParse() + fmt.Sprintf(app.Config.GetUrlVorlageTmpl(), v.VOLFDNR),
+				New:     d.created,
+				Changes: d.changes,
+			}})
+		case DocumentSitzung:
+			si := &Sitzung{}
+			if err := readJson(app, modelPath, si); err != nil {
+				slog.Error("error reading sitzung of digest: %v", err)
+				continue
+			}
+			if si.Start.Before(now) {
+				continue
+			}
+			sitzungen = append(sitzungen, &digestSitzung{sitzung: si, item: &DigestItem{
+				Title:   si.Bezeichnung,
+				Gremium: si.Gremium,
+				Datum:   si.Start,
+				Url:     app.Config.GetTargetToParse() + fmt.Sprintf(app.Config.GetUrlSitzungTmpl(), si.SILFDNR),
+				New:     d.created,
+				Changes: d.changes,
+			}})
+		}
+	}
+
+	sort.SliceStable(vorlagen, func(i, j int) bool { return vorlagen[i].item.Datum.After(vorlagen[j].item.Datum) })
+	sort.SliceStable(sitzungen, func(i, j int) bool { return sitzungen[i].item.Datum.Before(sitzungen[j].item.Datum) })
+	return vorlagen, sitzungen
+}
+
+var digestFuncs = map[string]interface{}{
+	"datum": func(t time.Time) string { return t.Format("02.01.2006") },
+	"zeit":  func(t time.Time) string { return t.Format("02.01.2006 15:04") },
+}
+
+var digestText = template.Must(template.New("text").Funcs(digestFuncs).Parse(`Hallo{{with .Subscription.Name}} {{.}}{{end}},
+
+{{if .Vorlagen}}Neue und geänderte Vorlagen:
+{{range .Vorlagen}}
+- {{.Title}}{{if .New}} (neu){{end}}
+  {{with .Gremium}}{{.}}, {{end}}{{datum .Datum}}
+  {{.Url}}
+{{range .Changes}}  * {{.}}
+{{end}}{{end}}
+{{end}}{{if .Sitzungen}}Kommende Sitzungen:
+{{range .Sitzungen}}
+- {{zeit .Datum}} {{.Gremium}}: {{.Title}}{{if .New}} (neu){{end}}
+  {{.Url}}
+{{range .Changes}}  * {{.}}
+{{end}}{{end}}{{end}}`))
+
+var digestHtml = htmltemplate.Must(htmltemplate.New("html").Funcs(digestFuncs).Parse(`<html><body>
+<p>Hallo{{with .Subscription.Name}} {{.}}{{end}},</p>
+{{if .Vorlagen}}<h2>Neue und geänderte Vorlagen</h2>
+<ul>{{range .Vorlagen}}
+<li><a href="{{.Url}}">{{.Title}}</a>{{if .New}} <b>neu</b>{{end}}<br>{{with .Gremium}}{{.}}, {{end}}{{datum .Datum}}{{if .Changes}}
+<ul>{{range .Changes}}<li>{{.}}</li>{{end}}</ul>{{end}}</li>{{end}}
+</ul>{{end}}
+{{if .Sitzungen}}<h2>Kommende Sitzungen</h2>
+<ul>{{range .Sitzungen}}
+<li>{{zeit .Datum}} {{.Gremium}}: <a href="{{.Url}}">{{.Title}}</a>{{if .New}} <b>neu</b>{{end}}{{if .Changes}}
+<ul>{{range .Changes}}<li>{{.}}</li>{{end}}</ul>{{end}}</li>{{end}}
+</ul>{{end}}
+</body></html>`))
+
+func (d *Digest) render(from string) (*Mail, error) {
+
+	var text bytes.Buffer
+	err := digestText.Execute(&text, d)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error rendering digest of %s", d.Subscription.Email))
+	}
+
+	var html bytes.Buffer
+	err = digestHtml.Execute(&html, d)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error rendering digest of %s", d.Subscription.Email))
+	}
+
+	return &Mail{
+		From:    from,
+		To:      d.Subscription.Email,
+		Subject: fmt.Sprintf("Ratsinfo: %d Vorlagen, %d Sitzungen", len(d.Vorlagen), len(d.Sitzungen)),
+		Text:    text.String(),
+		Html:    html.String(),
+	}, nil
+}
@@ -0,0 +1,240 @@
+package dpage_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/rismaster/allris-dpage/dpage"
+	"github.com/rismaster/allris-dpage/dpage/dpagetest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingMailer keeps the sent mails
+type recordingMailer struct {
+	mutex sync.Mutex
+	mails []*dpage.Mail
+}
+
+func (m *recordingMailer) Send(mail *dpage.Mail) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.mails = append(m.mails, mail)
+	return nil
+}
+
+func newDigestApp() *dpage.App {
+	return dpage.NewApp(context.Background(), dpagetest.NewConfig("https://ris.example.org/bi/"), dpage.NewMemoryStore(), dpage.NewMemoryStore())
+}
+
+func TestSubscribe(t *testing.T) {
+
+	app := newDigestApp()
+	valid := []struct {
+		email string
+		name  string
+		want  dpage.Subscription
+	}{
+		{"erika@example.org", "", dpage.Subscription{Email: "erika@example.org"}},
+		{"Max Mustermann <max@example.org>", "", dpage.Subscription{Email: "max@example.org", Name: "Max Mustermann"}},
+		{"Max Mustermann <max@example.org>", "Max", dpage.Subscription{Email: "max@example.org", Name: "Max"}},
+	}
+	for _, tt := range valid {
+		subscription := &dpage.Subscription{Email: tt.email, Name: tt.name}
+		if err := dpage.Subscribe(app, subscription); err != nil {
+			t.Errorf("error subscribing %s: %v", tt.email, err)
+		}
+		if subscription.Email != tt.email {
+			t.Errorf("subscription of the caller is changed to %s", subscription.Email)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"keine adresse",
+		"erika@example.org\r\nBcc: spam@example.org",
+		"erika@example.org\nBcc: spam@example.org",
+		"erika@example.org, max@example.org",
+	}
+	for _, email := range invalid {
+		if err := dpage.Subscribe(app, &dpage.Subscription{Email: email}); err == nil {
+			t.Errorf("no error subscribing %q", email)
+		}
+	}
+
+	subscriptions, err := dpage.ListSubscriptions(app)
+	if err != nil {
+		t.Fatalf("error listing subscriptions: %v", err)
+	}
+	if len(subscriptions) != 2 {
+		t.Fatalf("%d subscriptions, want 2", len(subscriptions))
+	}
+	for i, want := range []dpage.Subscription{valid[0].want, valid[2].want} {
+		if subscriptions[i].Email != want.Email || subscriptions[i].Name != want.Name {
+			t.Errorf("subscription is %+v, want %+v", subscriptions[i], want)
+		}
+	}
+
+	// the email is case insensitive
+	if err = dpage.Unsubscribe(app, "ERIKA@example.org"); err != nil {
+		t.Fatalf("error unsubscribing: %v", err)
+	}
+	if err = dpage.Unsubscribe(app, "erika@example.org"); err == nil {
+		t.Errorf("no error unsubscribing twice")
+	}
+	subscriptions, _ = dpage.ListSubscriptions(app)
+	if len(subscriptions) != 1 || subscriptions[0].Email != "max@example.org" {
+		t.Errorf("subscriptions after unsubscribe are %v", subscriptions)
+	}
+}
+
+func TestDigestRender(t *testing.T) {
+
+	digest := &dpage.Digest{
+		Subscription: &dpage.Subscription{Email: "erika@example.org", Name: "Erika"},
+		Vorlagen: []*dpage.DigestItem{{
+			Title:   "VO/2021/0815 Radweg <Nord> & Brücke",
+			Gremium: "Bauausschuss",
+			Datum:   berlin(t, "01.03.2021 00:00"),
+			Url:     "https://ris.example.org/bi/vo020.asp?VOLFDNR=4711",
+			New:     true,
+		}},
+		Sitzungen: []*dpage.DigestItem{{
+			Title:   "12. Sitzung",
+			Gremium: "Bauausschuss",
+			Datum:   berlin(t, "12.04.2021 18:00"),
+			Url:     "https://ris.example.org/bi/si010.asp?SILFDNR=1001",
+			Changes: []string{"Beginn geändert: 18:00 → 19:00", "TOP 7 neu: Haushalt 2022"},
+		}},
+	}
+
+	mail, err := digest.Render("ratsinfo@example.org")
+	if err != nil {
+		t.Fatalf("error rendering: %v", err)
+	}
+	if mail.From != "ratsinfo@example.org" || mail.To != "erika@example.org" || mail.Subject != "Ratsinfo: 1 Vorlagen, 1 Sitzungen" {
+		t.Errorf("mail is from %s to %s: %s", mail.From, mail.To, mail.Subject)
+	}
+
+	wantText := `Hallo Erika,
+
+Neue und geänderte Vorlagen:
+
+- VO/2021/0815 Radweg <Nord> & Brücke (neu)
+  Bauausschuss, 01.03.2021
+  https://ris.example.org/bi/vo020.asp?VOLFDNR=4711
+
+Kommende Sitzungen:
+
+- 12.04.2021 18:00 Bauausschuss: 12. Sitzung
+  https://ris.example.org/bi/si010.asp?SILFDNR=1001
+  * Beginn geändert: 18:00 → 19:00
+  * TOP 7 neu: Haushalt 2022
+`
+	if mail.Text != wantText {
+		t.Errorf("text is\n%s\nwant\n%s", mail.Text, wantText)
+	}
+
+	for _, want := range []string{
+		"<p>Hallo Erika,</p>",
+		`<a href="https://ris.example.org/bi/vo020.asp?VOLFDNR=4711">VO/2021/0815 Radweg &lt;Nord&gt; &amp; Brücke</a> <b>neu</b>`,
+		"<li>12.04.2021 18:00 Bauausschuss: ",
+		"<li>Beginn geändert: 18:00 → 19:00</li><li>TOP 7 neu: Haushalt 2022</li>",
+	} {
+		if !strings.Contains(mail.Html, want) {
+			t.Errorf("html does not contain %s:\n%s", want, mail.Html)
+		}
+	}
+	if strings.Contains(mail.Html, "<Nord>") {
+		t.Errorf("html is not escaped:\n%s", mail.Html)
+	}
+
+	// without Vorlagen the section is left out
+	digest.Vorlagen = nil
+	digest.Subscription.Name = ""
+	mail, err = digest.Render("")
+	if err != nil {
+		t.Fatalf("error rendering: %v", err)
+	}
+	if !strings.HasPrefix(mail.Text, "Hallo,\n\nKommende Sitzungen:") || strings.Contains(mail.Html, "Vorlagen") {
+		t.Errorf("digest without vorlagen is\n%s\n%s", mail.Text, mail.Html)
+	}
+}
+
+func writeModel(t *testing.T, app *dpage.App, path string, v interface{}) {
+	t.Helper()
+	content, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("error marshalling %s: %v", path, err)
+	}
+	if err = dpage.WriteFile(app, path, content, "application/json", time.Now()); err != nil {
+		t.Fatalf("error writing %s: %v", path, err)
+	}
+}
+
+func TestSendDigests(t *testing.T) {
+
+	app := newDigestApp()
+	mailer := &recordingMailer{}
+	app.Mailer = mailer
+
+	for _, s := range []*dpage.Subscription{
+		{Email: "bau@example.org", Gremien: []string{"bauausschuss"}},
+		{Email: "rad@example.org", Keywords: []string{"radweg"}},
+		{Email: "vorlage@example.org", Vorlagen: []int{4710}},
+		{Email: "schule@example.org", Gremien: []string{"Schulausschuss"}},
+	} {
+		if err := dpage.Subscribe(app, s); err != nil {
+			t.Fatalf("error subscribing: %v", err)
+		}
+	}
+
+	start := time.Now().Add(7 * 24 * time.Hour).Truncate(time.Minute)
+	writeModel(t, app, "vorlagen/vorlage-4711.json", &dpage.Vorlage{VOLFDNR: 4711, Nummer: "VO/2021/0815", Betreff: "Neubau Radweg Hauptstraße", Federfuehrend: "Tiefbauamt", Datum: berlin(t, "01.03.2021 00:00")})
+	writeModel(t, app, "vorlagen/vorlage-4710.json", &dpage.Vorlage{VOLFDNR: 4710, Nummer: "VO/2021/0814", Betreff: "Bebauungsplan Nr. 12", Datum: berlin(t, "15.02.2021 00:00"),
+		Beratungsfolge: []dpage.Beratung{{Gremium: "Bauausschuss"}}})
+	writeModel(t, app, "sitzungen/sitzung-1001.json", &dpage.Sitzung{SILFDNR: 1001, Gremium: "Bauausschuss", Bezeichnung: "12. Sitzung", Start: start,
+		Tops: []dpage.TopInfo{{Nummer: "7", Betreff: "Radweg Hauptstraße"}}})
+	writeModel(t, app, "sitzungen/sitzung-1000.json", &dpage.Sitzung{SILFDNR: 1000, Gremium: "Schulausschuss", Bezeichnung: "3. Sitzung", Start: berlin(t, "01.02.2021 18:00")})
+
+	sent, err := dpage.SendDigests(app)
+	if err != nil {
+		t.Fatalf("error sending digests: %v", err)
+	}
+	if sent != 3 {
+		t.Errorf("%d digests sent, want 3", sent)
+	}
+
+	subjects := make(map[string]string)
+	for _, m := range mailer.mails {
+		subjects[m.To] = m.Subject
+	}
+	// the past Sitzung of the Schulausschuss is not sent
+	want := map[string]string{
+		"bau@example.org":     "Ratsinfo: 1 Vorlagen, 1 Sitzungen",
+		"rad@example.org":     "Ratsinfo: 1 Vorlagen, 1 Sitzungen",
+		"vorlage@example.org": "Ratsinfo: 1 Vorlagen, 0 Sitzungen",
+	}
+	if len(subjects) != len(want) {
+		t.Errorf("mails are sent to %v", subjects)
+	}
+	for to, subject := range want {
+		if subjects[to] != subject {
+			t.Errorf("subject of %s is %q, want %q", to, subjects[to], subject)
+		}
+	}
+	for _, m := range mailer.mails {
+		if m.To == "rad@example.org" && (!strings.Contains(m.Text, "VO/2021/0815 Neubau Radweg Hauptstraße (neu)") ||
+			!strings.Contains(m.Text, "https://ris.example.org/bi/vo020.asp?VOLFDNR=4711") ||
+			!strings.Contains(m.Text, "https://ris.example.org/bi/si010.asp?SILFDNR=1001")) {
+			t.Errorf("digest of rad@example.org is\n%s", m.Text)
+		}
+	}
+
+	// the changes are sent once
+	sent, err = dpage.SendDigests(app)
+	if err != nil || sent != 0 {
+		t.Errorf("%d digests sent again: %v", sent, err)
+	}
+}
@@ -1,7 +1,15 @@
 package dpage
 
+import "github.com/rismaster/allris-common/downloader"
+
 type Document interface {
 	GetPath() string
 	GetUrl() string
 	Download() error
 }
+
+// Container is a Document linking to other ressources, which are downloaded after the Container
+type Container interface {
+	Document
+	Children() []downloader.RisRessource
+}
@@ -2,51 +2,105 @@ package dpage
 
 import (
 	"context"
+	"fmt"
+	"github.com/pkg/errors"
 	allris_common "github.com/rismaster/allris-common"
-	"github.com/rismaster/allris-common/application"
 	"github.com/rismaster/allris-common/common/slog"
 	"github.com/rismaster/allris-common/downloader"
+	"strings"
 )
 
-func Download(ctx context.Context, ris downloader.RisRessource, conf allris_common.Config) {
+var ErrUnknownFolder = errors.New("no document type for folder")
 
-	app, err := application.NewAppContextWithContext(ctx, conf)
-	if err != nil {
-		slog.Fatal("error init appContext: %+v", err)
-	}
+// DownloadError is the error of a single failed RisRessource
+type DownloadError struct {
+	Ris downloader.RisRessource
+	Err error
+}
 
-	var doc Document
+func (e *DownloadError) Error() string {
+	return fmt.Sprintf("error downloading %s%s (%s): %v", e.Ris.GetFolder(), e.Ris.GetName(), e.Ris.GetUrl(), e.Err)
+}
 
-	switch ris.Folder {
-	case conf.GetSitzungenFolder():
-		doc = NewSitzung(app, &ris)
-	case conf.GetTopFolder():
-		doc = NewTop(app, &ris)
-	case conf.GetAnlagenFolder():
-		if ris.GetFormData().Get("options") != "" {
-			doc = NewAnlageDocument(app, &ris)
-		} else {
-			doc = NewAnlage(app, &ris)
-		}
-	case conf.GetVorlagenFolder():
-		doc = NewVorlage(app, &ris)
+func (e *DownloadError) Cause() error {
+	return e.Err
+}
+
+func (e *DownloadError) Unwrap() error {
+	return e.Err
+}
+
+// PublishError collects the failed downloads of PublishRisDownload, the successful downloads are counted in Ok
+type PublishError struct {
+	Ok     int
+	Failed []*DownloadError
+}
+
+func (e *PublishError) Error() string {
+	msgs := make([]string, 0, len(e.Failed))
+	for _, f := range e.Failed {
+		msgs = append(msgs, f.Error())
 	}
+	return fmt.Sprintf("%d ok, %d failed: %s", e.Ok, len(e.Failed), strings.Join(msgs, "; "))
+}
 
-	if doc != nil {
-		err = doc.Download()
-		if err != nil {
-			slog.Fatal("error downloading %+v: %+v", ris, err)
+func (e *PublishError) add(ris downloader.RisRessource, err error) {
+	switch t := err.(type) {
+	case nil:
+		e.Ok++
+	case *DownloadError:
+		e.Failed = append(e.Failed, t)
+	default:
+		e.Failed = append(e.Failed, &DownloadError{Ris: ris, Err: err})
+	}
+}
+
+// Download downloads the RisRessource and all its children. If the ressource itself failed the result
+// is a *DownloadError, if only children failed a *PublishError
+func Download(ctx context.Context, ris downloader.RisRessource, conf allris_common.Config) error {
+
+	app, err := NewAppWithConfig(ctx, conf)
+	if err != nil {
+		return &DownloadError{Ris: ris, Err: err}
+	}
+	defer func() {
+		errClose := app.Close()
+		if errClose != nil {
+			slog.Error("error closing app: %v", errClose)
 		}
-	} else {
-		slog.Fatal("error downloading %+v: %+v", ris, err)
+	}()
+
+	results, err := NewPool(app).Run([]downloader.RisRessource{ris})
+	if len(results) > 0 && results[0].Err != nil {
+		return results[0].Err
 	}
+	return err
 }
 
-func PublishRisDownload(app *application.AppContext, risArr []downloader.RisRessource) error {
+// PublishRisDownload downloads all ressources and their children and returns a *PublishError if at least one of them failed
+func PublishRisDownload(app *App, risArr []downloader.RisRessource) error {
 
-	for _, ris := range risArr {
-		Download(app.Ctx(), ris, app.Config)
+	_, err := NewPool(app).Run(risArr)
+	return err
+}
+
+func newDocument(app *App, ris *downloader.RisRessource) (Document, error) {
+
+	switch ris.Folder {
+	case app.Config.GetSitzungenFolder():
+		return NewSitzung(app, ris), nil
+	case app.Config.GetTopFolder():
+		return NewTop(app, ris), nil
+	case app.Config.GetAnlagenFolder():
+		if ris.GetFormData().Get("options") != "" {
+			return NewAnlageDocument(app, ris), nil
+		}
+		return NewAnlage(app, ris), nil
+	case app.Config.GetVorlagenFolder():
+		return NewVorlage(app, ris), nil
+	case GetPersonenFolder(app):
+		return NewPerson(app, ris), nil
 	}
 
-	return nil
+	return nil, errors.Wrap(ErrUnknownFolder, ris.Folder)
 }
@@ -0,0 +1,93 @@
+package dpage_test
+
+import (
+	"encoding/json"
+	"github.com/rismaster/allris-dpage/dpage"
+	"github.com/rismaster/allris-dpage/dpage/dpagetest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fixture reads a page of the dpagetest fixtures in utf-8
+func fixture(t *testing.T, name string) []byte {
+	t.Helper()
+	content, err := dpagetest.Fixture(dpagetest.Testdata(), name)
+	if err != nil {
+		t.Fatalf("error reading fixture %s: %v", name, err)
+	}
+	return content
+}
+
+func testDates(t *testing.T) *dpage.RisDates {
+	t.Helper()
+	dates, err := dpage.NewRisDates(dpagetest.NewConfig(""))
+	if err != nil {
+		t.Fatalf("error creating dates: %v", err)
+	}
+	return dates
+}
+
+// berlin is the time in the timezone of the fixtures
+func berlin(t *testing.T, text string) time.Time {
+	t.Helper()
+	location, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("error loading timezone: %v", err)
+	}
+	result, err := time.ParseInLocation("02.01.2006 15:04", text, location)
+	if err != nil {
+		t.Fatalf("error parsing %s: %v", text, err)
+	}
+	return result
+}
+
+// newEnv starts the fake servers of the fixtures, they are stopped at the end of the test
+func newEnv(t *testing.T) *dpagetest.Env {
+	t.Helper()
+	env, err := dpagetest.NewEnv(dpagetest.Testdata())
+	if err != nil {
+		t.Fatalf("error creating env: %v", err)
+	}
+	t.Cleanup(env.Close)
+	return env
+}
+
+func assertNames(t *testing.T, env *dpagetest.Env, want []string) {
+	t.Helper()
+	got := env.Storage.Names(env.Config.BucketFetched)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("stored %v, want %v", got, want)
+	}
+}
+
+// assertTime compares the instants, the locations may differ
+func assertTime(t *testing.T, field string, got time.Time, want time.Time) {
+	t.Helper()
+	if !got.Equal(want) {
+		t.Errorf("%s is %s, want %s", field, got, want)
+	}
+}
+
+func assertStrings(t *testing.T, field string, got []string, want []string) {
+	t.Helper()
+	if len(got) != len(want) || (len(got) > 0 && !reflect.DeepEqual(got, want)) {
+		t.Errorf("%s is %v, want %v", field, got, want)
+	}
+}
+
+// assertModel compares the json of the models, so the times are compared with their offsets
+func assertModel(t *testing.T, got interface{}, want interface{}) {
+	t.Helper()
+	gotJson, err := json.MarshalIndent(got, "", "  ")
+	if err != nil {
+		t.Fatalf("error marshalling %v: %v", got, err)
+	}
+	wantJson, err := json.MarshalIndent(want, "", "  ")
+	if err != nil {
+		t.Fatalf("error marshalling %v: %v", want, err)
+	}
+	if string(gotJson) != string(wantJson) {
+		t.Errorf("model is\n%s\nwant\n%s", gotJson, wantJson)
+	}
+}
@@ -0,0 +1,137 @@
+package dpagetest
+
+import (
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fixtureParams are the parameters of a request which are part of the fixture name, e.g.
+// vo020.asp?VOLFDNR=4711 is served from vo020-4711.html
+var fixtureParams = []string{"VOLFDNR", "SILFDNR", "TOLFDNR", "DOLFDNR", "GRA", "KPLFDNR", "GRLFDNR", "FRLFDNR", "shownext"}
+
+// AllrisServer is a fake ALLRIS server serving recorded pages from a testdata folder.
+// Requests to *.asp are mapped to <page>[-<param>...].html or .pdf, all other requests to the file with the
+// same path, e.g. ydocs/lageplan.pdf. Pages are served as text/html in their ISO-8859-1 encoding like ALLRIS
+// does, see Record.
+type AllrisServer struct {
+	*httptest.Server
+	dir       string
+	mutex     sync.Mutex
+	requests  []string
+	delay     time.Duration
+	inFlight  int
+	maxFlight int
+}
+
+func NewAllrisServer(dir string) *AllrisServer {
+	s := &AllrisServer{dir: dir}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Target is the url of the server as used in Config.GetTargetToParse
+func (s *AllrisServer) Target() string {
+	return s.URL + "/"
+}
+
+// Requests returns the fixture names of all requests in order
+func (s *AllrisServer) Requests() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+// SetDelay delays every response, so concurrent requests overlap
+func (s *AllrisServer) SetDelay(delay time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.delay = delay
+}
+
+// MaxInFlight is the highest number of requests served at the same time
+func (s *AllrisServer) MaxInFlight() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.maxFlight
+}
+
+func (s *AllrisServer) serve(w http.ResponseWriter, r *http.Request) {
+
+	s.mutex.Lock()
+	s.inFlight++
+	if s.inFlight > s.maxFlight {
+		s.maxFlight = s.inFlight
+	}
+	delay := s.delay
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		s.inFlight--
+		s.mutex.Unlock()
+	}()
+	time.Sleep(delay)
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	candidates := []string{strings.TrimPrefix(r.URL.Path, "/")}
+	if name := fixtureName(r.URL.Path, r.Form); name != "" {
+		candidates = []string{name + ".html", name + ".pdf"}
+	}
+
+	for _, candidate := range candidates {
+		content, errRead := ioutil.ReadFile(filepath.Join(s.dir, filepath.FromSlash(candidate)))
+		if os.IsNotExist(errRead) {
+			continue
+		}
+		if errRead != nil {
+			http.Error(w, errRead.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		s.record(candidate)
+		contentType := mime.TypeByExtension(path.Ext(candidate))
+		if strings.HasSuffix(candidate, ".html") {
+			// like ALLRIS without charset, the pages are ISO-8859-1
+			contentType = "text/html"
+		}
+		w.Header().Set("Content-Type", contentType)
+		_, _ = w.Write(content)
+		return
+	}
+	s.record(candidates[0])
+	http.NotFound(w, r)
+}
+
+func (s *AllrisServer) record(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests = append(s.requests, name)
+}
+
+// fixtureName is the name of the fixture of an *.asp request without extension, empty for other requests
+func fixtureName(urlPath string, form url.Values) string {
+
+	if !strings.HasSuffix(urlPath, ".asp") {
+		return ""
+	}
+	name := strings.TrimSuffix(path.Base(urlPath), ".asp")
+	for _, param := range fixtureParams {
+		if value := form.Get(param); value != "" {
+			name = name + "-" + value
+		}
+	}
+	return name
+}
@@ -0,0 +1,97 @@
+package dpagetest
+
+import (
+	allris_common "github.com/rismaster/allris-common"
+	"time"
+)
+
+// Config is an allris-common Config pointing to the fake ALLRIS server with the urls and types of a
+// standard ALLRIS installation. The requests to the fake server are not rate limited.
+type Config struct {
+	TargetToParse     string
+	ProjectId         string
+	BucketFetched     string
+	BucketBackup      string
+	Debug             bool
+	RequestsPerSecond float64
+	// the parallelism of the dpage.PoolConfig, 0 for the defaults of dpage
+	DownloadParallelism        int
+	DownloadParallelismPerHost int
+}
+
+func NewConfig(targetToParse string) *Config {
+	return &Config{
+		TargetToParse:     targetToParse,
+		ProjectId:         "dpagetest",
+		BucketFetched:     "fetched",
+		BucketBackup:      "backup",
+		RequestsPerSecond: 1000,
+	}
+}
+
+func (c *Config) GetProxySecretHeaderKey() string          { return "" }
+func (c *Config) GetProxyHostHeaderKey() string            { return "" }
+func (c *Config) GetProxySecret() string                   { return "" }
+func (c *Config) GetProxyUrl() string                      { return "" }
+func (c *Config) GetProxyHost() string                     { return "" }
+func (c *Config) GetProxyProto() string                    { return "" }
+func (c *Config) GetProxyParser() allris_common.ProxParser { return nil }
+func (c *Config) GetProjectId() string                     { return c.ProjectId }
+func (c *Config) GetBucketFetched() string                 { return c.BucketFetched }
+func (c *Config) GetBucketBackup() string                  { return c.BucketBackup }
+func (c *Config) GetMinAgeBeforeDownload() time.Duration   { return 0 }
+func (c *Config) GetHttpTimeout() time.Duration            { return 10 * time.Second }
+func (c *Config) GetHttpCalldelay() time.Duration          { return 0 }
+func (c *Config) GetHttpVersuche() int                     { return 1 }
+func (c *Config) GetHttpWithproxy() bool                   { return false }
+func (c *Config) GetHttpWartezeitonretry() time.Duration   { return 10 * time.Millisecond }
+func (c *Config) GetTimezone() string                      { return "Europe/Berlin" }
+func (c *Config) GetDateFormatWithTime() string            { return "02.01.2006 15:04:05" }
+func (c *Config) GetPathToParse() string                   { return "" }
+func (c *Config) GetEntityTop() string                     { return "Top" }
+func (c *Config) GetEntityAnlage() string                  { return "Anlage" }
+func (c *Config) GetEntitySitzung() string                 { return "Sitzung" }
+func (c *Config) GetAnlageType() string                    { return "anlage" }
+func (c *Config) GetUrlAnlagedoc() string                  { return "do027.asp" }
+func (c *Config) GetAnlageDocumentType() string            { return "anlagedoc" }
+func (c *Config) GetTopFolder() string                     { return "tops/" }
+func (c *Config) GetSitzungenFolder() string               { return "sitzungen/" }
+func (c *Config) GetVorlagenFolder() string                { return "vorlagen/" }
+func (c *Config) GetSitzungType() string                   { return "sitzung" }
+func (c *Config) GetVorlageType() string                   { return "vorlage" }
+func (c *Config) GetAlleSitzungenType() string             { return "allesitzungen" }
+func (c *Config) GetDateFormatTech() string                { return "2006-01-02" }
+func (c *Config) GetEntityTermin() string                  { return "Termin" }
+func (c *Config) GetEntityVorlage() string                 { return "Vorlage" }
+func (c *Config) GetDateFormat() string                    { return "02.01.2006" }
+func (c *Config) GetAnlagenFolder() string                 { return "anlagen/" }
+func (c *Config) GetTopType() string                       { return "top" }
+func (c *Config) GetTargetToParse() string                 { return c.TargetToParse }
+func (c *Config) GetDownloadTopic() string                 { return "download" }
+func (c *Config) GetDebug() bool                           { return c.Debug }
+func (c *Config) GetUrlSitzungsLangeliste() string         { return "si010_j.asp" }
+func (c *Config) GetUrlSitzungsliste() string              { return "si010_e.asp" }
+func (c *Config) GetGremienListeType() string              { return "gremienliste" }
+func (c *Config) GetUrlSitzungTmpl() string                { return "si010.asp?SILFDNR=%d" }
+func (c *Config) GetGremienOptionsType() string            { return "gremienoptions" }
+func (c *Config) GetUrlVorlagenliste() string              { return "vo040.asp" }
+func (c *Config) GetVorlagenListeType() string             { return "vorlagenliste" }
+func (c *Config) GetUrlVorlageTmpl() string                { return "vo020.asp?VOLFDNR=%d" }
+func (c *Config) GetBucketOcr() string                     { return "ocr" }
+func (c *Config) GetBucketOcrHtml() string                 { return "ocrhtml" }
+func (c *Config) GetMailDomain() string                    { return "" }
+func (c *Config) GetMailApiString() string                 { return "" }
+func (c *Config) GetSearchApiKey() string                  { return "" }
+func (c *Config) GetSearchIndex() string                   { return "" }
+func (c *Config) GetRestartUrl() string                    { return "" }
+func (c *Config) GetPublicSearchIndexDoneTopic() string    { return "done" }
+func (c *Config) GetPublishDoneSecret() string             { return "" }
+
+func (c *Config) GetRequestsPerSecond() float64 { return c.RequestsPerSecond }
+func (c *Config) GetRequestBurst() int          { return 1 }
+func (c *Config) GetQuietHoursStart() string    { return "" }
+func (c *Config) GetQuietHoursEnd() string      { return "" }
+func (c *Config) GetIgnoreRobotsTxt() bool      { return false }
+
+func (c *Config) GetDownloadParallelism() int        { return c.DownloadParallelism }
+func (c *Config) GetDownloadParallelismPerHost() int { return c.DownloadParallelismPerHost }
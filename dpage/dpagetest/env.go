@@ -0,0 +1,92 @@
+// Package dpagetest runs dpage offline against a fake ALLRIS server serving recorded pages, an in-memory
+// Cloud Storage and a Pub/Sub server, e.g.
+//
+//	env, err := dpagetest.NewEnv(dpagetest.Testdata())
+//	defer env.Close()
+//	vl := dpage.NewVorlagenliste(env.App)
+//	err = vl.SynchronizeSince(time.Time{}, false)
+//	names := env.Storage.Names(env.Config.BucketFetched)
+package dpagetest
+
+import (
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-dpage/dpage"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// emulatorHosts are the environment variables used by the cloud clients of the AppContext
+var emulatorHosts = []string{"STORAGE_EMULATOR_HOST", "PUBSUB_EMULATOR_HOST", "DATASTORE_EMULATOR_HOST"}
+
+// Env is an AppContext with all its services replaced by local fakes. The emulator environment variables
+// are process wide, so only one Env should be used at a time.
+type Env struct {
+	Allris  *AllrisServer
+	Storage *StorageServer
+	PubSub  *pstest.Server
+	Config  *Config
+	// AppContext is the allris-common context with the clients of the fakes, App uses its Cloud Storage
+	AppContext *application.AppContext
+	App        *dpage.App
+
+	previousEnv map[string]*string
+}
+
+// NewEnv starts the fake servers, the ALLRIS server serves the fixtures of dir
+func NewEnv(dir string) (*Env, error) {
+
+	env := &Env{
+		Allris:      NewAllrisServer(dir),
+		Storage:     NewStorageServer(),
+		PubSub:      pstest.NewServer(),
+		previousEnv: make(map[string]*string),
+	}
+	for _, key := range emulatorHosts {
+		if value, ok := os.LookupEnv(key); ok {
+			env.previousEnv[key] = &value
+		} else {
+			env.previousEnv[key] = nil
+		}
+	}
+
+	_ = os.Setenv("STORAGE_EMULATOR_HOST", env.Storage.Endpoint())
+	_ = os.Setenv("PUBSUB_EMULATOR_HOST", env.PubSub.Addr)
+	// the datastore client connects lazily and is not used by dpage
+	_ = os.Setenv("DATASTORE_EMULATOR_HOST", env.PubSub.Addr)
+
+	env.Config = NewConfig(env.Allris.Target())
+
+	appContext, err := application.NewAppContext(env.Config)
+	if err != nil {
+		env.Close()
+		return nil, errors.Wrap(err, "error creating app context")
+	}
+	env.AppContext = appContext
+	env.App = dpage.NewAppFromContext(appContext)
+	return env, nil
+}
+
+// Testdata is the folder of the fixtures bundled with this package
+func Testdata() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "testdata")
+}
+
+// Close stops the servers and restores the environment
+func (env *Env) Close() {
+
+	env.Allris.Close()
+	env.Storage.Close()
+	_ = env.PubSub.Close()
+
+	for key, value := range env.previousEnv {
+		if value == nil {
+			_ = os.Unsetenv(key)
+		} else {
+			_ = os.Setenv(key, *value)
+		}
+	}
+}
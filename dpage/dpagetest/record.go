@@ -0,0 +1,85 @@
+package dpagetest
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/net/html/charset"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Record downloads a page or document of a live ALLRIS to dir with the name the AllrisServer serves it from
+// and returns that name, e.g.
+//
+//	Record("https://ris.example.de/bi/", dir, http.MethodGet, "vo020.asp", url.Values{"VOLFDNR": {"4711"}})
+//	Record("https://ris.example.de/bi/", dir, http.MethodPost, "do027.asp", url.Values{"DOLFDNR": {"55501"}, "options": {"64"}})
+//
+// The content is stored as delivered, so the fixtures of an ALLRIS upgrade can be recorded and diffed.
+func Record(target string, dir string, method string, page string, form url.Values) (string, error) {
+
+	uri := target + page
+	var resp *http.Response
+	var err error
+	if method == http.MethodPost {
+		resp, err = http.PostForm(uri, form)
+	} else {
+		if len(form) > 0 {
+			uri = uri + "?" + form.Encode()
+		}
+		resp, err = http.Get(uri)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("error fetching %s", uri))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(fmt.Sprintf("error fetching: %s | %d", uri, resp.StatusCode))
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("error reading %s", uri))
+	}
+
+	name := fixtureName(page, form)
+	switch {
+	case name == "":
+		name = strings.TrimPrefix(page, "/")
+	case strings.HasPrefix(resp.Header.Get("Content-Type"), "application/pdf"):
+		name = name + ".pdf"
+	default:
+		name = name + ".html"
+	}
+
+	file := filepath.Join(dir, filepath.FromSlash(name))
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return "", err
+	}
+	err = ioutil.WriteFile(file, content, 0644)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("error writing %s", file))
+	}
+	return name, nil
+}
+
+// Fixture reads a fixture of dir, pages are decoded from their charset to utf-8 like the Fetcher does
+func Fixture(dir string, name string) ([]byte, error) {
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(name, ".html") {
+		return content, nil
+	}
+	reader, err := charset.NewReader(strings.NewReader(string(content)), "text/html")
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
@@ -0,0 +1,276 @@
+package dpagetest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+const s3StreamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+const s3MetaPrefix = "X-Amz-Meta-"
+
+type s3Object struct {
+	content     []byte
+	contentType string
+	metadata    map[string]string
+	modified    time.Time
+}
+
+func (o *s3Object) etag() string {
+	sum := md5.Sum(o.content)
+	return "\"" + hex.EncodeToString(sum[:]) + "\""
+}
+
+type s3Error struct {
+	XMLName    xml.Name `xml:"Error"`
+	Code       string   `xml:"Code"`
+	Message    string   `xml:"Message"`
+	BucketName string   `xml:"BucketName"`
+	Key        string   `xml:"Key"`
+}
+
+type s3Contents struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type s3ListBucketResult struct {
+	XMLName     xml.Name     `xml:"ListBucketResult"`
+	Xmlns       string       `xml:"xmlns,attr"`
+	Name        string       `xml:"Name"`
+	Prefix      string       `xml:"Prefix"`
+	KeyCount    int          `xml:"KeyCount"`
+	MaxKeys     int          `xml:"MaxKeys"`
+	IsTruncated bool         `xml:"IsTruncated"`
+	Contents    []s3Contents `xml:"Contents"`
+}
+
+type s3CopyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+}
+
+// S3Server is an in-memory fake of the S3 API for the minio client of the dpage.S3Store. It supports what the
+// store needs: the bucket location, putting, copying, stating, reading, listing and removing objects. Every
+// bucket exists, the requests are not authenticated, streaming signatures are only decoded.
+type S3Server struct {
+	*httptest.Server
+	mutex   sync.Mutex
+	buckets map[string]map[string]*s3Object
+}
+
+func NewS3Server() *S3Server {
+	s := &S3Server{buckets: make(map[string]map[string]*s3Object)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Endpoint is the host and port of the server for the minio client
+func (s *S3Server) Endpoint() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// Names are the sorted keys of the objects in the bucket
+func (s *S3Server) Names(bucket string) (names []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for name := range s.buckets[bucket] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *S3Server) serve(w http.ResponseWriter, r *http.Request) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket := parts[0]
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string]*s3Object)
+	}
+
+	if len(parts) == 1 || parts[1] == "" {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query()["location"] != nil:
+			writeXml(w, http.StatusOK, struct {
+				XMLName xml.Name `xml:"LocationConstraint"`
+				Xmlns   string   `xml:"xmlns,attr"`
+			}{Xmlns: s3Namespace})
+		case r.Method == http.MethodGet:
+			s.list(w, bucket, r.URL.Query().Get("prefix"))
+		default:
+			writeS3Error(w, http.StatusNotImplemented, "NotImplemented", bucket, "")
+		}
+		return
+	}
+
+	key := parts[1]
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		object, ok := s.buckets[bucket][key]
+		if !ok {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey", bucket, key)
+			return
+		}
+		for k, v := range object.metadata {
+			w.Header().Set(s3MetaPrefix+k, v)
+		}
+		w.Header().Set("Content-Type", object.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.content)))
+		w.Header().Set("ETag", object.etag())
+		w.Header().Set("Last-Modified", object.modified.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(object.content)
+		}
+
+	case http.MethodPut:
+		if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+			s.copy(w, r, bucket, key, source)
+			return
+		}
+		content, err := ioutil.ReadAll(r.Body)
+		if err == nil && r.Header.Get("X-Amz-Content-Sha256") == s3StreamingPayload {
+			content, err = decodeStreamingPayload(content)
+		}
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody", bucket, key)
+			return
+		}
+		object := &s3Object{content: content, modified: time.Now()}
+		object.contentType, object.metadata = s3Metadata(r.Header)
+		s.buckets[bucket][key] = object
+		w.Header().Set("ETag", object.etag())
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodDelete:
+		delete(s.buckets[bucket], key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented", bucket, key)
+	}
+}
+
+// copy copies the source object, with the metadata directive REPLACE the content type and the user metadata
+// of the request replace those of the source like in S3
+func (s *S3Server) copy(w http.ResponseWriter, r *http.Request, bucket string, key string, source string) {
+
+	sourcePath, err := url.PathUnescape(source)
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", bucket, key)
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(sourcePath, "/"), "/", 2)
+	if len(parts) < 2 || s.buckets[parts[0]] == nil || s.buckets[parts[0]][parts[1]] == nil {
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey", parts[0], sourcePath)
+		return
+	}
+	src := s.buckets[parts[0]][parts[1]]
+
+	object := &s3Object{content: src.content, contentType: src.contentType, metadata: src.metadata, modified: time.Now()}
+	if strings.EqualFold(r.Header.Get("X-Amz-Metadata-Directive"), "REPLACE") {
+		object.contentType, object.metadata = s3Metadata(r.Header)
+	}
+	s.buckets[bucket][key] = object
+	writeXml(w, http.StatusOK, s3CopyObjectResult{LastModified: object.modified.UTC().Format(time.RFC3339), ETag: object.etag()})
+}
+
+func (s *S3Server) list(w http.ResponseWriter, bucket string, prefix string) {
+
+	result := s3ListBucketResult{Xmlns: s3Namespace, Name: bucket, Prefix: prefix, MaxKeys: 1000}
+	for key, object := range s.buckets[bucket] {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, s3Contents{
+				Key:          key,
+				LastModified: object.modified.UTC().Format(time.RFC3339),
+				ETag:         object.etag(),
+				Size:         len(object.content),
+				StorageClass: "STANDARD",
+			})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool {
+		return result.Contents[i].Key < result.Contents[j].Key
+	})
+	result.KeyCount = len(result.Contents)
+	writeXml(w, http.StatusOK, result)
+}
+
+// s3Metadata reads the content type and the user metadata of a put or copy, S3 defaults to binary/octet-stream
+func s3Metadata(header http.Header) (string, map[string]string) {
+
+	metadata := make(map[string]string)
+	for k := range header {
+		if strings.HasPrefix(k, s3MetaPrefix) {
+			metadata[strings.TrimPrefix(k, s3MetaPrefix)] = header.Get(k)
+		}
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "binary/octet-stream"
+	}
+	return contentType, metadata
+}
+
+// decodeStreamingPayload removes the chunk headers of a payload with streaming signature V4
+func decodeStreamingPayload(body []byte) ([]byte, error) {
+
+	var content []byte
+	reader := bufio.NewReader(bytes.NewReader(body))
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseInt(strings.SplitN(strings.TrimSpace(header), ";", 2)[0], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return content, nil
+		}
+		chunk := make([]byte, size+2) // the chunk ends with \r\n
+		if _, err = io.ReadFull(reader, chunk); err != nil {
+			return nil, err
+		}
+		content = append(content, chunk[:size]...)
+	}
+}
+
+func writeXml(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, xml.Header)
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string, bucket string, key string) {
+	writeXml(w, status, s3Error{Code: code, Message: fmt.Sprintf("%s %s/%s", code, bucket, key), BucketName: bucket, Key: key})
+}
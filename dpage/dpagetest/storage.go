@@ -0,0 +1,303 @@
+package dpagetest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// objectResource is the subset of the Cloud Storage JSON API object used by dpage and allris-common
+type objectResource struct {
+	Kind            string            `json:"kind"`
+	Id              string            `json:"id"`
+	Name            string            `json:"name"`
+	Bucket          string            `json:"bucket"`
+	Generation      string            `json:"generation"`
+	Metageneration  string            `json:"metageneration"`
+	ContentType     string            `json:"contentType,omitempty"`
+	ContentEncoding string            `json:"contentEncoding,omitempty"`
+	ContentLanguage string            `json:"contentLanguage,omitempty"`
+	CustomTime      string            `json:"customTime,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	Size            string            `json:"size"`
+	Md5Hash         string            `json:"md5Hash"`
+	TimeCreated     string            `json:"timeCreated"`
+	Updated         string            `json:"updated"`
+}
+
+type storedObject struct {
+	resource objectResource
+	content  []byte
+}
+
+// StorageServer is an in-memory fake of the Cloud Storage JSON API, used by the storage client if
+// STORAGE_EMULATOR_HOST is set to Endpoint(). It supports what dpage needs: attrs, multipart uploads,
+// listing by prefix, reading, touching and deleting objects.
+type StorageServer struct {
+	*httptest.Server
+	mutex      sync.Mutex
+	buckets    map[string]map[string]*storedObject
+	generation int64
+}
+
+func NewStorageServer() *StorageServer {
+	s := &StorageServer{buckets: make(map[string]map[string]*storedObject)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Endpoint is the value of STORAGE_EMULATOR_HOST for the storage client
+func (s *StorageServer) Endpoint() string {
+	return s.Listener.Addr().String()
+}
+
+// Names returns the sorted names of all objects in the bucket
+func (s *StorageServer) Names(bucket string) (names []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for name := range s.buckets[bucket] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Content returns the uncompressed content of an object
+func (s *StorageServer) Content(bucket string, name string) ([]byte, bool) {
+	s.mutex.Lock()
+	obj, ok := s.buckets[bucket][name]
+	s.mutex.Unlock()
+	if !ok {
+		return nil, false
+	}
+	content, err := obj.plain()
+	return content, err == nil
+}
+
+// Metadata returns the custom metadata of an object
+func (s *StorageServer) Metadata(bucket string, name string) (map[string]string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	obj, ok := s.buckets[bucket][name]
+	if !ok {
+		return nil, false
+	}
+	return obj.resource.Metadata, true
+}
+
+func (o *storedObject) plain() ([]byte, error) {
+	if o.resource.ContentEncoding != "gzip" {
+		return o.content, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(o.content))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (s *StorageServer) serve(w http.ResponseWriter, r *http.Request) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	escaped := r.URL.EscapedPath()
+	switch {
+	case strings.HasPrefix(escaped, "/upload/storage/v1/b/") && r.Method == http.MethodPost:
+		bucket := strings.TrimSuffix(strings.TrimPrefix(escaped, "/upload/storage/v1/b/"), "/o")
+		s.insert(w, r, bucket)
+
+	case strings.HasPrefix(escaped, "/storage/v1/b/"):
+		parts := strings.SplitN(strings.TrimPrefix(escaped, "/storage/v1/b/"), "/", 3)
+		if len(parts) < 2 || parts[1] != "o" {
+			writeError(w, http.StatusNotImplemented)
+			return
+		}
+		if len(parts) == 2 && r.Method == http.MethodGet {
+			s.list(w, parts[0], r.URL.Query().Get("prefix"))
+			return
+		}
+		if len(parts) < 3 {
+			writeError(w, http.StatusNotImplemented)
+			return
+		}
+		name, err := url.PathUnescape(parts[2])
+		if err != nil {
+			writeError(w, http.StatusBadRequest)
+			return
+		}
+		s.object(w, r, parts[0], name)
+
+	case r.Method == http.MethodGet:
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+		if len(parts) < 2 {
+			writeError(w, http.StatusNotFound)
+			return
+		}
+		s.read(w, parts[0], parts[1])
+
+	default:
+		writeError(w, http.StatusNotImplemented)
+	}
+}
+
+func (s *StorageServer) insert(w http.ResponseWriter, r *http.Request, bucket string) {
+
+	if r.URL.Query().Get("uploadType") != "multipart" {
+		writeError(w, http.StatusNotImplemented)
+		return
+	}
+
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+	reader := multipart.NewReader(r.Body, params["boundary"])
+
+	var resource objectResource
+	part, err := reader.NextPart()
+	if err != nil {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+	if err = json.NewDecoder(part).Decode(&resource); err != nil {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+	part, err = reader.NextPart()
+	if err != nil {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+	content, err := ioutil.ReadAll(part)
+	if err != nil {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+
+	if resource.Name == "" {
+		resource.Name = r.URL.Query().Get("name")
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	s.generation++
+	hash := md5.Sum(content)
+
+	resource.Kind = "storage#object"
+	resource.Bucket = bucket
+	resource.Id = fmt.Sprintf("%s/%s/%d", bucket, resource.Name, s.generation)
+	resource.Generation = strconv.FormatInt(s.generation, 10)
+	resource.Metageneration = "1"
+	resource.Size = strconv.Itoa(len(content))
+	resource.Md5Hash = base64.StdEncoding.EncodeToString(hash[:])
+	resource.TimeCreated = now
+	resource.Updated = now
+
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string]*storedObject)
+	}
+	s.buckets[bucket][resource.Name] = &storedObject{resource: resource, content: content}
+	writeJson(w, resource)
+}
+
+func (s *StorageServer) list(w http.ResponseWriter, bucket string, prefix string) {
+
+	var names []string
+	for name := range s.buckets[bucket] {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	items := []objectResource{}
+	for _, name := range names {
+		items = append(items, s.buckets[bucket][name].resource)
+	}
+	writeJson(w, map[string]interface{}{"kind": "storage#objects", "items": items})
+}
+
+func (s *StorageServer) object(w http.ResponseWriter, r *http.Request, bucket string, name string) {
+
+	obj, ok := s.buckets[bucket][name]
+	if !ok {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJson(w, obj.resource)
+
+	case http.MethodPatch:
+		var update objectResource
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			writeError(w, http.StatusBadRequest)
+			return
+		}
+		if update.Metadata != nil {
+			obj.resource.Metadata = update.Metadata
+		}
+		if update.CustomTime != "" {
+			obj.resource.CustomTime = update.CustomTime
+		}
+		metageneration, _ := strconv.Atoi(obj.resource.Metageneration)
+		obj.resource.Metageneration = strconv.Itoa(metageneration + 1)
+		obj.resource.Updated = time.Now().UTC().Format(time.RFC3339Nano)
+		writeJson(w, obj.resource)
+
+	case http.MethodDelete:
+		delete(s.buckets[bucket], name)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusNotImplemented)
+	}
+}
+
+// read serves the content like Cloud Storage with decompressive transcoding of gzip encoded objects
+func (s *StorageServer) read(w http.ResponseWriter, bucket string, name string) {
+
+	obj, ok := s.buckets[bucket][name]
+	if !ok {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+
+	content, err := obj.plain()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", obj.resource.ContentType)
+	w.Header().Set("X-Goog-Generation", obj.resource.Generation)
+	_, _ = w.Write(content)
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"code": code, "message": http.StatusText(code)},
+	})
+}
@@ -0,0 +1,399 @@
+package dpage
+
+import (
+	"bytes"
+	"cloud.google.com/go/pubsub"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-common/common/slog"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the types of a ChangeEvent
+const EventCreated = "created"
+const EventUpdated = "updated"
+const EventDeleted = "deleted"
+
+// the document types of a ChangeEvent
+const DocumentVorlage = "vorlage"
+const DocumentSitzung = "sitzung"
+const DocumentTop = "top"
+const DocumentAnlage = "anlage"
+const DocumentKalender = "kalender"
+const DocumentPerson = "person"
+const DocumentGremium = "gremium"
+const DocumentFraktion = "fraktion"
+
+const eventSinkJsonl = "jsonl"
+const eventSinkWebhook = "webhook"
+const eventSinkPubSub = "pubsub"
+
+const webhookTimeout = 10 * time.Second
+
+// risIdPattern matches the type and id pairs of a name like sitzung-1001-top-20002
+var risIdPattern = regexp.MustCompile(`(?:^|-)([^-]+)-([0-9]+)`)
+
+// EventConfig can be implemented by the Config to publish the ChangeEvents of the syncs. The sink is jsonl,
+// webhook or pubsub, the target is the path of the file, the url or the topic.
+type EventConfig interface {
+	GetEventSink() string
+	GetEventTarget() string
+}
+
+// ChangeEvent is a document created, updated or deleted in the fetched store by a sync. Updated Sitzungen,
+// TOPs and Vorlagen contain the changes to the previous revision. The RisId of a linked Anlage without own id
+// is the id of its parent.
+type ChangeEvent struct {
+	Type         string    `json:"type"`
+	DocumentType string    `json:"documentType"`
+	RisId        int       `json:"risId,omitempty"`
+	Path         string    `json:"path"`
+	Hash         string    `json:"hash,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+	Revision     int       `json:"revision,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	Changes      []Change  `json:"changes,omitempty"`
+}
+
+// EventSink delivers the ChangeEvents, Close flushes pending events
+type EventSink interface {
+	Emit(event *ChangeEvent) error
+	Close() error
+}
+
+// newChangeEvent creates the event of the file at path, nil for files which are no documents of the sync
+// like the exports
+func newChangeEvent(app *App, eventType string, filePath string, hash string) *ChangeEvent {
+
+	documentType, typeName := documentType(app, filePath)
+	if documentType == "" {
+		return nil
+	}
+	return &ChangeEvent{
+		Type:         eventType,
+		DocumentType: documentType,
+		RisId:        documentRisId(app, filePath, typeName),
+		Path:         filePath,
+		Hash:         hash,
+		Timestamp:    time.Now(),
+	}
+}
+
+// documentType returns the document type of the folder and the type used in the names of its files
+func documentType(app *App, filePath string) (string, string) {
+
+	folder, _ := path.Split(filePath)
+	switch folder {
+	case app.Config.GetVorlagenFolder():
+		return DocumentVorlage, app.Config.GetVorlageType()
+	case app.Config.GetSitzungenFolder():
+		return DocumentSitzung, app.Config.GetSitzungType()
+	case app.Config.GetTopFolder():
+		return DocumentTop, app.Config.GetTopType()
+	case app.Config.GetAnlagenFolder():
+		return DocumentAnlage, app.Config.GetAnlageDocumentType()
+	case GetKalenderFolder(app):
+		return DocumentKalender, ""
+	case GetPersonenFolder(app):
+		return DocumentPerson, getPersonType(app)
+	case GetGremienFolder(app):
+		return DocumentGremium, gremiumType
+	case GetFraktionenFolder(app):
+		return DocumentFraktion, defaultFraktionType
+	}
+	return "", ""
+}
+
+// risIdOf reads the id following the last type in a name like sitzung-1001-top-20002, 0 if there is none
+func risIdOf(filePath string, typeName string) int {
+
+	if typeName == "" {
+		return 0
+	}
+	_, name := path.Split(filePath)
+	matches := risIdPattern.FindAllStringSubmatch(name, -1)
+	for i := len(matches) - 1; i >= 0; i-- {
+		if matches[i][1] == typeName {
+			id, _ := strconv.Atoi(matches[i][2])
+			return id
+		}
+	}
+	return 0
+}
+
+// documentRisId is the id of the document at the path. A linked Anlage has no id of its own, so the id of the
+// Vorlage, Sitzung or TOP in front of the Anlage type is used, e.g. 4711 for vorlage-4711-anlage-32-kb-kosten.pdf.
+func documentRisId(app *App, filePath string, typeName string) int {
+
+	if id := risIdOf(filePath, typeName); id != 0 || typeName != app.Config.GetAnlageDocumentType() {
+		return id
+	}
+	_, name := path.Split(filePath)
+	end := strings.Index(name, "-"+app.Config.GetAnlageType()+"-")
+	if end <= 0 {
+		return 0
+	}
+	matches := risIdPattern.FindAllStringSubmatch(name[:end], -1)
+	if len(matches) == 0 {
+		return 0
+	}
+	id, _ := strconv.Atoi(matches[len(matches)-1][2])
+	return id
+}
+
+// derivedFile is true for the files written from a document, like the json model next to its page or the text
+// of a PDF. Their events are used by the digest and the index, but not sent to the EventSink.
+func derivedFile(app *App, filePath string) bool {
+
+	if strings.HasSuffix(filePath, textEnding) {
+		return true
+	}
+	documentType, _ := documentType(app, filePath)
+	return strings.HasSuffix(filePath, jsonEnding) && documentType != DocumentKalender
+}
+
+// emit keeps the event for the digest, the iCalendar feeds and the Beratungsgraph, updates the index and
+// sends the events of the primary documents to the sink of the App, errors of the sink do not fail the sync
+func (app *App) emit(event *ChangeEvent) {
+
+	if event == nil {
+		return
+	}
+	app.digest.add(event)
+	app.ical.add(event)
+	app.beratungen.add(event)
+	app.Index.apply(app, event)
+	if app.Events == nil || derivedFile(app, event.Path) {
+		return
+	}
+	err := app.Events.Emit(event)
+	if err != nil {
+		slog.Error("error emitting %s event of %s: %v", event.Type, event.Path, err)
+	}
+}
+
+// modelCollector keeps the events of the json models of some document types written by a sync, e.g. to update
+// the iCalendar feeds of the changed Sitzungen or the Beratungsgraph
+type modelCollector struct {
+	mutex         sync.Mutex
+	documentTypes map[string]bool
+	events        map[string]*ChangeEvent
+}
+
+func newModelCollector(documentTypes ...string) *modelCollector {
+	c := &modelCollector{documentTypes: make(map[string]bool)}
+	for _, t := range documentTypes {
+		c.documentTypes[t] = true
+	}
+	return c
+}
+
+func (c *modelCollector) add(event *ChangeEvent) {
+
+	if c == nil || !strings.HasSuffix(event.Path, jsonEnding) || !c.documentTypes[event.DocumentType] {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.events == nil {
+		c.events = make(map[string]*ChangeEvent)
+	}
+	c.events[event.Path] = event
+}
+
+// take returns the last event of every model sorted by path and forgets them
+func (c *modelCollector) take() []*ChangeEvent {
+
+	if c == nil {
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	events := make([]*ChangeEvent, 0, len(c.events))
+	for _, event := range c.events {
+		events = append(events, event)
+	}
+	c.events = nil
+	sort.Slice(events, func(i, j int) bool { return events[i].Path < events[j].Path })
+	return events
+}
+
+// newEventSink creates the sink of the EventConfig, nil without EventConfig. The publisher is used for
+// the pubsub sink, without one a client for the project is created.
+func newEventSink(ctx context.Context, conf allris_common.Config, publisher *pubsub.Client) (EventSink, error) {
+
+	ec, ok := conf.(EventConfig)
+	if !ok || ec.GetEventSink() == "" {
+		return nil, nil
+	}
+
+	switch ec.GetEventSink() {
+	case eventSinkJsonl:
+		return NewJsonlSink(ec.GetEventTarget())
+	case eventSinkWebhook:
+		return NewWebhookSink(ec.GetEventTarget()), nil
+	case eventSinkPubSub:
+		if publisher == nil {
+			var err error
+			publisher, err = pubsub.NewClient(ctx, conf.GetProjectId())
+			if err != nil {
+				return nil, errors.Wrap(err, "error creating publisher")
+			}
+		}
+		return NewPubSubSink(ctx, publisher, ec.GetEventTarget())
+	}
+	return nil, errors.New(fmt.Sprintf("unknown event sink %s", ec.GetEventSink()))
+}
+
+// JsonlSink appends the events as json lines to a local file
+type JsonlSink struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func NewJsonlSink(filePath string) (*JsonlSink, error) {
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error opening event file %s", filePath))
+	}
+	return &JsonlSink{file: file}, nil
+}
+
+func (s *JsonlSink) Emit(event *ChangeEvent) error {
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+func (s *JsonlSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
+
+// WebhookSink posts every event as json to an url
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{
+		url:    url,
+		client: &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+func (s *WebhookSink) Emit(event *ChangeEvent) error {
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error posting event to %s", s.url))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New(fmt.Sprintf("error posting event to %s: %d", s.url, resp.StatusCode))
+	}
+	return nil
+}
+
+func (s *WebhookSink) Close() error {
+	return nil
+}
+
+// PubSubSink publishes the events as json to a Pub/Sub topic, with the type, document type and path as attributes
+type PubSubSink struct {
+	ctx   context.Context
+	topic *pubsub.Topic
+
+	mutex   sync.Mutex
+	results []*pubsub.PublishResult
+}
+
+// NewPubSubSink creates the topic if it does not exist
+func NewPubSubSink(ctx context.Context, client *pubsub.Client, topicName string) (*PubSubSink, error) {
+
+	topic := client.Topic(topicName)
+	exists, err := topic.Exists(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error checking topic %s", topicName))
+	}
+	if !exists {
+		topic, err = client.CreateTopic(ctx, topicName)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("error creating topic %s", topicName))
+		}
+	}
+	return &PubSubSink{ctx: ctx, topic: topic}, nil
+}
+
+func (s *PubSubSink) Emit(event *ChangeEvent) error {
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	result := s.topic.Publish(s.ctx, &pubsub.Message{
+		Data: data,
+		Attributes: map[string]string{
+			"type":         event.Type,
+			"documentType": event.DocumentType,
+			"path":         event.Path,
+		},
+	})
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.results = append(s.results, result)
+	return nil
+}
+
+// Close waits until all events are published
+func (s *PubSubSink) Close() error {
+
+	s.mutex.Lock()
+	results := s.results
+	s.results = nil
+	s.mutex.Unlock()
+
+	var failed []string
+	for _, result := range results {
+		_, err := result.Get(s.ctx)
+		if err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	s.topic.Stop()
+
+	if len(failed) > 0 {
+		return errors.New(fmt.Sprintf("error publishing %d events: %s", len(failed), strings.Join(failed, "; ")))
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package dpage_test
+
+import (
+	"bufio"
+	"cloud.google.com/go/pubsub"
+	"context"
+	"encoding/json"
+	"github.com/rismaster/allris-dpage/dpage"
+	"github.com/rismaster/allris-dpage/dpage/dpagetest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDocumentRisId(t *testing.T) {
+
+	app := dpage.NewApp(context.Background(), dpagetest.NewConfig(""), dpage.NewMemoryStore(), dpage.NewMemoryStore())
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"vorlagen/vorlage-4711.html", 4711},
+		{"vorlagen/vorlage-4711.json", 4711},
+		{"sitzungen/sitzung-1001.html", 1001},
+		{"tops/sitzung-1001-top-20002.html", 20002},
+		{"anlagen/vorlage-4711-anlagedoc-55501-1.pdf", 55501},
+		{"anlagen/vorlage-4711-anlage-32-kb-kosten.pdf", 4711},
+		{"anlagen/sitzung-1001-top-20002-anlage-245-kb-lageplan.pdf", 20002},
+		{"anlagen/sitzung-1001-top-20002-anlage-245-kb-lageplan.text.json", 20002},
+		{"anlagen/anlage-245-kb-lageplan.pdf", 0},
+		{"personen/person-17.json", 17},
+		{"gremien/gremium-3.json", 3},
+		{"fraktionen/fraktion-5.json", 5},
+		{"vorlagenliste-0.html", 0},
+	}
+	for _, tt := range tests {
+		if got := dpage.DocumentRisId(app, tt.path); got != tt.want {
+			t.Errorf("id of %s is %d, want %d", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestEmitOnlyPrimaryDocuments(t *testing.T) {
+
+	app := dpage.NewApp(context.Background(), dpagetest.NewConfig(""), dpage.NewMemoryStore(), dpage.NewMemoryStore())
+	sink := &recordingSink{}
+	app.Events = sink
+	risTime := berlin(t, "12.04.2021 18:00")
+
+	files := []struct {
+		path        string
+		contentType string
+	}{
+		{"vorlagen/vorlage-4711.html", "text/html"},
+		{"vorlagen/vorlage-4711.json", "application/json"},
+		{"anlagen/vorlage-4711-anlage-32-kb-kosten.pdf", "application/pdf"},
+		{"anlagen/vorlage-4711-anlage-32-kb-kosten.text.json", "application/json"},
+		{"vorlagenliste-0.html", "text/html"},
+	}
+	for _, f := range files {
+		if err := dpage.WriteFile(app, f.path, []byte("Inhalt von "+f.path), f.contentType, risTime); err != nil {
+			t.Fatalf("error writing %s: %v", f.path, err)
+		}
+	}
+
+	assertStrings(t, "events", sink.summary(), []string{
+		"created vorlagen/vorlage-4711.html 1",
+		"created anlagen/vorlage-4711-anlage-32-kb-kosten.pdf 0",
+	})
+	if sink.events[1].DocumentType != dpage.DocumentAnlage || sink.events[1].RisId != 4711 || sink.events[1].Hash == "" {
+		t.Errorf("event of the anlage is %+v", sink.events[1])
+	}
+}
+
+func testEvents() []*dpage.ChangeEvent {
+	timestamp := time.Date(2021, 4, 12, 18, 0, 0, 0, time.UTC)
+	return []*dpage.ChangeEvent{
+		{Type: dpage.EventCreated, DocumentType: dpage.DocumentVorlage, RisId: 4711, Path: "vorlagen/vorlage-4711.html", Hash: "a", Timestamp: timestamp, Revision: 1},
+		{Type: dpage.EventUpdated, DocumentType: dpage.DocumentSitzung, RisId: 1001, Path: "sitzungen/sitzung-1001.html", Hash: "b", Timestamp: timestamp, Revision: 2,
+			Changes: []dpage.Change{{Kind: "beginn", Old: "18:00", New: "19:00"}}},
+		{Type: dpage.EventDeleted, DocumentType: dpage.DocumentTop, RisId: 20002, Path: "tops/sitzung-1001-top-20002.html", Timestamp: timestamp, Reason: "sitzungen sync"},
+	}
+}
+
+func assertEvent(t *testing.T, got *dpage.ChangeEvent, want *dpage.ChangeEvent) {
+	t.Helper()
+	gotJson, _ := json.Marshal(got)
+	wantJson, _ := json.Marshal(want)
+	if string(gotJson) != string(wantJson) {
+		t.Errorf("event is %s, want %s", gotJson, wantJson)
+	}
+}
+
+func TestJsonlSink(t *testing.T) {
+
+	filePath := filepath.Join(t.TempDir(), "events.jsonl")
+	events := testEvents()
+
+	// a second sink appends to the file
+	for _, batch := range [][]*dpage.ChangeEvent{events[:2], events[2:]} {
+		sink, err := dpage.NewJsonlSink(filePath)
+		if err != nil {
+			t.Fatalf("error creating sink: %v", err)
+		}
+		for _, event := range batch {
+			if err = sink.Emit(event); err != nil {
+				t.Fatalf("error emitting: %v", err)
+			}
+		}
+		if err = sink.Close(); err != nil {
+			t.Fatalf("error closing: %v", err)
+		}
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatalf("error opening events: %v", err)
+	}
+	defer file.Close()
+	var got []*dpage.ChangeEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event dpage.ChangeEvent
+		if err = json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("error unmarshalling line %s: %v", scanner.Text(), err)
+		}
+		got = append(got, &event)
+	}
+	if len(got) != len(events) {
+		t.Fatalf("%d lines, want %d", len(got), len(events))
+	}
+	for i := range events {
+		assertEvent(t, got[i], events[i])
+	}
+
+	if _, err = dpage.NewJsonlSink(filepath.Join(t.TempDir(), "missing", "events.jsonl")); err == nil {
+		t.Errorf("no error for a missing folder")
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+
+	var mutex sync.Mutex
+	var received []*dpage.ChangeEvent
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		body, _ := ioutil.ReadAll(r.Body)
+		var event dpage.ChangeEvent
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || json.Unmarshal(body, &event) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received = append(received, &event)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink := dpage.NewWebhookSink(server.URL + "/events")
+	events := testEvents()
+	for _, event := range events {
+		if err := sink.Emit(event); err != nil {
+			t.Fatalf("error emitting: %v", err)
+		}
+	}
+	mutex.Lock()
+	if len(received) != len(events) {
+		t.Fatalf("%d events received, want %d", len(received), len(events))
+	}
+	for i := range events {
+		assertEvent(t, received[i], events[i])
+	}
+	status = http.StatusInternalServerError
+	mutex.Unlock()
+	if err := sink.Emit(events[0]); err == nil {
+		t.Errorf("no error for status 500")
+	}
+
+	server.Close()
+	if err := sink.Emit(events[0]); err == nil {
+		t.Errorf("no error for a closed server")
+	}
+	if err := sink.Close(); err != nil {
+		t.Errorf("error closing: %v", err)
+	}
+}
+
+func TestPubSubSink(t *testing.T) {
+
+	env := newEnv(t)
+	ctx := context.Background()
+	client, err := pubsub.NewClient(ctx, env.Config.ProjectId)
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	defer client.Close()
+
+	events := testEvents()
+	// the first sink creates the topic, the second uses it
+	for _, batch := range [][]*dpage.ChangeEvent{events[:2], events[2:]} {
+		sink, err := dpage.NewPubSubSink(ctx, client, "dpage-changes")
+		if err != nil {
+			t.Fatalf("error creating sink: %v", err)
+		}
+		for _, event := range batch {
+			if err = sink.Emit(event); err != nil {
+				t.Fatalf("error emitting: %v", err)
+			}
+		}
+		if err = sink.Close(); err != nil {
+			t.Fatalf("error closing: %v", err)
+		}
+	}
+
+	messages := env.PubSub.Messages()
+	if len(messages) != len(events) {
+		t.Fatalf("%d messages, want %d", len(messages), len(events))
+	}
+	byPath := make(map[string]*dpage.ChangeEvent)
+	for _, event := range events {
+		byPath[event.Path] = event
+	}
+	for _, m := range messages {
+		want, ok := byPath[m.Attributes["path"]]
+		if !ok {
+			t.Fatalf("unexpected message with attributes %v", m.Attributes)
+		}
+		var event dpage.ChangeEvent
+		if err = json.Unmarshal(m.Data, &event); err != nil {
+			t.Fatalf("error unmarshalling %s: %v", m.Data, err)
+		}
+		assertEvent(t, &event, want)
+		if m.Attributes["type"] != want.Type || m.Attributes["documentType"] != want.DocumentType {
+			t.Errorf("attributes are %v", m.Attributes)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package dpage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-common/common"
+	"path"
+	"time"
+)
+
+// the unexported parts of dpage used by the tests of dpage_test
+
+var ParseZeit = parseZeit
+var ParseTopNummer = parseTopNummer
+var ParseAbstimmung = parseAbstimmung
+
+var EscapeICalText = escapeICalText
+
+// WriteICalLine returns the folded line
+func WriteICalLine(text string) string {
+	var b bytes.Buffer
+	writeICalLine(&b, text)
+	return b.String()
+}
+
+// RenderICal renders a feed with an event of an hour at each start
+func RenderICal(name string, location *time.Location, starts ...time.Time) []byte {
+	var events []icalEvent
+	for i, start := range starts {
+		events = append(events, icalEvent{
+			uid:     fmt.Sprintf("event-%d", i),
+			summary: name,
+			start:   start,
+			ende:    start.Add(time.Hour),
+			stamp:   start,
+		})
+	}
+	return renderICal(name, location, events)
+}
+
+var NewFetcher = newFetcher
+var ParseRetryAfter = parseRetryAfter
+var IsTransient = isTransient
+
+// NewStopError is an error which is not retried
+func NewStopError(err error) error {
+	return stopError{err}
+}
+
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	return p.backoff(attempt)
+}
+
+// CircuitBreaker exposes the circuit breaker of a Fetcher
+type CircuitBreaker struct {
+	cb *circuitBreaker
+}
+
+func NewCircuitBreaker(conf allris_common.Config) CircuitBreaker {
+	return CircuitBreaker{cb: newCircuitBreaker(conf)}
+}
+
+func (b CircuitBreaker) Wait(ctx context.Context) error { return b.cb.wait(ctx) }
+func (b CircuitBreaker) Success()                       { b.cb.success() }
+func (b CircuitBreaker) Failure()                       { b.cb.failure() }
+func (b CircuitBreaker) Cancel()                        { b.cb.cancel() }
+
+var ParseCrawlDelay = parseCrawlDelay
+
+// RateLimiter exposes the quiet hours of the rate limiter of a Fetcher
+type RateLimiter struct {
+	rl *rateLimiter
+}
+
+// NewRateLimiter returns a rate limiter with the clock now
+func NewRateLimiter(conf allris_common.Config, now func() time.Time) RateLimiter {
+	rl := newRateLimiter(conf)
+	rl.now = now
+	return RateLimiter{rl: rl}
+}
+
+func (l RateLimiter) WaitQuietHours(ctx context.Context) error { return l.rl.waitQuietHours(ctx) }
+
+var DeleteFilesIfNotInAndAfter = deleteFilesIfNotInAndAfter
+
+var TombstoneFile = tombstone
+
+// WriteFile writes content to path like a fetched file
+func WriteFile(app *App, filePath string, content []byte, contentType string, risTime time.Time) error {
+	folder, name := path.Split(filePath)
+	file := &File{app: app, folder: folder, name: name, content: content, contentType: contentType, risTime: risTime, fetchedAt: time.Now()}
+	return file.WriteIfMoreActualAndDifferent(common.Md5HashB(content))
+}
+
+// DocumentRisId is the id of the document at path
+func DocumentRisId(app *App, filePath string) int {
+	_, typeName := documentType(app, filePath)
+	return documentRisId(app, filePath, typeName)
+}
+
+func (d *Digest) Render(from string) (*Mail, error) {
+	return d.render(from)
+}
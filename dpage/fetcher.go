@@ -0,0 +1,407 @@
+package dpage
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-common/common/slog"
+	"github.com/rismaster/allris-common/downloader"
+	"golang.org/x/net/html/charset"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// the resource types of the retry policies
+const ResourceListe = "liste"
+const ResourceVorlage = "vorlage"
+const ResourceSitzung = "sitzung"
+const ResourceTop = "top"
+const ResourceAnlage = "anlage"
+const ResourceAnlageDocument = "anlagedoc"
+const ResourcePerson = "person"
+
+const defaultRetryMaxDelay = 2 * time.Minute
+
+// RetryPolicy is the retry of a fetch, the delay before the n-th retry is a random duration up to
+// min(MaxDelay, BaseDelay * 2^n). A Retry-After of the RIS longer than MaxDelay is not retried.
+type RetryPolicy struct {
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Timeout of a single attempt
+	Timeout time.Duration
+}
+
+// RetryConfig can be implemented by the Config to set the retry policy per resource type (ResourceListe,
+// ResourceVorlage, ...), empty fields of the policy are taken from the http settings of the Config
+type RetryConfig interface {
+	GetRetryPolicy(resourceType string) RetryPolicy
+}
+
+// HttpStatusError is the error of a response with unexpected status
+type HttpStatusError struct {
+	Url        string
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *HttpStatusError) Error() string {
+	return fmt.Sprintf("error fetching: %s | %d", e.Url, e.StatusCode)
+}
+
+// stopError is not retried, e.g. if the RIS requires a login
+type stopError struct {
+	error
+}
+
+// Fetcher downloads the ressources of the RIS with retries, all fetches of the process to the target host of
+// the Config share the circuit breaker and the rate limiter
+type Fetcher struct {
+	conf    allris_common.Config
+	breaker *circuitBreaker
+	limiter *rateLimiter
+	mutex   sync.Mutex
+	client  *http.Client
+}
+
+func newFetcher(conf allris_common.Config) *Fetcher {
+	return &Fetcher{
+		conf:    conf,
+		breaker: newCircuitBreaker(conf),
+		limiter: newRateLimiter(conf),
+	}
+}
+
+// resourceType is the type of the retry policy of a ressource
+func resourceType(app *App, ris *downloader.RisRessource) string {
+
+	switch ris.GetFolder() {
+	case app.Config.GetVorlagenFolder():
+		return ResourceVorlage
+	case app.Config.GetSitzungenFolder():
+		return ResourceSitzung
+	case app.Config.GetTopFolder():
+		return ResourceTop
+	case GetPersonenFolder(app):
+		return ResourcePerson
+	case app.Config.GetAnlagenFolder():
+		if ris.GetFormData() != nil && ris.GetFormData().Get("options") != "" {
+			return ResourceAnlageDocument
+		}
+		return ResourceAnlage
+	}
+	return ResourceListe
+}
+
+func (f *Fetcher) policy(resourceType string) RetryPolicy {
+
+	var policy RetryPolicy
+	if rc, ok := f.conf.(RetryConfig); ok {
+		policy = rc.GetRetryPolicy(resourceType)
+	}
+	if policy.Attempts <= 0 {
+		policy.Attempts = f.conf.GetHttpVersuche()
+		if policy.Attempts <= 0 {
+			policy.Attempts = 1
+		}
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = f.conf.GetHttpWartezeitonretry()
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = defaultRetryMaxDelay
+	}
+	if policy.Timeout <= 0 {
+		policy.Timeout = f.conf.GetHttpTimeout()
+	}
+	return policy
+}
+
+// backoff is the delay before the retry after attempt (1 for the first retry)
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+
+	if p.BaseDelay <= 0 {
+		return 0
+	}
+	ceiling := p.MaxDelay
+	if attempt < 32 && p.BaseDelay<<uint(attempt) > 0 && p.BaseDelay<<uint(attempt) < ceiling {
+		ceiling = p.BaseDelay << uint(attempt)
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// Fetch downloads the ressource with GET or POST (with its form data), transient errors (timeouts, 5xx,
+// connection resets) are retried according to the policy of the resource type
+func (f *Fetcher) Fetch(ctx context.Context, method string, ris *downloader.RisRessource, resourceType string) (*downloader.Download, error) {
+
+	policy := f.policy(resourceType)
+
+	var err error
+	for attempt := 0; attempt < policy.Attempts; attempt++ {
+
+		if attempt > 0 {
+			delay := policy.backoff(attempt)
+			var statusErr *HttpStatusError
+			if errors.As(err, &statusErr) && statusErr.RetryAfter > delay {
+				delay = statusErr.RetryAfter
+			}
+			slog.Warn("retry %s in %s (attempt %d of %d): %v", ris.GetUrl(), delay, attempt+1, policy.Attempts, err)
+			errSleep := sleep(ctx, delay)
+			if errSleep != nil {
+				return nil, errSleep
+			}
+		}
+
+		errWait := f.breaker.wait(ctx)
+		if errWait != nil {
+			return nil, errors.Wrap(errWait, fmt.Sprintf("error fetching %s", ris.GetUrl()))
+		}
+
+		var download *downloader.Download
+		download, err = f.fetch(ctx, method, ris, policy.Timeout)
+		if err == nil {
+			f.breaker.success()
+			return download, nil
+		}
+
+		if !isTransient(err) {
+			if _, ok := err.(*HttpStatusError); ok {
+				// the RIS is up
+				f.breaker.success()
+			} else {
+				f.breaker.cancel()
+			}
+			return nil, err
+		}
+		f.breaker.failure()
+		f.resetClient()
+
+		var statusErr *HttpStatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > policy.MaxDelay {
+			return nil, errors.Wrap(err, fmt.Sprintf("Retry-After %s is longer than the max delay", statusErr.RetryAfter))
+		}
+	}
+	return nil, errors.Wrap(err, fmt.Sprintf("giving up after %d attempts", policy.Attempts))
+}
+
+// isTransient is true for errors which may not happen on a retry
+func isTransient(err error) bool {
+
+	var statusErr *HttpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	if _, ok := err.(stopError); ok {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+// parseRetryAfter reads the seconds or the http date of a Retry-After header
+func parseRetryAfter(value string) time.Duration {
+
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && time.Until(date) > 0 {
+		return time.Until(date)
+	}
+	return 0
+}
+
+func (f *Fetcher) fetch(ctx context.Context, method string, ris *downloader.RisRessource, timeout time.Duration) (*downloader.Download, error) {
+
+	client, err := f.httpClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "error init httpclient")
+	}
+
+	err = f.limiter.wait(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var req *http.Request
+	if method == httpPost {
+		encoded := ""
+		if ris.GetFormData() != nil {
+			encoded = ris.GetFormData().Encode()
+		}
+		slog.Info("send post request: %s?%s", ris.GetUrl(), encoded)
+		req, err = http.NewRequest(http.MethodPost, ris.GetUrl(), strings.NewReader(encoded))
+		if err != nil {
+			return nil, stopError{err}
+		}
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		slog.Debug("send request: %s", ris.GetUrl())
+		req, err = http.NewRequest(http.MethodGet, ris.GetUrl(), nil)
+		if err != nil {
+			return nil, stopError{err}
+		}
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("X-Page") == "noauth.asp" {
+		return nil, stopError{errors.New(fmt.Sprintf("error fetching X-Page=noauth.asp - no retry : %s | %d", ris.GetUrl(), resp.StatusCode))}
+	}
+
+	if resp.StatusCode == http.StatusNotFound && method == httpPost {
+		slog.Warn("error fetching: %s | %d", ris.GetUrl(), resp.StatusCode)
+		return downloader.NewDownload(path.Base(resp.Request.URL.String()), resp.Header.Get("Content-Type"), nil, resp.StatusCode), nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HttpStatusError{
+			Url:        ris.GetUrl(),
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	var body []byte
+	contentType := strings.ReplaceAll(strings.ToLower(resp.Header.Get("Content-Type")), " ", "")
+	if strings.HasPrefix(contentType, "text/html") {
+		body, contentType, err = readHtml(contentType, resp.Body)
+	} else {
+		body, err = ioutil.ReadAll(resp.Body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(body) == 0 {
+		return nil, stopError{errors.New(fmt.Sprintf("error empty body: %s", ris.GetUrl()))}
+	}
+
+	return downloader.NewDownload(path.Base(resp.Request.URL.String()), contentType, body, resp.StatusCode), nil
+}
+
+// readHtml decodes the html to utf-8 like the RetryClient of allris-common, html without charset is latin-1. The
+// RetryClient does not export it, as it reads the body only within its own requests.
+func readHtml(headerContentType string, body io.Reader) ([]byte, string, error) {
+
+	var readerCharset string
+	if headerContentType == "text/html" || headerContentType == "text/html;charset=iso-8859-1" {
+		readerCharset = "latin"
+		headerContentType = "text/html;charset=iso-8859-1"
+	} else if splitted := strings.Split(headerContentType, ";"); len(splitted) == 2 {
+		readerCharset = splitted[1]
+	} else {
+		readerCharset = "utf-8"
+	}
+
+	reader, err := charset.NewReader(body, readerCharset)
+	if err != nil {
+		return nil, "", err
+	}
+	result, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var rc downloader.RetryClient
+	bodyContentType, err := rc.GetContentType(result)
+	if err != nil {
+		slog.Warn("missing contentType in body, use header %s", headerContentType)
+		bodyContentType = headerContentType
+	}
+
+	if bodyContentType != headerContentType {
+		if strings.HasSuffix(bodyContentType, "utf-8") && headerContentType == "text/html;charset=iso-8859-1" {
+			result = iso8859ToUtf8(result)
+		} else {
+			slog.Warn("different contentType (header %s, body %s) but i do not know how to fix it", headerContentType, bodyContentType)
+		}
+	}
+	return result, "text/html;charset=utf-8", nil
+}
+
+func iso8859ToUtf8(iso88591Buf []byte) []byte {
+	buf := make([]rune, len(iso88591Buf))
+	for i, b := range iso88591Buf {
+		buf[i] = rune(b)
+	}
+	return []byte(string(buf))
+}
+
+// httpClient creates the client with the RetryClient of allris-common, with proxy it requests a proxy url from
+// the proxy service of the Config
+func (f *Fetcher) httpClient() (client *http.Client, err error) {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.client != nil {
+		return f.client, nil
+	}
+
+	// the RetryClient panics if the proxy service is not reachable
+	defer func() {
+		if r := recover(); r != nil {
+			client, err = nil, errors.New(fmt.Sprintf("error requesting proxy: %v", r))
+		}
+	}()
+
+	rc := &downloader.RetryClient{
+		Config:     f.conf,
+		WithProxy:  f.conf.GetHttpWithproxy(),
+		Versuche:   1,
+		ProxParser: f.conf.GetProxyParser(),
+	}
+	err = rc.Retry(func(c *http.Client) error {
+		client = c
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	f.client = client
+	return client, nil
+}
+
+// resetClient creates a new client with the next request, with proxy a new proxy is requested
+func (f *Fetcher) resetClient() {
+	if !f.conf.GetHttpWithproxy() {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.client = nil
+}
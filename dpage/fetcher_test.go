@@ -0,0 +1,179 @@
+package dpage_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/downloader"
+	"github.com/rismaster/allris-dpage/dpage"
+	"github.com/rismaster/allris-dpage/dpage/dpagetest"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+
+	policy := dpage.RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		attempt int
+		ceiling time.Duration
+	}{
+		{1, 200 * time.Millisecond},
+		{2, 400 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		{40, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("attempt %d", tt.attempt), func(t *testing.T) {
+			delays := make(map[time.Duration]bool)
+			for i := 0; i < 100; i++ {
+				delay := policy.Backoff(tt.attempt)
+				if delay < 0 || delay > tt.ceiling {
+					t.Fatalf("delay %s is not within 0 and %s", delay, tt.ceiling)
+				}
+				delays[delay] = true
+			}
+			if len(delays) < 10 {
+				t.Errorf("%d different delays of 100, the jitter is missing", len(delays))
+			}
+		})
+	}
+
+	if delay := (dpage.RetryPolicy{MaxDelay: time.Second}).Backoff(1); delay != 0 {
+		t.Errorf("delay without base delay is %s", delay)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+
+	tests := []struct {
+		value string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{"", 0, 0},
+		{"120", 2 * time.Minute, 2 * time.Minute},
+		{"0", 0, 0},
+		{"-5", 0, 0},
+		{"soon", 0, 0},
+		{time.Now().Add(90 * time.Second).UTC().Format(http.TimeFormat), 85 * time.Second, 90 * time.Second},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, 0},
+	}
+	for _, tt := range tests {
+		if got := dpage.ParseRetryAfter(tt.value); got < tt.min || got > tt.max {
+			t.Errorf("Retry-After %q is %s, want %s to %s", tt.value, got, tt.min, tt.max)
+		}
+	}
+}
+
+// timeoutError is a net.Error of a timeout
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTransient(t *testing.T) {
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"server error", &dpage.HttpStatusError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"too many requests", &dpage.HttpStatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{"wrapped server error", errors.Wrap(&dpage.HttpStatusError{StatusCode: http.StatusInternalServerError}, "fetching"), true},
+		{"not found", &dpage.HttpStatusError{StatusCode: http.StatusNotFound}, false},
+		{"stop", dpage.NewStopError(errors.New("login required")), false},
+		{"cancelled", errors.Wrap(context.Canceled, "fetching"), false},
+		{"deadline", context.DeadlineExceeded, true},
+		{"net timeout", &url.Error{Op: "Get", URL: "http://ris", Err: timeoutError{}}, true},
+		{"connection reset", &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{"connection refused", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, true},
+		{"unexpected eof", io.ErrUnexpectedEOF, true},
+		{"other", errors.New("invalid html"), false},
+	}
+	for _, tt := range tests {
+		if got := dpage.IsTransient(tt.err); got != tt.want {
+			t.Errorf("%s: transient is %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+// retryConfig retries every resource type three times without delay
+type retryConfig struct {
+	*dpagetest.Config
+}
+
+func (c *retryConfig) GetRetryPolicy(resourceType string) dpage.RetryPolicy {
+	return dpage.RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Minute}
+}
+
+func TestFetchRetries(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		statuses []int
+		header   http.Header
+		requests int
+		wantErr  bool
+	}{
+		{"ok", []int{http.StatusOK}, nil, 1, false},
+		{"transient errors", []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK}, nil, 3, false},
+		{"giving up", []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable}, nil, 3, true},
+		{"not found", []int{http.StatusNotFound}, nil, 1, true},
+		{"short Retry-After", []int{http.StatusTooManyRequests, http.StatusOK}, http.Header{"Retry-After": {"1"}}, 2, false},
+		{"Retry-After beyond max delay", []int{http.StatusServiceUnavailable}, http.Header{"Retry-After": {"3600"}}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var mutex sync.Mutex
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/robots.txt" {
+					http.NotFound(w, r)
+					return
+				}
+				mutex.Lock()
+				status := tt.statuses[requests]
+				requests++
+				mutex.Unlock()
+				if status != http.StatusOK {
+					for k, v := range tt.header {
+						w.Header()[k] = v
+					}
+				}
+				w.Header().Set("Content-Type", "application/pdf")
+				w.WriteHeader(status)
+				_, _ = w.Write([]byte("%PDF-1.4"))
+			}))
+			defer server.Close()
+
+			f := dpage.NewFetcher(&retryConfig{Config: dpagetest.NewConfig(server.URL + "/")})
+			uri, _ := url.Parse(server.URL + "/ydocs/lageplan.pdf")
+			ris := downloader.NewRisRessource("anlagen/", "lageplan", ".pdf", time.Now(), uri, &url.Values{}, false, false)
+
+			download, err := f.Fetch(context.Background(), http.MethodGet, ris, dpage.ResourceAnlage)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("error is %v", err)
+			}
+			if err == nil && string(download.GetContent()) != "%PDF-1.4" {
+				t.Errorf("content is %s", download.GetContent())
+			}
+			if requests != tt.requests {
+				t.Errorf("%d requests, want %d", requests, tt.requests)
+			}
+		})
+	}
+}
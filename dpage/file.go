@@ -0,0 +1,341 @@
+package dpage
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/slog"
+	"github.com/rismaster/allris-common/downloader"
+	"path"
+	"strings"
+	"time"
+)
+
+const httpGet = "GET"
+const httpPost = "POST"
+
+const metadataChangedBy = "ChangedBy"
+
+// defaultMaxDeletions is the number of files one deletion of a sync may move to the tombstones, it applies per
+// call of deleteFilesIfNotInAndAfter, i.e. per folder, not to the sum of a sync
+const defaultMaxDeletions = 50
+
+var ErrTooManyDeletions = errors.New("too many deletions without confirmation")
+
+// DeletionConfig can be implemented by the Config to set how many files of a folder may be deleted at once by a
+// sync without App.ConfirmDeletions. The limit applies to each folder of the sync separately, the files of
+// deleted parents in their child folders are not counted.
+type DeletionConfig interface {
+	GetMaxDeletions() int
+}
+
+// File is a document in the fetched store, loaded from the store or downloaded from the RIS
+type File struct {
+	app         *App
+	folder      string
+	name        string
+	contentType string
+	updated     time.Time
+	risTime     time.Time
+	fetchedAt   time.Time
+	hash        string
+	content     []byte
+	url         string
+	// fetches shares the downloads of a Pool, nil outside of a Pool
+	fetches *fetchCache
+
+	loadedFromStore bool
+	attrsRead       bool
+	existInStore    bool
+}
+
+func NewFile(app *App, ris *downloader.RisRessource) *File {
+	return &File{
+		app:     app,
+		folder:  ris.GetFolder(),
+		name:    ris.GetName() + ris.GetEnding(),
+		risTime: ris.GetCreated(),
+		url:     ris.GetUrl(),
+	}
+}
+
+func newFileFromAttrs(app *App, attrs *ObjectAttrs) *File {
+	folder, name := path.Split(attrs.Name)
+	return &File{
+		app:          app,
+		folder:       folder,
+		name:         name,
+		contentType:  attrs.ContentType,
+		updated:      attrs.Updated,
+		risTime:      attrs.RisTime,
+		fetchedAt:    attrs.FetchedAt,
+		hash:         attrs.Hash,
+		attrsRead:    true,
+		existInStore: true,
+	}
+}
+
+func (file *File) GetName() string {
+	return file.name
+}
+
+func (file *File) GetFolder() string {
+	return file.folder
+}
+
+func (file *File) GetPath() string {
+	return file.folder + file.name
+}
+
+func (file *File) GetNameWithoutExtension() string {
+	return strings.TrimSuffix(file.name, path.Ext(file.name))
+}
+
+func (file *File) GetContent() []byte {
+	return file.content
+}
+
+func (file *File) GetContentType() string {
+	return file.contentType
+}
+
+// readAttrs loads the attributes of the stored file once
+func (file *File) readAttrs() error {
+
+	if file.attrsRead {
+		return nil
+	}
+
+	attrs, err := file.app.Fetched.Attrs(file.GetPath())
+	if err == ErrObjectNotExist {
+		file.attrsRead = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	file.attrsRead = true
+	file.existInStore = true
+	file.hash = attrs.Hash
+	file.updated = attrs.Updated
+	file.contentType = attrs.ContentType
+	file.risTime = attrs.RisTime
+	file.fetchedAt = attrs.FetchedAt
+	return nil
+}
+
+// Fetch loads the file from the store if it exists and should not be redownloaded or is younger than
+// MinAgeBeforeDownload, otherwise from the RIS
+func (file *File) Fetch(httpMethod string, ris *downloader.RisRessource, expectedMimeType string) error {
+
+	stored := &File{app: file.app, folder: file.folder, name: file.name}
+	err := stored.readAttrs()
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error reading stored %s", file.GetPath()))
+	}
+
+	useStored := stored.existInStore && (!ris.Redownload || time.Now().Before(stored.updated.Add(file.app.Config.GetMinAgeBeforeDownload())))
+	if useStored {
+		slog.Debug("Read From Store: %s", file.GetPath())
+		content, errRead := file.app.Fetched.Read(file.GetPath())
+		if errRead != nil {
+			return errors.Wrap(errRead, fmt.Sprintf("error getting file content from store %s is %s", ris.GetUrl(), file.GetPath()))
+		}
+
+		file.contentType = stored.contentType
+		file.fetchedAt = stored.fetchedAt
+		file.existInStore = true
+		file.attrsRead = true
+		file.hash = stored.hash
+		file.updated = stored.updated
+		file.risTime = stored.risTime
+		file.content = content
+		file.loadedFromStore = true
+		return nil
+	}
+
+	slog.Info("%s: %s (%s)", httpMethod, ris.GetName(), ris.GetUrl())
+
+	download, err := file.fetch(httpMethod, ris)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error fetching file %s", ris.GetUrl()))
+	}
+
+	if expectedMimeType != "*" && !strings.HasPrefix(download.GetContentType(), expectedMimeType) {
+		return errors.New(fmt.Sprintf("content is not %s on page %s is %s", expectedMimeType, ris.GetUrl(), download.GetContentType()))
+	}
+
+	// keep the fetch time and content type of the download, only hash and update time are of the stored file
+	file.attrsRead = true
+	file.existInStore = stored.existInStore
+	file.hash = stored.hash
+	file.updated = stored.updated
+	file.fetchedAt = time.Now()
+	file.contentType = download.GetContentType()
+	file.content = download.GetContent()
+	file.loadedFromStore = false
+	return nil
+}
+
+// fetch downloads the ressource from the RIS, in a Pool only once per method, url and form data
+func (file *File) fetch(httpMethod string, ris *downloader.RisRessource) (*downloader.Download, error) {
+
+	fetch := func() (*downloader.Download, error) {
+		return file.app.Fetcher().Fetch(file.app.Ctx(), httpMethod, ris, resourceType(file.app, ris))
+	}
+	if file.fetches == nil {
+		return fetch()
+	}
+	return file.fetches.fetch(fetchKey(httpMethod, ris), fetch)
+}
+
+// WriteIfMoreActualAndDifferent writes the file to the store if it does not exist there or has another hash,
+// a replaced html page of a Sitzung, TOP or Vorlage is kept as a revision
+func (file *File) WriteIfMoreActualAndDifferent(newHash string) error {
+
+	err := file.readAttrs()
+	if err != nil {
+		return err
+	}
+
+	changedBy := "Create"
+	revision := 1
+	var previous []byte
+	if file.existInStore {
+
+		if file.hash == newHash {
+			slog.Debug("Same Hash for File %s: %s", file.GetPath(), file.hash)
+			if !file.loadedFromStore {
+				err = file.app.Fetched.Touch(file.GetPath())
+				if err != nil {
+					return errors.Wrap(err, fmt.Sprintf("error touching file %s", file.GetPath()))
+				}
+			}
+			file.app.Catalog.record(file.app, file, false)
+			return nil
+		}
+
+		if hasRevisions(file.app, file.GetPath()) {
+			revision, previous, err = file.saveRevision()
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("error saving revision of file '%s'", file.GetPath()))
+			}
+			slog.Info("revision saved, Update File: %s (%s)", file.GetPath(), file.hash)
+			revision++
+		} else {
+			slog.Info("Update File: %s (%s)", file.GetPath(), file.hash)
+		}
+		changedBy = "Update"
+	} else {
+		slog.Info("Create File: %s", file.GetPath())
+	}
+
+	if len(file.content) == 0 {
+		return errors.New(fmt.Sprintf("content length is 0 for file %s", file.GetPath()))
+	}
+
+	file.hash = newHash
+	err = file.app.Fetched.Write(file.GetPath(), file.content, &ObjectAttrs{
+		ContentType: file.contentType,
+		Hash:        file.hash,
+		RisTime:     file.risTime,
+		FetchedAt:   file.fetchedAt,
+		Metadata:    map[string]string{metadataChangedBy: changedBy},
+	})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error writing new file %s", file.GetPath()))
+	}
+
+	event := newChangeEvent(file.app, EventCreated, file.GetPath(), file.hash)
+	if event != nil {
+		if hasRevisions(file.app, file.GetPath()) {
+			event.Revision = revision
+		}
+		if changedBy == "Update" {
+			event.Type = EventUpdated
+		}
+		if previous != nil {
+			event.Changes, _ = diffDocuments(file.app, file.GetPath(), previous, file.content)
+		}
+	}
+	file.app.emit(event)
+	file.app.Catalog.record(file.app, file, true)
+
+	file.existInStore = true
+	return nil
+}
+
+// listFiles lists the stored files starting with prefix
+func listFiles(app *App, prefix string) (result []*File, err error) {
+
+	objects, err := app.Fetched.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, attrs := range objects {
+		result = append(result, newFileFromAttrs(app, attrs))
+	}
+	return result, nil
+}
+
+// deleteFilesIfNotInAndAfter moves all stored files starting with prefix to the tombstones, if they are
+// not in foundFilePathes and were created in the RIS after minTime. The files of a deleted file in the
+// childFolders are moved too. The reason is noted in the tombstone, e.g. the sync or the parent document.
+// More deletions than allowed by the DeletionConfig fail with ErrTooManyDeletions unless confirmed, a dry run
+// plans them anyway.
+func deleteFilesIfNotInAndAfter(app *App, prefix string, foundFilePathes map[string]bool, childFolders []string, minTime time.Time, reason string) error {
+
+	stored, err := listFiles(app, prefix)
+	if err != nil {
+		return errors.Wrap(err, "error iterating file results")
+	}
+
+	var toDelete []*File
+	for _, f := range stored {
+		if f.risTime.After(minTime) && !foundFilePathes[f.GetPath()] {
+			slog.Info("DELETE File '%s' not existing in RIS and tombstone it", f.GetPath())
+			toDelete = append(toDelete, f)
+		}
+	}
+
+	if len(toDelete) > maxDeletions(app) && !app.ConfirmDeletions {
+		if app.Plan() == nil {
+			return errors.Wrap(ErrTooManyDeletions, fmt.Sprintf("%d files of %s", len(toDelete), prefix))
+		}
+		app.Plan().refuse(prefix, len(toDelete))
+	}
+
+	for _, f := range toDelete {
+
+		err = tombstone(app, f.GetPath(), reason)
+		if err != nil {
+			slog.Error("error deleting file: %s %v", f.GetPath(), err)
+			continue
+		}
+
+		for _, childFolder := range childFolders {
+			childPrefixWithPath := childFolder + f.GetNameWithoutExtension()
+			children, errList := listFiles(app, childPrefixWithPath)
+			if errList != nil {
+				slog.Error("error reading files: %s %v", childPrefixWithPath, errList)
+				continue
+			}
+			for _, child := range children {
+				slog.Info("DELETE Child from '%s' and tombstone it %s", f.GetName(), child.GetPath())
+				err = tombstone(app, child.GetPath(), fmt.Sprintf("%s (%s)", f.GetPath(), reason))
+				if err != nil {
+					slog.Error("error deleting file: %s %v", child.GetPath(), err)
+					continue
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func maxDeletions(app *App) int {
+	if dc, ok := app.Config.(DeletionConfig); ok && dc.GetMaxDeletions() > 0 {
+		return dc.GetMaxDeletions()
+	}
+	return defaultMaxDeletions
+}
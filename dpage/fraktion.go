@@ -0,0 +1,168 @@
+package dpage
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/domtools"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fraktion is the parsed content of the fr020 page of a Fraktion with its Anträge and Anfragen
+type Fraktion struct {
+	FRLFDNR  int      `json:"frlfdnr"`
+	Name     string   `json:"name"`
+	Antraege []Antrag `json:"antraege,omitempty"`
+}
+
+// Antrag is an Antrag or Anfrage of a Fraktion, the Vorlage of the RIS is linked by its VOLFDNR
+type Antrag struct {
+	VOLFDNR int       `json:"volfdnr,omitempty"`
+	Nummer  string    `json:"nummer"`
+	Betreff string    `json:"betreff"`
+	Art     string    `json:"art,omitempty"`
+	Datum   time.Time `json:"datum"`
+}
+
+func ParseFraktion(html []byte, dates *RisDates) (*Fraktion, error) {
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(html))
+	if err != nil {
+		return nil, errors.Wrap(err, "error create dom from fraktion")
+	}
+
+	container := doc.Find("#allriscontainer")
+	if container.Size() == 0 {
+		return nil, errors.New("no allriscontainer in fraktion")
+	}
+
+	labels := labelValues(container)
+
+	fraktion := &Fraktion{
+		FRLFDNR: domtools.ExtractIntFromInput(container, "FRLFDNR"),
+		Name:    labels["Name"],
+	}
+
+	if fraktion.Name == "" {
+		title := domtools.CleanText(doc.Find("#risname h1").Text())
+		if i := strings.Index(title, " - "); i >= 0 {
+			fraktion.Name = strings.TrimSpace(title[i+3:])
+		}
+	}
+
+	container.Find("table.tl1 tr").Each(func(i int, tr *goquery.Selection) {
+		tds := tr.Children().Filter("td")
+		if tds.Size() < 4 {
+			return
+		}
+		href, _ := tds.Eq(1).Find("a").Attr("href")
+		antrag := Antrag{
+			Datum:   dates.parse(domtools.CleanText(tds.Eq(0).Text())),
+			VOLFDNR: idFromHref(href, "VOLFDNR"),
+			Nummer:  domtools.CleanText(tds.Eq(1).Text()),
+			Betreff: domtools.CleanText(tds.Eq(2).Text()),
+			Art:     domtools.CleanText(tds.Eq(3).Text()),
+		}
+		if antrag.Nummer != "" || antrag.VOLFDNR > 0 {
+			fraktion.Antraege = append(fraktion.Antraege, antrag)
+		}
+	})
+
+	if fraktion.Name == "" {
+		return nil, errors.New(fmt.Sprintf("no Name in fraktion %d", fraktion.FRLFDNR))
+	}
+
+	return fraktion, nil
+}
+
+// ListFraktionen returns the stored Fraktionen of the Fraktionenliste
+func ListFraktionen(app *App) ([]*Fraktion, error) {
+
+	objects, err := listJsons(app, GetFraktionenFolder(app))
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing fraktionen")
+	}
+
+	var fraktionen []*Fraktion
+	for _, attrs := range objects {
+		fraktion := &Fraktion{}
+		err = readJson(app, attrs.Name, fraktion)
+		if err != nil {
+			return nil, err
+		}
+		fraktionen = append(fraktionen, fraktion)
+	}
+	return fraktionen, nil
+}
+
+// AntraegeOfFraktion returns the stored Anträge and Anfragen of the Fraktion with the name dated since,
+// the oldest first
+func AntraegeOfFraktion(app *App, name string, since time.Time) ([]Antrag, error) {
+
+	fraktionen, err := ListFraktionen(app)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Antrag
+	for _, f := range fraktionen {
+		if !strings.EqualFold(f.Name, strings.TrimSpace(name)) {
+			continue
+		}
+		for _, a := range f.Antraege {
+			if !a.Datum.Before(since) {
+				result = append(result, a)
+			}
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Datum.Before(result[j].Datum) })
+	return result, nil
+}
+
+// fraktionenDirectory caches the Fraktionen of the stored Anträge by VOLFDNR to cross-reference the Vorlagen
+type fraktionenDirectory struct {
+	mutex     sync.Mutex
+	byVorlage map[int][]string
+}
+
+// fraktionen returns the names of the Fraktionen with an Antrag of the Vorlage
+func (d *fraktionenDirectory) fraktionen(app *App, volfdnr int) []string {
+
+	if d == nil {
+		return nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.byVorlage == nil {
+		fraktionen, err := ListFraktionen(app)
+		if err != nil {
+			return nil
+		}
+		d.byVorlage = make(map[int][]string)
+		for _, f := range fraktionen {
+			for _, a := range f.Antraege {
+				if a.VOLFDNR > 0 {
+					d.byVorlage[a.VOLFDNR] = append(d.byVorlage[a.VOLFDNR], f.Name)
+				}
+			}
+		}
+	}
+	return d.byVorlage[volfdnr]
+}
+
+func (d *fraktionenDirectory) reset() {
+
+	if d == nil {
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.byVorlage = nil
+}
@@ -0,0 +1,47 @@
+package dpage_test
+
+import (
+	"github.com/rismaster/allris-dpage/dpage"
+	"testing"
+)
+
+func TestParseFraktion(t *testing.T) {
+
+	tests := []struct {
+		fixture string
+		want    dpage.Fraktion
+	}{
+		{
+			fixture: "fr020-31.html",
+			want: dpage.Fraktion{
+				FRLFDNR: 31,
+				Name:    "SPD-Fraktion",
+				Antraege: []dpage.Antrag{
+					{VOLFDNR: 4711, Nummer: "VO/2021/0815", Betreff: "Neubau Radweg Hauptstraße", Art: "Antrag", Datum: berlin(t, "01.03.2021 00:00")},
+				},
+			},
+		},
+		{
+			fixture: "fr020-32.html",
+			want: dpage.Fraktion{
+				FRLFDNR: 32,
+				Name:    "CDU-Fraktion",
+				Antraege: []dpage.Antrag{
+					{VOLFDNR: 4710, Nummer: "VO/2021/0810", Betreff: "Bericht zur Haushaltslage", Art: "Anfrage", Datum: berlin(t, "15.02.2021 00:00")},
+					{VOLFDNR: 4711, Nummer: "VO/2021/0815", Betreff: "Neubau Radweg Hauptstraße", Art: "Antrag", Datum: berlin(t, "01.03.2021 00:00")},
+				},
+			},
+		},
+	}
+
+	dates := testDates(t)
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			fraktion, err := dpage.ParseFraktion(fixture(t, tt.fixture), dates)
+			if err != nil {
+				t.Fatalf("error parsing: %v", err)
+			}
+			assertModel(t, fraktion, tt.want)
+		})
+	}
+}
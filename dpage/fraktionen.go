@@ -0,0 +1,185 @@
+package dpage
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common"
+	"github.com/rismaster/allris-common/common/slog"
+	"github.com/rismaster/allris-common/downloader"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const defaultFraktionenFolder = "fraktionen/"
+const defaultFraktionType = "fraktion"
+const defaultFraktionenListeType = "fraktionenliste"
+const defaultUrlFraktionenliste = "fr010.asp"
+const defaultUrlFraktionTmpl = "fr020.asp?FRLFDNR=%d"
+
+// FraktionConfig can be implemented by the Config to change the folder and urls of the Fraktionenliste
+type FraktionConfig interface {
+	GetFraktionenFolder() string
+	GetUrlFraktionenliste() string
+	GetUrlFraktionTmpl() string
+}
+
+type Fraktionenliste struct {
+	app *App
+}
+
+func NewFraktionenliste(app *App) Fraktionenliste {
+	return Fraktionenliste{
+		app: app,
+	}
+}
+
+func GetFraktionenFolder(app *App) string {
+	if fc, ok := app.Config.(FraktionConfig); ok && fc.GetFraktionenFolder() != "" {
+		return fc.GetFraktionenFolder()
+	}
+	return defaultFraktionenFolder
+}
+
+func getUrlFraktionenliste(app *App) string {
+	if fc, ok := app.Config.(FraktionConfig); ok && fc.GetUrlFraktionenliste() != "" {
+		return fc.GetUrlFraktionenliste()
+	}
+	return defaultUrlFraktionenliste
+}
+
+func getUrlFraktionTmpl(app *App) string {
+	if fc, ok := app.Config.(FraktionConfig); ok && fc.GetUrlFraktionTmpl() != "" {
+		return fc.GetUrlFraktionTmpl()
+	}
+	return defaultUrlFraktionTmpl
+}
+
+// SynchronizeSince downloads the pages of all Fraktionen with their Anträge and Anfragen, downloads the
+// Vorlagen of the Anträge dated after minTime to cross-reference them with the Fraktionen and moves the stored
+// Fraktionen missing in the RIS to the tombstones
+func (fl *Fraktionenliste) SynchronizeSince(minTime time.Time, redownload bool) error {
+
+	options, err := fl.fetch(redownload)
+	if err != nil {
+		return errors.Wrap(err, "error downloading fraktionenliste")
+	}
+
+	allFraktionenFromRis := make(map[string]bool)
+	var vorlagen []downloader.RisRessource
+	var failed []string
+	for _, frlfdnr := range options {
+		name := fmt.Sprintf("%s-%d", defaultFraktionType, frlfdnr)
+		allFraktionenFromRis[GetFraktionenFolder(fl.app)+name+htmlEnding] = true
+		allFraktionenFromRis[GetFraktionenFolder(fl.app)+name+jsonEnding] = true
+
+		fraktion, errFraktion := fl.download(frlfdnr, name, redownload)
+		if errFraktion != nil {
+			slog.Error("error downloading fraktion %d: %v", frlfdnr, errFraktion)
+			failed = append(failed, errFraktion.Error())
+			continue
+		}
+		for _, a := range fraktion.Antraege {
+			if a.VOLFDNR <= 0 || !a.Datum.After(minTime) {
+				continue
+			}
+			vorlage, errVorlage := newVorlageRessource(fl.app, a.VOLFDNR, a.Datum, redownload)
+			if errVorlage != nil {
+				slog.Error("error creating ressource of vorlage %d: %v", a.VOLFDNR, errVorlage)
+				continue
+			}
+			vorlagen = append(vorlagen, *vorlage)
+		}
+	}
+	fl.app.fraktionen.reset()
+
+	err = deleteFilesIfNotInAndAfter(fl.app, GetFraktionenFolder(fl.app), allFraktionenFromRis, []string{}, time.Time{}, "sync fraktionenliste")
+	if err != nil {
+		return errors.Wrap(err, "error deleting fraktionen")
+	}
+
+	publishErr := PublishRisDownload(fl.app, vorlagen)
+	if len(failed) > 0 {
+		return errors.New(fmt.Sprintf("error downloading %d fraktionen: %s", len(failed), strings.Join(failed, "; ")))
+	}
+	return publishErr
+}
+
+// fetch returns the FRLFDNR of all Fraktionen of the Fraktionenliste
+func (fl *Fraktionenliste) fetch(redownload bool) (options []int, err error) {
+
+	uri, err := url.Parse(fl.app.Config.GetTargetToParse() + getUrlFraktionenliste(fl.app))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse url")
+	}
+
+	srcWeb := downloader.NewRisRessource("", defaultFraktionenListeType, htmlEnding, time.Now(), uri, &url.Values{}, true, redownload)
+	targetStore := NewFile(fl.app, srcWeb)
+
+	err = targetStore.Fetch(httpGet, srcWeb, "text/html")
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error downloading Fraktionenliste from %s", uri))
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(targetStore.GetContent()))
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error create dom from %s", targetStore.GetName()))
+	}
+
+	seen := make(map[int]bool)
+	doc.Find("tr.zl11,tr.zl12").Each(func(index int, tr *goquery.Selection) {
+		href, _ := tr.Find("a[href*=\"FRLFDNR\"]").First().Attr("href")
+		frlfdnr := idFromHref(href, "FRLFDNR")
+		if frlfdnr > 0 && !seen[frlfdnr] {
+			seen[frlfdnr] = true
+			options = append(options, frlfdnr)
+		}
+	})
+
+	newHash := common.Md5HashB(targetStore.GetContent())
+	err = targetStore.WriteIfMoreActualAndDifferent(newHash)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error writing fraktionenliste %s", srcWeb.GetName()))
+	}
+
+	slog.Info("loaded %d Fraktionen from %s", len(options), uri)
+	return options, nil
+}
+
+// download stores the page and the model of the Fraktion
+func (fl *Fraktionenliste) download(frlfdnr int, name string, redownload bool) (*Fraktion, error) {
+
+	uri, err := url.Parse(fl.app.Config.GetTargetToParse() + fmt.Sprintf(getUrlFraktionTmpl(fl.app), frlfdnr))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse url")
+	}
+
+	srcWeb := downloader.NewRisRessource(GetFraktionenFolder(fl.app), name, htmlEnding, time.Now(), uri, &url.Values{}, redownload, redownload)
+	targetStore := NewFile(fl.app, srcWeb)
+
+	err = targetStore.Fetch(httpGet, srcWeb, "text/html")
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error downloading fraktion from %s", uri))
+	}
+
+	newHash := common.Md5HashB(targetStore.GetContent())
+	err = targetStore.WriteIfMoreActualAndDifferent(newHash)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error writing %s", targetStore.GetPath()))
+	}
+
+	dates, err := fl.app.Dates()
+	if err != nil {
+		return nil, err
+	}
+	fraktion, err := ParseFraktion(targetStore.GetContent(), dates)
+	if err != nil {
+		return nil, err
+	}
+	if fraktion.FRLFDNR == 0 {
+		fraktion.FRLFDNR = frlfdnr
+	}
+	return fraktion, writeJson(fl.app, GetFraktionenFolder(fl.app)+name+jsonEnding, time.Now(), fraktion)
+}
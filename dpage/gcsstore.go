@@ -0,0 +1,143 @@
+package dpage
+
+import (
+	"cloud.google.com/go/storage"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"google.golang.org/api/iterator"
+	"io/ioutil"
+	"time"
+)
+
+const metadataHash = "hash"
+const metadataFetchedAt = "fetchedAt"
+
+// GcsStore stores the objects gzipped in a Cloud Storage bucket with the attributes used by allris-common,
+// the RisTime is the CustomTime of the object
+type GcsStore struct {
+	ctx    context.Context
+	client *storage.Client
+	bucket string
+}
+
+func NewGcsStore(ctx context.Context, client *storage.Client, bucket string) *GcsStore {
+	return &GcsStore{
+		ctx:    ctx,
+		client: client,
+		bucket: bucket,
+	}
+}
+
+func (s *GcsStore) Attrs(path string) (*ObjectAttrs, error) {
+
+	attrs, err := s.client.Bucket(s.bucket).Object(path).Attrs(s.ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, ErrObjectNotExist
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading attrs of %s", path))
+	}
+	return gcsObjectAttrs(attrs), nil
+}
+
+func gcsObjectAttrs(attrs *storage.ObjectAttrs) *ObjectAttrs {
+
+	result := &ObjectAttrs{
+		Name:        attrs.Name,
+		ContentType: attrs.ContentType,
+		Hash:        attrs.Metadata[metadataHash],
+		RisTime:     attrs.CustomTime,
+		Updated:     attrs.Updated,
+		Metadata:    make(map[string]string),
+	}
+	result.FetchedAt, _ = time.Parse(time.RFC3339, attrs.Metadata[metadataFetchedAt])
+	for k, v := range attrs.Metadata {
+		if k != metadataHash && k != metadataFetchedAt {
+			result.Metadata[k] = v
+		}
+	}
+	return result
+}
+
+func (s *GcsStore) Read(path string) ([]byte, error) {
+
+	reader, err := s.client.Bucket(s.bucket).Object(path).NewReader(s.ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, ErrObjectNotExist
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading %s", path))
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
+
+func (s *GcsStore) Write(path string, content []byte, attrs *ObjectAttrs) error {
+
+	metadata := copyMetadata(attrs.Metadata)
+	metadata[metadataHash] = attrs.Hash
+	metadata[metadataFetchedAt] = attrs.FetchedAt.Format(time.RFC3339)
+
+	wc := s.client.Bucket(s.bucket).Object(path).NewWriter(s.ctx)
+	wc.ObjectAttrs = storage.ObjectAttrs{
+		Name:            path,
+		ContentLanguage: "de",
+		ContentType:     attrs.ContentType,
+		ContentEncoding: "gzip",
+		CustomTime:      attrs.RisTime,
+		Metadata:        metadata,
+	}
+
+	w := gzip.NewWriter(wc)
+	_, err := w.Write(content)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error writing %s", path))
+	}
+
+	err = w.Close()
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error writing %s", path))
+	}
+
+	return wc.Close()
+}
+
+func (s *GcsStore) Touch(path string) error {
+
+	_, err := s.client.Bucket(s.bucket).Object(path).Update(s.ctx, storage.ObjectAttrsToUpdate{})
+	if err == storage.ErrObjectNotExist {
+		return ErrObjectNotExist
+	}
+	return err
+}
+
+func (s *GcsStore) Delete(path string) error {
+
+	err := s.client.Bucket(s.bucket).Object(path).Delete(s.ctx)
+	if err == storage.ErrObjectNotExist {
+		return ErrObjectNotExist
+	}
+	return err
+}
+
+func (s *GcsStore) List(prefix string) (result []*ObjectAttrs, err error) {
+
+	it := s.client.Bucket(s.bucket).Objects(s.ctx, &storage.Query{
+		Prefix: prefix,
+	})
+
+	for {
+		attrs, errIt := it.Next()
+		if errIt == iterator.Done {
+			break
+		}
+		if errIt != nil {
+			return nil, errors.Wrap(errIt, fmt.Sprintf("error iterating objects %s", prefix))
+		}
+		result = append(result, gcsObjectAttrs(attrs))
+	}
+	return result, nil
+}
@@ -0,0 +1,165 @@
+package dpage
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common"
+	"github.com/rismaster/allris-common/common/slog"
+	"github.com/rismaster/allris-common/downloader"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const defaultGremienFolder = "gremien/"
+const defaultUrlGremiumTmpl = "gr020.asp?GRLFDNR=%d"
+const defaultUrlMitgliederTmpl = "pa021.asp?GRLFDNR=%d"
+const mitgliederSuffix = "-mitglieder"
+
+// GremiumConfig can be implemented by the Config to change the folder and urls of the Gremienliste
+type GremiumConfig interface {
+	GetGremienFolder() string
+	GetUrlGremiumTmpl() string
+	GetUrlMitgliederTmpl() string
+}
+
+// Gremienliste is the directory of the Gremien of the GRA select of the Sitzungsliste
+type Gremienliste struct {
+	app *App
+}
+
+func NewGremienliste(app *App) Gremienliste {
+	return Gremienliste{
+		app: app,
+	}
+}
+
+func GetGremienFolder(app *App) string {
+	if gc, ok := app.Config.(GremiumConfig); ok && gc.GetGremienFolder() != "" {
+		return gc.GetGremienFolder()
+	}
+	return defaultGremienFolder
+}
+
+func getUrlGremiumTmpl(app *App) string {
+	if gc, ok := app.Config.(GremiumConfig); ok && gc.GetUrlGremiumTmpl() != "" {
+		return gc.GetUrlGremiumTmpl()
+	}
+	return defaultUrlGremiumTmpl
+}
+
+func getUrlMitgliederTmpl(app *App) string {
+	if gc, ok := app.Config.(GremiumConfig); ok && gc.GetUrlMitgliederTmpl() != "" {
+		return gc.GetUrlMitgliederTmpl()
+	}
+	return defaultUrlMitgliederTmpl
+}
+
+// SynchronizeSince downloads the page and the members of every Gremium, stores them with the parsed Gremium and
+// moves the stored Gremien missing in the RIS to the tombstones. The Gremien have no dates, so minTime is not
+// used and every stored Gremium missing in the list is deleted. Afterwards the digests of the changes are sent
+// to the subscribers.
+func (gl *Gremienliste) SynchronizeSince(minTime time.Time, redownload bool) error {
+
+	sl := NewSitzungsliste(gl.app)
+	options, err := sl.fetchGremiumOptions(redownload)
+	if err != nil {
+		return errors.Wrap(err, "error fetching gremien")
+	}
+
+	allGremienFromRis := make(map[string]bool)
+	var failed []string
+	for _, option := range options {
+		pathes, errGremium := gl.download(option, redownload)
+		for _, p := range pathes {
+			allGremienFromRis[p] = true
+		}
+		if errGremium != nil {
+			slog.Error("error downloading gremium %d: %v", option.option, errGremium)
+			failed = append(failed, errGremium.Error())
+		}
+	}
+	gl.app.gremien.reset()
+
+	err = deleteFilesIfNotInAndAfter(gl.app, GetGremienFolder(gl.app), allGremienFromRis, []string{}, time.Time{}, "sync gremienliste")
+	if err != nil {
+		return errors.Wrap(err, "error deleting gremien")
+	}
+
+	_, err = PurgeTombstones(gl.app)
+	if err != nil {
+		slog.Error("error purging tombstones: %v", err)
+	}
+
+	_, err = SendDigests(gl.app)
+	if err != nil {
+		slog.Error("error sending digests: %v", err)
+	}
+
+	slog.Info("loaded %d Gremien", len(options))
+	if len(failed) > 0 {
+		return errors.New(fmt.Sprintf("error downloading %d gremien: %s", len(failed), strings.Join(failed, "; ")))
+	}
+	return nil
+}
+
+// download stores the pages and the model of the Gremium and returns their pathes, the pathes of a failed
+// Gremium are returned too so they are kept until the next sync
+func (gl *Gremienliste) download(option *gremiumOption, redownload bool) ([]string, error) {
+
+	name := fmt.Sprintf("%s-%d", gremiumType, option.option)
+	folder := GetGremienFolder(gl.app)
+	pathes := []string{folder + name + htmlEnding, folder + name + mitgliederSuffix + htmlEnding, folder + name + jsonEnding}
+
+	dates, err := gl.app.Dates()
+	if err != nil {
+		return pathes, err
+	}
+
+	page, err := gl.fetchPage(name, fmt.Sprintf(getUrlGremiumTmpl(gl.app), option.option), redownload)
+	if err != nil {
+		return pathes, err
+	}
+	gremium, err := ParseGremium(page, dates)
+	if err != nil {
+		return pathes, err
+	}
+
+	page, err = gl.fetchPage(name+mitgliederSuffix, fmt.Sprintf(getUrlMitgliederTmpl(gl.app), option.option), redownload)
+	if err != nil {
+		return pathes, err
+	}
+	gremium.Mitglieder, err = ParseMitglieder(page, dates)
+	if err != nil {
+		return pathes, err
+	}
+
+	gremium.ID = option.option
+	if gremium.Name == "" {
+		gremium.Name = option.name
+	}
+	return pathes, writeJson(gl.app, folder+name+jsonEnding, time.Now(), gremium)
+}
+
+func (gl *Gremienliste) fetchPage(name string, ressourceUrl string, redownload bool) ([]byte, error) {
+
+	uri, err := url.Parse(gl.app.Config.GetTargetToParse() + ressourceUrl)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse url")
+	}
+
+	srcWeb := downloader.NewRisRessource(GetGremienFolder(gl.app), name, htmlEnding, time.Now(), uri, &url.Values{}, redownload, redownload)
+	targetStore := NewFile(gl.app, srcWeb)
+
+	err = targetStore.Fetch(httpGet, srcWeb, "text/html")
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error downloading %s", ressourceUrl))
+	}
+
+	newHash := common.Md5HashB(targetStore.GetContent())
+	err = targetStore.WriteIfMoreActualAndDifferent(newHash)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error writing %s", targetStore.GetPath()))
+	}
+	return targetStore.GetContent(), nil
+}
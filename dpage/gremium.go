@@ -0,0 +1,168 @@
+package dpage
+
+import (
+	"bytes"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/domtools"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Gremium is the parsed content of the gr020 page of a Gremium with the members of its pa021 page. The ID
+// is the option of the Gremium in the Sitzungsliste.
+type Gremium struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Kurzname   string     `json:"kurzname,omitempty"`
+	Art        string     `json:"art,omitempty"`
+	Aktiv      bool       `json:"aktiv"`
+	Mitglieder []Mitglied `json:"mitglieder,omitempty"`
+}
+
+// Mitglied is a Person in a Gremium, Bis is zero for current members
+type Mitglied struct {
+	KPLFDNR int       `json:"kplfdnr,omitempty"`
+	Name    string    `json:"name"`
+	Rolle   string    `json:"rolle,omitempty"`
+	Von     time.Time `json:"von"`
+	Bis     time.Time `json:"bis"`
+}
+
+func ParseGremium(html []byte, dates *RisDates) (*Gremium, error) {
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(html))
+	if err != nil {
+		return nil, errors.Wrap(err, "error create dom from gremium")
+	}
+
+	container := doc.Find("#allriscontainer")
+	if container.Size() == 0 {
+		return nil, errors.New("no allriscontainer in gremium")
+	}
+
+	labels := labelValues(container)
+
+	gremium := &Gremium{
+		Name:     labels["Name"],
+		Kurzname: labels["Kurzbezeichnung"],
+		Art:      labels["Gremienart"],
+		Aktiv:    true,
+	}
+
+	if gremium.Name == "" {
+		title := domtools.CleanText(doc.Find("#risname h1").Text())
+		if i := strings.Index(title, " - "); i >= 0 {
+			gremium.Name = strings.TrimSpace(title[i+3:])
+		}
+	}
+
+	status := strings.ToLower(labels["Status"])
+	bis := dates.parse(labels["Bis"])
+	if strings.Contains(status, "inaktiv") || strings.Contains(status, "aufgelöst") || (!bis.IsZero() && bis.Before(time.Now())) {
+		gremium.Aktiv = false
+	}
+
+	return gremium, nil
+}
+
+// ParseMitglieder reads the members of the pa021 page of a Gremium
+func ParseMitglieder(html []byte, dates *RisDates) ([]Mitglied, error) {
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(html))
+	if err != nil {
+		return nil, errors.Wrap(err, "error create dom from mitglieder")
+	}
+
+	var mitglieder []Mitglied
+	doc.Find("#allriscontainer table.tl1 tr").Each(func(i int, tr *goquery.Selection) {
+		tds := tr.Children().Filter("td")
+		if tds.Size() < 4 {
+			return
+		}
+		href, _ := tds.Eq(0).Find("a").Attr("href")
+		mitglied := Mitglied{
+			KPLFDNR: idFromHref(href, "KPLFDNR"),
+			Name:    domtools.CleanText(tds.Eq(0).Text()),
+			Rolle:   domtools.CleanText(tds.Eq(1).Text()),
+			Von:     dates.parse(domtools.CleanText(tds.Eq(2).Text())),
+			Bis:     dates.parse(domtools.CleanText(tds.Eq(3).Text())),
+		}
+		if mitglied.Name != "" {
+			mitglieder = append(mitglieder, mitglied)
+		}
+	})
+	return mitglieder, nil
+}
+
+// Matches is true if name is the name or short name of the Gremium
+func (g *Gremium) Matches(name string) bool {
+	name = strings.TrimSpace(name)
+	return name != "" && (strings.EqualFold(g.Name, name) || strings.EqualFold(g.Kurzname, name))
+}
+
+// ListGremien returns the stored Gremien of the Gremienliste
+func ListGremien(app *App) ([]*Gremium, error) {
+
+	objects, err := listJsons(app, GetGremienFolder(app))
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing gremien")
+	}
+
+	var gremien []*Gremium
+	for _, attrs := range objects {
+		gremium := &Gremium{}
+		err = readJson(app, attrs.Name, gremium)
+		if err != nil {
+			return nil, err
+		}
+		gremien = append(gremien, gremium)
+	}
+	return gremien, nil
+}
+
+// gremienDirectory caches the stored Gremien of an App to label the Sitzungen with their Gremium
+type gremienDirectory struct {
+	mutex   sync.Mutex
+	gremien []*Gremium
+	loaded  bool
+}
+
+// id returns the ID of the stored Gremium with the name, 0 if there is none
+func (d *gremienDirectory) id(app *App, name string) int {
+
+	if d == nil {
+		return 0
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if !d.loaded {
+		gremien, err := ListGremien(app)
+		if err != nil {
+			return 0
+		}
+		d.gremien = gremien
+		d.loaded = true
+	}
+
+	for _, g := range d.gremien {
+		if g.Matches(name) {
+			return g.ID
+		}
+	}
+	return 0
+}
+
+func (d *gremienDirectory) reset() {
+
+	if d == nil {
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.gremien = nil
+	d.loaded = false
+}
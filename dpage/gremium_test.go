@@ -0,0 +1,70 @@
+package dpage_test
+
+import (
+	"github.com/rismaster/allris-dpage/dpage"
+	"testing"
+)
+
+func TestParseGremium(t *testing.T) {
+
+	tests := []struct {
+		fixture string
+		want    dpage.Gremium
+	}{
+		{fixture: "gr020-1.html", want: dpage.Gremium{Name: "Bau- und Umweltausschuss", Kurzname: "BUA", Art: "Ausschuss", Aktiv: true}},
+		{fixture: "gr020-2.html", want: dpage.Gremium{Name: "Rat der Stadt", Kurzname: "Rat", Art: "Rat", Aktiv: true}},
+		// Status inaktiv
+		{fixture: "gr020-1001.html", want: dpage.Gremium{Name: "Seniorenbeirat", Kurzname: "SBR", Art: "Beirat"}},
+	}
+
+	dates := testDates(t)
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			gremium, err := dpage.ParseGremium(fixture(t, tt.fixture), dates)
+			if err != nil {
+				t.Fatalf("error parsing: %v", err)
+			}
+			assertModel(t, gremium, tt.want)
+		})
+	}
+}
+
+func TestParseMitglieder(t *testing.T) {
+
+	tests := []struct {
+		fixture string
+		want    []dpage.Mitglied
+	}{
+		{
+			fixture: "pa021-1.html",
+			want: []dpage.Mitglied{
+				{KPLFDNR: 101, Name: "Müller, Anna", Rolle: "Vorsitzende", Von: berlin(t, "01.11.2019 00:00")},
+			},
+		},
+		{
+			fixture: "pa021-2.html",
+			want: []dpage.Mitglied{
+				{KPLFDNR: 101, Name: "Müller, Anna", Rolle: "Ratsmitglied", Von: berlin(t, "01.11.2014 00:00")},
+				{KPLFDNR: 102, Name: "Schmidt, Peter", Rolle: "Ratsmitglied", Von: berlin(t, "01.11.2019 00:00")},
+			},
+		},
+		{
+			// a former member without link to a person
+			fixture: "pa021-1001.html",
+			want: []dpage.Mitglied{
+				{Name: "Weber, Ilse", Rolle: "Mitglied", Von: berlin(t, "01.01.2010 00:00"), Bis: berlin(t, "31.12.2015 00:00")},
+			},
+		},
+	}
+
+	dates := testDates(t)
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			mitglieder, err := dpage.ParseMitglieder(fixture(t, tt.fixture), dates)
+			if err != nil {
+				t.Fatalf("error parsing: %v", err)
+			}
+			assertModel(t, mitglieder, tt.want)
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package dpage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/slog"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultICalFolder = "ical/"
+const icalDateTimeFormat = "20060102T150405"
+const icalAlleName = "alle"
+const gremiumType = "gremium"
+
+// ICalConfig can be implemented by the Config to store the iCalendar feeds in another folder
+type ICalConfig interface {
+	GetICalFolder() string
+}
+
+type icalEvent struct {
+	uid         string
+	summary     string
+	location    string
+	description string
+	url         string
+	start       time.Time
+	ende        time.Time
+	stamp       time.Time
+	gremiumID   int
+	gremium     string
+}
+
+func GetICalFolder(app *App) string {
+	if ic, ok := app.Config.(ICalConfig); ok && ic.GetICalFolder() != "" {
+		return ic.GetICalFolder()
+	}
+	return defaultICalFolder
+}
+
+// ExportICal writes one iCalendar feed per Gremium and one with all Sitzungen and Kalender-Eintraege
+// from the stored models. A feed is only written if its content changed.
+func (sl *Sitzungsliste) ExportICal(redownload bool) error {
+	return sl.exportICal(redownload, nil)
+}
+
+// icalGremien are the Gremien of the changed Sitzungen and Kalendereintraege by id and name
+type icalGremien struct {
+	ids   map[int]bool
+	names map[string]bool
+}
+
+func (g *icalGremien) contains(gremium *gremiumOption) bool {
+	return g.ids[gremium.option] || g.names[gremium.name]
+}
+
+// updateICal re-renders the feed with all Sitzungen and the feeds of the Gremien of the Sitzungen and
+// Kalendereintraege written by the sync, nothing is written if none changed
+func (sl *Sitzungsliste) updateICal() error {
+
+	events := sl.app.ical.take()
+	if len(events) == 0 {
+		return nil
+	}
+
+	changed := &icalGremien{ids: make(map[int]bool), names: make(map[string]bool)}
+	for _, event := range events {
+		var model struct {
+			GremiumID int    `json:"gremiumId"`
+			Gremium   string `json:"gremium"`
+		}
+		err := sl.readICalModel(event, &model)
+		if err != nil {
+			slog.Warn("ignore %s in ical feeds: %v", event.Path, err)
+			continue
+		}
+		if model.GremiumID > 0 {
+			changed.ids[model.GremiumID] = true
+		}
+		if model.Gremium != "" {
+			changed.names[model.Gremium] = true
+		}
+	}
+	return sl.exportICal(false, changed)
+}
+
+// readICalModel reads the model of the event, a deleted model from its tombstone
+func (sl *Sitzungsliste) readICalModel(event *ChangeEvent, v interface{}) error {
+
+	if event.Type != EventDeleted {
+		return readJson(sl.app, event.Path, v)
+	}
+	latest, err := latestTombstone(sl.app, event.Path)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error reading tombstone of %s", event.Path))
+	}
+	content, err := sl.app.Backup.Read(latest.Attrs.Name)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error reading tombstone of %s", event.Path))
+	}
+	err = json.Unmarshal(content, v)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error unmarshalling tombstone of %s", event.Path))
+	}
+	return nil
+}
+
+// exportICal writes the feed with all Sitzungen and the feeds of the changed Gremien, all Gremien if changed
+// is nil
+func (sl *Sitzungsliste) exportICal(redownload bool, changed *icalGremien) error {
+
+	gremien, err := sl.fetchGremiumOptions(redownload)
+	if err != nil {
+		return errors.Wrap(err, "error fetching gremien")
+	}
+
+	events, err := sl.loadICalEvents()
+	if err != nil {
+		return err
+	}
+
+	dates, err := sl.app.Dates()
+	if err != nil {
+		return err
+	}
+	location := dates.Location()
+
+	folder := GetICalFolder(sl.app)
+	err = writeObject(sl.app, folder+icalAlleName+".ics", "text/calendar; charset=utf-8", time.Now(), renderICal("Alle Sitzungen", location, events))
+	if err != nil {
+		return errors.Wrap(err, "error writing ical feed")
+	}
+
+	feeds := 1
+	for _, gremium := range gremien {
+		if changed != nil && !changed.contains(gremium) {
+			continue
+		}
+
+		var gremiumEvents []icalEvent
+		for _, e := range events {
+			if e.gremiumID == gremium.option || (e.gremiumID == 0 && e.gremium != "" && e.gremium == gremium.name) {
+				gremiumEvents = append(gremiumEvents, e)
+			}
+		}
+
+		name := fmt.Sprintf("%s%s-%d.ics", folder, gremiumType, gremium.option)
+		err = writeObject(sl.app, name, "text/calendar; charset=utf-8", time.Now(), renderICal(gremium.name, location, gremiumEvents))
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error writing ical feed of gremium %d", gremium.option))
+		}
+		feeds++
+	}
+
+	slog.Info("exported %d events to %d ical feeds", len(events), feeds)
+	return nil
+}
+
+func (sl *Sitzungsliste) loadICalEvents() (events []icalEvent, err error) {
+
+	host := ""
+	if uri, errUrl := url.Parse(sl.app.Config.GetTargetToParse()); errUrl == nil {
+		host = uri.Host
+	}
+
+	sitzungObjects, err := listJsons(sl.app, sl.app.Config.GetSitzungenFolder())
+	if err != nil {
+		return nil, err
+	}
+	for _, attrs := range sitzungObjects {
+		var sitzung Sitzung
+		err = readJson(sl.app, attrs.Name, &sitzung)
+		if err != nil {
+			slog.Warn("ignore sitzung %s: %v", attrs.Name, err)
+			continue
+		}
+
+		var location []string
+		for _, l := range []string{sitzung.Raum, sitzung.Ort} {
+			if l != "" {
+				location = append(location, l)
+			}
+		}
+
+		events = append(events, icalEvent{
+			uid:         fmt.Sprintf("%s-%d@%s", sl.app.Config.GetSitzungType(), sitzung.SILFDNR, host),
+			summary:     sitzung.Bezeichnung,
+			location:    strings.Join(location, ", "),
+			description: sitzung.Gremium,
+			url:         sl.app.Config.GetTargetToParse() + fmt.Sprintf(sl.app.Config.GetUrlSitzungTmpl(), sitzung.SILFDNR),
+			start:       sitzung.Start,
+			ende:        sitzung.Ende,
+			stamp:       attrs.Updated,
+			gremiumID:   sitzung.GremiumID,
+			gremium:     sitzung.Gremium,
+		})
+	}
+
+	kalenderObjects, err := listJsons(sl.app, GetKalenderFolder(sl.app))
+	if err != nil {
+		return nil, err
+	}
+	for _, attrs := range kalenderObjects {
+		var eintrag Kalendereintrag
+		err = readJson(sl.app, attrs.Name, &eintrag)
+		if err != nil {
+			slog.Warn("ignore kalendereintrag %s: %v", attrs.Name, err)
+			continue
+		}
+
+		events = append(events, icalEvent{
+			uid:         fmt.Sprintf("%s-%s@%s", kalenderType, eintrag.ID, host),
+			summary:     eintrag.Titel,
+			location:    eintrag.Raum,
+			description: eintrag.Gremium,
+			start:       eintrag.Start,
+			ende:        eintrag.Ende,
+			stamp:       attrs.Updated,
+			gremiumID:   eintrag.GremiumID,
+			gremium:     eintrag.Gremium,
+		})
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].start.Equal(events[j].start) {
+			return events[i].uid < events[j].uid
+		}
+		return events[i].start.Before(events[j].start)
+	})
+	return events, nil
+}
+
+// renderICal renders the events as RFC 5545 calendar with the times in the given location
+func renderICal(name string, location *time.Location, events []icalEvent) []byte {
+
+	var b bytes.Buffer
+	line := func(format string, a ...interface{}) {
+		writeICalLine(&b, fmt.Sprintf(format, a...))
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//rismaster//allris-dpage//DE")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	line("X-WR-CALNAME:%s", escapeICalText(name))
+	line("X-WR-TIMEZONE:%s", location.String())
+
+	if len(events) > 0 {
+		renderVTimezone(line, location, events[0].start, events[len(events)-1].start)
+	}
+
+	for _, e := range events {
+		line("BEGIN:VEVENT")
+		line("UID:%s", e.uid)
+		line("DTSTAMP:%s", e.stamp.UTC().Format(icalDateTimeFormat+"Z"))
+		line("DTSTART;TZID=%s:%s", location.String(), e.start.In(location).Format(icalDateTimeFormat))
+		if !e.ende.IsZero() && e.ende.After(e.start) {
+			line("DTEND;TZID=%s:%s", location.String(), e.ende.In(location).Format(icalDateTimeFormat))
+		}
+		line("SUMMARY:%s", escapeICalText(e.summary))
+		if e.location != "" {
+			line("LOCATION:%s", escapeICalText(e.location))
+		}
+		if e.description != "" {
+			line("DESCRIPTION:%s", escapeICalText(e.description))
+		}
+		if e.url != "" {
+			line("URL:%s", e.url)
+		}
+		line("END:VEVENT")
+	}
+
+	line("END:VCALENDAR")
+	return b.Bytes()
+}
+
+// renderVTimezone writes the offsets and all transitions of the location between the years of from and to
+func renderVTimezone(line func(format string, a ...interface{}), location *time.Location, from time.Time, to time.Time) {
+
+	begin := time.Date(from.In(location).Year(), 1, 1, 0, 0, 0, 0, location)
+	end := time.Date(to.In(location).Year()+1, 1, 1, 0, 0, 0, 0, location)
+
+	component := func(t time.Time, offsetFrom int) {
+		name, offset := t.Zone()
+		kind := "STANDARD"
+		if t.IsDST() {
+			kind = "DAYLIGHT"
+		}
+		line("BEGIN:%s", kind)
+		line("DTSTART:%s", t.UTC().Add(time.Duration(offsetFrom)*time.Second).Format(icalDateTimeFormat))
+		line("TZOFFSETFROM:%s", formatICalOffset(offsetFrom))
+		line("TZOFFSETTO:%s", formatICalOffset(offset))
+		line("TZNAME:%s", name)
+		line("END:%s", kind)
+	}
+
+	line("BEGIN:VTIMEZONE")
+	line("TZID:%s", location.String())
+
+	_, offset := begin.Zone()
+	component(begin, offset)
+
+	for day := begin; day.Before(end); day = day.Add(24 * time.Hour) {
+		next := day.Add(24 * time.Hour)
+		_, nextOffset := next.Zone()
+		if nextOffset == offset {
+			continue
+		}
+		// find the exact transition between day and next
+		lo, hi := day, next
+		for hi.Sub(lo) > time.Minute {
+			mid := lo.Add(hi.Sub(lo) / 2)
+			if _, o := mid.Zone(); o == offset {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		transition := hi.Truncate(time.Minute)
+		component(transition, offset)
+		offset = nextOffset
+	}
+
+	line("END:VTIMEZONE")
+}
+
+func formatICalOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, offset/3600, offset%3600/60)
+}
+
+func escapeICalText(text string) string {
+	return strings.NewReplacer("\\", "\\\\", ";", "\\;", ",", "\\,", "\r\n", "\\n", "\n", "\\n").Replace(text)
+}
+
+// writeICalLine folds lines longer than 75 octets without splitting utf-8 characters
+func writeICalLine(b *bytes.Buffer, text string) {
+
+	lineLength := 0
+	for _, r := range text {
+		runeLength := len(string(r))
+		if lineLength+runeLength > 75 {
+			b.WriteString("\r\n ")
+			lineLength = 1
+		}
+		b.WriteRune(r)
+		lineLength += runeLength
+	}
+	b.WriteString("\r\n")
+}
@@ -0,0 +1,155 @@
+package dpage_test
+
+import (
+	"github.com/rismaster/allris-dpage/dpage"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestWriteICalLine(t *testing.T) {
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "short", text: "SUMMARY:Rat der Stadt", want: "SUMMARY:Rat der Stadt\r\n"},
+		{name: "75 octets", text: strings.Repeat("a", 75), want: strings.Repeat("a", 75) + "\r\n"},
+		{name: "76 octets", text: strings.Repeat("a", 76), want: strings.Repeat("a", 75) + "\r\n a\r\n"},
+		{name: "two folds", text: strings.Repeat("a", 150), want: strings.Repeat("a", 75) + "\r\n " + strings.Repeat("a", 74) + "\r\n a\r\n"},
+		// ä is two octets, it would end at octet 76
+		{name: "umlaut at the fold", text: strings.Repeat("a", 74) + "ä", want: strings.Repeat("a", 74) + "\r\n ä\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := dpage.WriteICalLine(tt.text)
+			if got != tt.want {
+				t.Errorf("line is %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteICalLineLongText(t *testing.T) {
+
+	text := "DESCRIPTION:" + strings.Repeat("Grundstücksangelegenheiten für Straßenbau, ", 10)
+	folded := dpage.WriteICalLine(text)
+
+	for _, line := range strings.Split(strings.TrimSuffix(folded, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line of %d octets: %q", len(line), line)
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("line splits a character: %q", line)
+		}
+	}
+	if unfolded := strings.ReplaceAll(strings.TrimSuffix(folded, "\r\n"), "\r\n ", ""); unfolded != text {
+		t.Errorf("unfolded line is %q, want %q", unfolded, text)
+	}
+}
+
+func TestEscapeICalText(t *testing.T) {
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "Rathaus, Markt 1", want: `Rathaus\, Markt 1`},
+		{text: "Bau; Umwelt", want: `Bau\; Umwelt`},
+		{text: `C:\Sitzung`, want: `C:\\Sitzung`},
+		{text: "Zeile 1\nZeile 2\r\nZeile 3", want: `Zeile 1\nZeile 2\nZeile 3`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := dpage.EscapeICalText(tt.text); got != tt.want {
+				t.Errorf("escaped text is %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderICalTimezone(t *testing.T) {
+
+	location, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("error loading timezone: %v", err)
+	}
+	ics := string(dpage.RenderICal("Rat der Stadt", location, berlin(t, "12.04.2021 18:00"), berlin(t, "15.11.2021 17:00")))
+
+	vtimezone := ics[strings.Index(ics, "BEGIN:VTIMEZONE"):strings.Index(ics, "END:VTIMEZONE")]
+	want := strings.Join([]string{
+		"BEGIN:VTIMEZONE",
+		"TZID:Europe/Berlin",
+		"BEGIN:STANDARD",
+		"DTSTART:20210101T000000",
+		"TZOFFSETFROM:+0100",
+		"TZOFFSETTO:+0100",
+		"TZNAME:CET",
+		"END:STANDARD",
+		"BEGIN:DAYLIGHT",
+		"DTSTART:20210328T020000",
+		"TZOFFSETFROM:+0100",
+		"TZOFFSETTO:+0200",
+		"TZNAME:CEST",
+		"END:DAYLIGHT",
+		"BEGIN:STANDARD",
+		"DTSTART:20211031T030000",
+		"TZOFFSETFROM:+0200",
+		"TZOFFSETTO:+0100",
+		"TZNAME:CET",
+		"END:STANDARD",
+		"",
+	}, "\r\n")
+	if vtimezone != want {
+		t.Errorf("vtimezone is\n%s\nwant\n%s", vtimezone, want)
+	}
+
+	for _, line := range []string{
+		"DTSTART;TZID=Europe/Berlin:20210412T180000\r\n",
+		"DTEND;TZID=Europe/Berlin:20210412T190000\r\n",
+		"DTSTART;TZID=Europe/Berlin:20211115T170000\r\n",
+		"DTSTAMP:20211115T160000Z\r\n",
+	} {
+		if !strings.Contains(ics, line) {
+			t.Errorf("no %q in\n%s", line, ics)
+		}
+	}
+}
+
+func TestExportICal(t *testing.T) {
+
+	env := newEnv(t)
+	sl := dpage.NewSitzungsliste(env.App)
+	err := sl.SynchronizeSince(time.Time{}, false)
+	if err != nil {
+		t.Fatalf("error synchronizing: %v", err)
+	}
+
+	content, ok := env.Storage.Content(env.Config.BucketFetched, "ical/gremium-1.ics")
+	if !ok {
+		t.Fatal("no feed of gremium 1")
+	}
+	ics := string(content)
+	if !strings.HasPrefix(ics, "BEGIN:VCALENDAR\r\n") || !strings.HasSuffix(ics, "END:VCALENDAR\r\n") {
+		t.Errorf("feed is no calendar:\n%s", ics)
+	}
+	for _, line := range []string{
+		"X-WR-CALNAME:Bau- und Umweltausschuss\r\n",
+		"DTSTART;TZID=Europe/Berlin:20210412T180000\r\n",
+		"DTEND;TZID=Europe/Berlin:20210412T201500\r\n",
+		"SUMMARY:12. Sitzung des Bau- und Umweltausschusses\r\n",
+		`LOCATION:Ratssaal\, Rathaus\, Markt 1` + "\r\n",
+		"SUMMARY:Fraktionssitzung\r\n",
+	} {
+		if !strings.Contains(ics, line) {
+			t.Errorf("no %q in\n%s", line, ics)
+		}
+	}
+	if strings.Contains(ics, "Rat der Stadt") {
+		t.Errorf("feed of gremium 1 has a Sitzung of gremium 2:\n%s", ics)
+	}
+}
@@ -0,0 +1,81 @@
+package dpage
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common"
+	"github.com/rismaster/allris-common/common/domtools"
+	"time"
+)
+
+const defaultKalenderFolder = "kalender/"
+const kalenderType = "kalender"
+
+// KalenderConfig can be implemented by the Config to store the Kalender-Eintraege in another folder
+type KalenderConfig interface {
+	GetKalenderFolder() string
+}
+
+// Kalendereintrag is a row of the Sitzungsliste without linked Sitzung, e.g. a Fraktionssitzung
+type Kalendereintrag struct {
+	ID        string    `json:"id"`
+	Titel     string    `json:"titel"`
+	GremiumID int       `json:"gremiumId,omitempty"`
+	Gremium   string    `json:"gremium,omitempty"`
+	Start     time.Time `json:"start"`
+	Ende      time.Time `json:"ende"`
+	Raum      string    `json:"raum,omitempty"`
+}
+
+// newKalendereintrag creates the Kalendereintrag of a row, the rows have no RIS id so the id is derived from the
+// start, the title and the Gremium
+func newKalendereintrag(row *Sitzung) *Kalendereintrag {
+
+	eintrag := &Kalendereintrag{
+		Titel:     domtools.CleanText(row.Bezeichnung),
+		GremiumID: row.GremiumID,
+		Gremium:   row.Gremium,
+		Start:     row.Start,
+		Ende:      row.Ende,
+		Raum:      row.Raum,
+	}
+	eintrag.ID = fmt.Sprintf("%s-%s", eintrag.Start.Format("200601021504"), common.Md5HashStr(eintrag.Titel + "|" + eintrag.Gremium)[:8])
+	return eintrag
+}
+
+func GetKalenderFolder(app *App) string {
+	if kc, ok := app.Config.(KalenderConfig); ok && kc.GetKalenderFolder() != "" {
+		return kc.GetKalenderFolder()
+	}
+	return defaultKalenderFolder
+}
+
+func (k *Kalendereintrag) GetPath(app *App) string {
+	return fmt.Sprintf("%s%s-%s%s", GetKalenderFolder(app), kalenderType, k.ID, jsonEnding)
+}
+
+// writeKalender stores every Kalendereintrag as json and returns the written pathes
+func writeKalender(app *App, eintraege []*Kalendereintrag) (map[string]bool, error) {
+
+	pathes := make(map[string]bool)
+	for _, eintrag := range eintraege {
+		err := writeJson(app, eintrag.GetPath(app), eintrag.Start, eintrag)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("error writing kalendereintrag %s", eintrag.ID))
+		}
+		pathes[eintrag.GetPath(app)] = true
+	}
+	return pathes, nil
+}
+
+// firstKalenderStart is the time before the earliest Kalendereintrag, stored ones after it are compared with the list
+func firstKalenderStart(eintraege []*Kalendereintrag) time.Time {
+
+	first := eintraege[0].Start
+	for _, eintrag := range eintraege[1:] {
+		if eintrag.Start.Before(first) {
+			first = eintrag.Start
+		}
+	}
+	return first.Add(-time.Second)
+}
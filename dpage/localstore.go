@@ -0,0 +1,187 @@
+package dpage
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// localMetaFolder holds the attributes of the objects as json, next to the objects in the root folder
+const localMetaFolder = ".meta"
+
+// LocalStore stores the objects as files below a root folder
+type LocalStore struct {
+	root  string
+	mutex sync.Mutex
+}
+
+func NewLocalStore(root string) *LocalStore {
+	return &LocalStore{
+		root: root,
+	}
+}
+
+func (s *LocalStore) contentPath(path string) string {
+	return filepath.Join(s.root, filepath.FromSlash(path))
+}
+
+func (s *LocalStore) metaPath(path string) string {
+	return filepath.Join(s.root, localMetaFolder, filepath.FromSlash(path)+jsonEnding)
+}
+
+func (s *LocalStore) Attrs(path string) (*ObjectAttrs, error) {
+
+	content, err := ioutil.ReadFile(s.metaPath(path))
+	if os.IsNotExist(err) {
+		return nil, ErrObjectNotExist
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading attrs of %s", path))
+	}
+
+	var attrs ObjectAttrs
+	err = json.Unmarshal(content, &attrs)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error unmarshalling attrs of %s", path))
+	}
+	attrs.Name = path
+	return &attrs, nil
+}
+
+func (s *LocalStore) Read(path string) ([]byte, error) {
+
+	content, err := ioutil.ReadFile(s.contentPath(path))
+	if os.IsNotExist(err) {
+		return nil, ErrObjectNotExist
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading %s", path))
+	}
+	return content, nil
+}
+
+func (s *LocalStore) Write(path string, content []byte, attrs *ObjectAttrs) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := writeFile(s.contentPath(path), content)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error writing %s", path))
+	}
+
+	stored := *attrs
+	stored.Name = path
+	stored.Updated = time.Now()
+	return s.writeAttrs(&stored)
+}
+
+func (s *LocalStore) writeAttrs(attrs *ObjectAttrs) error {
+
+	content, err := json.Marshal(attrs)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error marshalling attrs of %s", attrs.Name))
+	}
+
+	err = writeFile(s.metaPath(attrs.Name), content)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error writing attrs of %s", attrs.Name))
+	}
+	return nil
+}
+
+// writeFile writes to a temporary file first, so readers never see a partially written file
+func writeFile(name string, content []byte) error {
+
+	err := os.MkdirAll(filepath.Dir(name), 0755)
+	if err != nil {
+		return err
+	}
+
+	tmp := name + ".tmp"
+	err = ioutil.WriteFile(tmp, content, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func (s *LocalStore) Touch(path string) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	attrs, err := s.Attrs(path)
+	if err != nil {
+		return err
+	}
+	attrs.Updated = time.Now()
+	return s.writeAttrs(attrs)
+}
+
+func (s *LocalStore) Delete(path string) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := os.Remove(s.contentPath(path))
+	if os.IsNotExist(err) {
+		return ErrObjectNotExist
+	}
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error deleting %s", path))
+	}
+
+	err = os.Remove(s.metaPath(path))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, fmt.Sprintf("error deleting attrs of %s", path))
+	}
+	return nil
+}
+
+func (s *LocalStore) List(prefix string) (result []*ObjectAttrs, err error) {
+
+	metaRoot := filepath.Join(s.root, localMetaFolder)
+	err = filepath.Walk(metaRoot, func(name string, info os.FileInfo, errWalk error) error {
+		if os.IsNotExist(errWalk) {
+			return nil
+		}
+		if errWalk != nil {
+			return errWalk
+		}
+		if info.IsDir() || !strings.HasSuffix(name, jsonEnding) {
+			return nil
+		}
+
+		rel, errRel := filepath.Rel(metaRoot, name)
+		if errRel != nil {
+			return errRel
+		}
+		path := strings.TrimSuffix(filepath.ToSlash(rel), jsonEnding)
+		if !strings.HasPrefix(path, prefix) {
+			return nil
+		}
+
+		attrs, errAttrs := s.Attrs(path)
+		if errAttrs != nil {
+			return errAttrs
+		}
+		result = append(result, attrs)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error listing %s", prefix))
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
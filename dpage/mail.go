@@ -0,0 +1,177 @@
+package dpage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/mailgun/mailgun-go/v4"
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const mailerMailgun = "mailgun"
+const mailerSmtp = "smtp"
+const mailerFile = "file"
+
+const mailgunTimeout = 30 * time.Second
+
+// Mail is a message with a text and a html part
+type Mail struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	Html    string
+}
+
+// Mailer delivers the digest mails
+type Mailer interface {
+	Send(mail *Mail) error
+}
+
+// newMailer creates the mailer of the DigestConfig, nil without DigestConfig. The mailgun mailer uses the
+// mail domain and api key of the Config.
+func newMailer(conf allris_common.Config) (Mailer, error) {
+
+	dc, ok := conf.(DigestConfig)
+	if !ok || dc.GetDigestMailer() == "" {
+		return nil, nil
+	}
+
+	switch dc.GetDigestMailer() {
+	case mailerMailgun:
+		return NewMailgunMailer(conf.GetMailDomain(), conf.GetMailApiString()), nil
+	case mailerSmtp:
+		return NewSmtpMailer(dc.GetDigestTarget(), nil), nil
+	case mailerFile:
+		return NewFileMailer(dc.GetDigestTarget())
+	}
+	return nil, errors.New(fmt.Sprintf("unknown digest mailer %s", dc.GetDigestMailer()))
+}
+
+// MailgunMailer sends the mails with the api of mailgun
+type MailgunMailer struct {
+	mg *mailgun.MailgunImpl
+}
+
+func NewMailgunMailer(domain string, apiKey string) *MailgunMailer {
+	return &MailgunMailer{mg: mailgun.NewMailgun(domain, apiKey)}
+}
+
+func (m *MailgunMailer) Send(mail *Mail) error {
+
+	message := m.mg.NewMessage(mail.From, mail.Subject, mail.Text, mail.To)
+	message.SetHtml(mail.Html)
+
+	ctx, cancel := context.WithTimeout(context.Background(), mailgunTimeout)
+	defer cancel()
+
+	_, _, err := m.mg.Send(ctx, message)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error sending mail to %s with mailgun", mail.To))
+	}
+	return nil
+}
+
+// SmtpMailer sends the mails to a smtp server like a local mailhog, auth may be nil
+type SmtpMailer struct {
+	addr string
+	auth smtp.Auth
+}
+
+func NewSmtpMailer(addr string, auth smtp.Auth) *SmtpMailer {
+	return &SmtpMailer{addr: addr, auth: auth}
+}
+
+func (m *SmtpMailer) Send(mail *Mail) error {
+
+	message, err := mail.mime()
+	if err != nil {
+		return err
+	}
+	err = smtp.SendMail(m.addr, m.auth, mail.From, []string{mail.To}, message)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error sending mail to %s with %s", mail.To, m.addr))
+	}
+	return nil
+}
+
+// FileMailer writes the mails as eml files into a directory
+type FileMailer struct {
+	dir string
+}
+
+func NewFileMailer(dir string) (*FileMailer, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error creating mail directory %s", dir))
+	}
+	return &FileMailer{dir: dir}, nil
+}
+
+func (m *FileMailer) Send(mail *Mail) error {
+
+	message, err := mail.mime()
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102150405.000000000"), url.PathEscape(mail.To))
+	err = ioutil.WriteFile(filepath.Join(m.dir, name), message, 0644)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error writing mail to %s", mail.To))
+	}
+	return nil
+}
+
+// mime renders the mail as multipart/alternative message, line breaks in the headers are refused
+func (mail *Mail) mime() ([]byte, error) {
+
+	for _, header := range []string{mail.From, mail.To, mail.Subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, errors.New(fmt.Sprintf("line break in mail header %q", header))
+		}
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", mail.Text},
+		{"text/html; charset=utf-8", mail.Html},
+	} {
+		w, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating mail part")
+		}
+		_, err = w.Write([]byte(part.content))
+		if err != nil {
+			return nil, errors.Wrap(err, "error writing mail part")
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "error closing mail")
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", mail.From)
+	fmt.Fprintf(&message, "To: %s\r\n", mail.To)
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", mail.Subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
@@ -0,0 +1,203 @@
+package dpage_test
+
+import (
+	"bufio"
+	"github.com/rismaster/allris-dpage/dpage"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func testMail() *dpage.Mail {
+	return &dpage.Mail{
+		From:    "ratsinfo@example.org",
+		To:      "erika@example.org",
+		Subject: "Ratsinfo: 1 Vorlagen, 0 Sitzungen – Bauausschuss",
+		Text:    "Hallo Erika,\n\nNeubau Radweg Hauptstraße\n",
+		Html:    "<p>Hallo Erika,</p><p>Neubau Radweg Hauptstraße</p>",
+	}
+}
+
+// assertMessage parses the message and compares its headers and parts with the mail
+func assertMessage(t *testing.T, message []byte, want *dpage.Mail) {
+	t.Helper()
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(message)))
+	if err != nil {
+		t.Fatalf("error reading message: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatalf("error decoding subject: %v", err)
+	}
+	if msg.Header.Get("From") != want.From || msg.Header.Get("To") != want.To || subject != want.Subject {
+		t.Errorf("message is from %s to %s: %s", msg.Header.Get("From"), msg.Header.Get("To"), subject)
+	}
+	if _, err = msg.Header.Date(); err != nil {
+		t.Errorf("invalid date: %v", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("content type is %s: %v", msg.Header.Get("Content-Type"), err)
+	}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	parts := make(map[string]string)
+	for {
+		part, errPart := reader.NextPart()
+		if errPart == io.EOF {
+			break
+		}
+		if errPart != nil {
+			t.Fatalf("error reading part: %v", errPart)
+		}
+		content, _ := ioutil.ReadAll(part)
+		// smtp sends the lines with CRLF
+		parts[part.Header.Get("Content-Type")] = strings.ReplaceAll(string(content), "\r\n", "\n")
+	}
+	if parts["text/plain; charset=utf-8"] != want.Text || parts["text/html; charset=utf-8"] != want.Html {
+		t.Errorf("parts are %v", parts)
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+
+	dir := filepath.Join(t.TempDir(), "mails")
+	mailer, err := dpage.NewFileMailer(dir)
+	if err != nil {
+		t.Fatalf("error creating mailer: %v", err)
+	}
+	if err = mailer.Send(testMail()); err != nil {
+		t.Fatalf("error sending: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*-erika@example.org.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("mail files are %v: %v", files, err)
+	}
+	message, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("error reading mail: %v", err)
+	}
+	assertMessage(t, message, testMail())
+
+	injected := testMail()
+	injected.To = "erika@example.org\r\nBcc: spam@example.org"
+	if err = mailer.Send(injected); err == nil {
+		t.Errorf("no error for a line break in the header")
+	}
+	injected = testMail()
+	injected.Subject = "Ratsinfo\nBcc: spam@example.org"
+	if err = mailer.Send(injected); err == nil {
+		t.Errorf("no error for a line break in the subject")
+	}
+}
+
+// smtpServer is a minimal smtp server which accepts one mail per connection
+type smtpServer struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	from     string
+	to       []string
+	data     string
+	done     chan struct{}
+}
+
+func newSmtpServer(t *testing.T) *smtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	s := &smtpServer{listener: listener, done: make(chan struct{})}
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) serve() {
+
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = io.WriteString(conn, line+"\r\n") }
+	reply("220 localhost ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			s.mutex.Lock()
+			s.from = strings.Trim(strings.TrimSpace(line)[len("MAIL FROM:"):], "<>")
+			s.mutex.Unlock()
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			s.mutex.Lock()
+			s.to = append(s.to, strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>"))
+			s.mutex.Unlock()
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(dataLine, "."))
+			}
+			s.mutex.Lock()
+			s.data = data.String()
+			s.mutex.Unlock()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSmtpMailer(t *testing.T) {
+
+	server := newSmtpServer(t)
+	defer server.listener.Close()
+
+	mailer := dpage.NewSmtpMailer(server.listener.Addr().String(), nil)
+	if err := mailer.Send(testMail()); err != nil {
+		t.Fatalf("error sending: %v", err)
+	}
+	<-server.done
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.from != "ratsinfo@example.org" || len(server.to) != 1 || server.to[0] != "erika@example.org" {
+		t.Errorf("envelope is from %s to %v", server.from, server.to)
+	}
+	assertMessage(t, []byte(server.data), testMail())
+
+	closed := dpage.NewSmtpMailer(server.listener.Addr().String(), nil)
+	_ = server.listener.Close()
+	if err := closed.Send(testMail()); err == nil {
+		t.Errorf("no error for a closed server")
+	}
+}
@@ -0,0 +1,101 @@
+package dpage
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	attrs   ObjectAttrs
+	content []byte
+}
+
+// MemoryStore keeps the objects in memory, e.g. for tests
+type MemoryStore struct {
+	mutex   sync.Mutex
+	objects map[string]*memoryObject
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		objects: make(map[string]*memoryObject),
+	}
+}
+
+func (s *MemoryStore) Attrs(path string) (*ObjectAttrs, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	obj, ok := s.objects[path]
+	if !ok {
+		return nil, ErrObjectNotExist
+	}
+	attrs := obj.attrs
+	attrs.Metadata = copyMetadata(obj.attrs.Metadata)
+	return &attrs, nil
+}
+
+func (s *MemoryStore) Read(path string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	obj, ok := s.objects[path]
+	if !ok {
+		return nil, ErrObjectNotExist
+	}
+	return append([]byte{}, obj.content...), nil
+}
+
+func (s *MemoryStore) Write(path string, content []byte, attrs *ObjectAttrs) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	obj := &memoryObject{attrs: *attrs, content: append([]byte{}, content...)}
+	obj.attrs.Name = path
+	obj.attrs.Updated = time.Now()
+	obj.attrs.Metadata = copyMetadata(attrs.Metadata)
+	s.objects[path] = obj
+	return nil
+}
+
+func (s *MemoryStore) Touch(path string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	obj, ok := s.objects[path]
+	if !ok {
+		return ErrObjectNotExist
+	}
+	obj.attrs.Updated = time.Now()
+	return nil
+}
+
+func (s *MemoryStore) Delete(path string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.objects[path]; !ok {
+		return ErrObjectNotExist
+	}
+	delete(s.objects, path)
+	return nil
+}
+
+func (s *MemoryStore) List(prefix string) (result []*ObjectAttrs, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for path, obj := range s.objects {
+		if strings.HasPrefix(path, prefix) {
+			attrs := obj.attrs
+			attrs.Metadata = copyMetadata(obj.attrs.Metadata)
+			result = append(result, &attrs)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
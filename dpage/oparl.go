@@ -0,0 +1,662 @@
+package dpage
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common"
+	"github.com/rismaster/allris-common/common/slog"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const oparlSchema = "https://schema.oparl.org/1.1/"
+const defaultOParlFolder = "oparl/"
+const defaultOParlPageSize = 100
+
+// OParlConfig can be implemented by the Config to set the folder, the public url of the folder and the
+// name of the Body of the OParl export
+type OParlConfig interface {
+	GetOParlFolder() string
+	GetOParlBaseUrl() string
+	GetOParlBodyName() string
+}
+
+type oparlObject struct {
+	Id       string `json:"id"`
+	Type     string `json:"type"`
+	Web      string `json:"web,omitempty"`
+	Modified string `json:"modified,omitempty"`
+}
+
+type oparlSystem struct {
+	oparlObject
+	OparlVersion string `json:"oparlVersion"`
+	Name         string `json:"name"`
+	Body         string `json:"body"`
+}
+
+type oparlBody struct {
+	oparlObject
+	System          string        `json:"system"`
+	Name            string        `json:"name"`
+	Organization    string        `json:"organization"`
+	Person          string        `json:"person"`
+	Meeting         string        `json:"meeting"`
+	Paper           string        `json:"paper"`
+	LegislativeTerm []interface{} `json:"legislativeTerm"`
+}
+
+type oparlOrganization struct {
+	oparlObject
+	Body             string `json:"body"`
+	Name             string `json:"name"`
+	OrganizationType string `json:"organizationType"`
+}
+
+type oparlLocation struct {
+	oparlObject
+	Description string `json:"description"`
+}
+
+type oparlMeeting struct {
+	oparlObject
+	Name          string            `json:"name"`
+	Start         string            `json:"start,omitempty"`
+	End           string            `json:"end,omitempty"`
+	Location      *oparlLocation    `json:"location,omitempty"`
+	Organization  []string          `json:"organization,omitempty"`
+	Invitation    *oparlFile        `json:"invitation,omitempty"`
+	AuxiliaryFile []oparlFile       `json:"auxiliaryFile,omitempty"`
+	AgendaItem    []oparlAgendaItem `json:"agendaItem,omitempty"`
+}
+
+type oparlAgendaItem struct {
+	oparlObject
+	Meeting        string      `json:"meeting"`
+	Number         string      `json:"number"`
+	Order          int         `json:"order"`
+	Name           string      `json:"name"`
+	Public         bool        `json:"public"`
+	Consultation   string      `json:"consultation,omitempty"`
+	Result         string      `json:"result,omitempty"`
+	ResolutionText string      `json:"resolutionText,omitempty"`
+	AuxiliaryFile  []oparlFile `json:"auxiliaryFile,omitempty"`
+}
+
+type oparlPaper struct {
+	oparlObject
+	Body          string              `json:"body"`
+	Name          string              `json:"name"`
+	Reference     string              `json:"reference,omitempty"`
+	Date          string              `json:"date,omitempty"`
+	PaperType     string              `json:"paperType,omitempty"`
+	AuxiliaryFile []oparlFile         `json:"auxiliaryFile,omitempty"`
+	Consultation  []oparlConsultation `json:"consultation,omitempty"`
+}
+
+type oparlFile struct {
+	oparlObject
+	Name        string `json:"name"`
+	FileName    string `json:"fileName"`
+	AccessUrl   string `json:"accessUrl"`
+	DownloadUrl string `json:"downloadUrl"`
+}
+
+type oparlPerson struct {
+	oparlObject
+	Body       string            `json:"body"`
+	Name       string            `json:"name"`
+	Membership []oparlMembership `json:"membership,omitempty"`
+}
+
+type oparlMembership struct {
+	oparlObject
+	Person       string `json:"person"`
+	Organization string `json:"organization,omitempty"`
+	Role         string `json:"role,omitempty"`
+	StartDate    string `json:"startDate,omitempty"`
+	EndDate      string `json:"endDate,omitempty"`
+}
+
+type oparlConsultation struct {
+	oparlObject
+	Paper         string   `json:"paper"`
+	AgendaItem    string   `json:"agendaItem,omitempty"`
+	Meeting       string   `json:"meeting,omitempty"`
+	Organization  []string `json:"organization,omitempty"`
+	Role          string   `json:"role,omitempty"`
+	Authoritative bool     `json:"authoritative"`
+}
+
+type oparlPagination struct {
+	TotalElements   int `json:"totalElements"`
+	ElementsPerPage int `json:"elementsPerPage"`
+	CurrentPage     int `json:"currentPage"`
+	TotalPages      int `json:"totalPages"`
+}
+
+type oparlLinks struct {
+	First string `json:"first"`
+	Prev  string `json:"prev,omitempty"`
+	Next  string `json:"next,omitempty"`
+	Last  string `json:"last"`
+}
+
+type oparlList struct {
+	Data       []interface{}   `json:"data"`
+	Pagination oparlPagination `json:"pagination"`
+	Links      oparlLinks      `json:"links"`
+}
+
+// OParl exports the stored Sitzungen, Tops and Vorlagen as static OParl 1.1 tree
+type OParl struct {
+	app      *App
+	folder   string
+	baseUrl  string
+	bodyName string
+	pageSize int
+	written  map[string]bool
+}
+
+func NewOParl(app *App) OParl {
+
+	o := OParl{
+		app:      app,
+		folder:   defaultOParlFolder,
+		baseUrl:  fmt.Sprintf("https://storage.googleapis.com/%s/%s", app.Config.GetBucketFetched(), defaultOParlFolder),
+		bodyName: app.Config.GetTargetToParse(),
+		pageSize: defaultOParlPageSize,
+	}
+	if oc, ok := app.Config.(OParlConfig); ok {
+		if oc.GetOParlFolder() != "" {
+			o.folder = oc.GetOParlFolder()
+		}
+		if oc.GetOParlBaseUrl() != "" {
+			o.baseUrl = oc.GetOParlBaseUrl()
+		}
+		if oc.GetOParlBodyName() != "" {
+			o.bodyName = oc.GetOParlBodyName()
+		}
+	}
+	return o
+}
+
+func (o *OParl) url(format string, a ...interface{}) string {
+	return o.baseUrl + fmt.Sprintf(format, a...)
+}
+
+// mirrorUrl is the public url of a stored document, the parent of the OParl folder
+func (o *OParl) mirrorUrl(storagePath string) string {
+	return strings.TrimSuffix(o.baseUrl, o.folder) + storagePath
+}
+
+func (o *OParl) write(id string, v interface{}) error {
+
+	storagePath := o.folder + strings.TrimPrefix(id, o.baseUrl)
+	o.written[storagePath] = true
+	return writeJson(o.app, storagePath, time.Now(), v)
+}
+
+// Export writes the System, the Body, the lists and all objects to the OParl folder and removes objects not
+// existing anymore
+func (o *OParl) Export(redownload bool) error {
+
+	o.written = make(map[string]bool)
+
+	sl := NewSitzungsliste(o.app)
+	gremien, err := sl.fetchGremiumOptions(redownload)
+	if err != nil {
+		return errors.Wrap(err, "error fetching gremien")
+	}
+
+	systemId := o.url("system.json")
+	bodyId := o.url("body.json")
+
+	organizations := make(map[string]string)
+	var organizationList []interface{}
+	for _, gremium := range gremien {
+		org := oparlOrganization{
+			oparlObject:      oparlObject{Id: o.url("organization/%d.json", gremium.option), Type: oparlSchema + "Organization"},
+			Body:             bodyId,
+			Name:             gremium.name,
+			OrganizationType: "Gremium",
+		}
+		organizations[fmt.Sprintf("%d", gremium.option)] = org.Id
+		organizations[gremium.name] = org.Id
+		organizationList = append(organizationList, org)
+		if err = o.write(org.Id, org); err != nil {
+			return err
+		}
+	}
+
+	tops, err := o.loadTops()
+	if err != nil {
+		return err
+	}
+
+	vorlagen, err := o.loadVorlagen()
+	if err != nil {
+		return err
+	}
+
+	// consultations by sitzung and vorlage, to link them from the agenda items
+	consultationIds := make(map[string]string)
+	var paperList []interface{}
+	for _, v := range vorlagen {
+		paper, errPaper := o.paper(v.vorlage, v.modified, bodyId, organizations, tops)
+		if errPaper != nil {
+			return errPaper
+		}
+		for _, c := range paper.Consultation {
+			consultationIds[c.Meeting+"|"+paper.Id] = c.Id
+		}
+		paperList = append(paperList, paper)
+	}
+
+	meetingList, err := o.meetings(organizations, tops, consultationIds)
+	if err != nil {
+		return err
+	}
+
+	personList, err := o.persons(bodyId, organizations)
+	if err != nil {
+		return err
+	}
+
+	organizationListUrl, err := o.writeList("organization", organizationList)
+	if err != nil {
+		return err
+	}
+	meetingListUrl, err := o.writeList("meeting", meetingList)
+	if err != nil {
+		return err
+	}
+	paperListUrl, err := o.writeList("paper", paperList)
+	if err != nil {
+		return err
+	}
+	personListUrl, err := o.writeList("person", personList)
+	if err != nil {
+		return err
+	}
+
+	body := oparlBody{
+		oparlObject:     oparlObject{Id: bodyId, Type: oparlSchema + "Body", Web: o.app.Config.GetTargetToParse()},
+		System:          systemId,
+		Name:            o.bodyName,
+		Organization:    organizationListUrl,
+		Person:          personListUrl,
+		Meeting:         meetingListUrl,
+		Paper:           paperListUrl,
+		LegislativeTerm: []interface{}{},
+	}
+	if err = o.write(body.Id, body); err != nil {
+		return err
+	}
+	bodyListUrl, err := o.writeList("body", []interface{}{body})
+	if err != nil {
+		return err
+	}
+
+	system := oparlSystem{
+		oparlObject:  oparlObject{Id: systemId, Type: oparlSchema + "System"},
+		OparlVersion: oparlSchema,
+		Name:         o.bodyName,
+		Body:         bodyListUrl,
+	}
+	if err = o.write(system.Id, system); err != nil {
+		return err
+	}
+
+	err = deleteFilesIfNotInAndAfter(o.app, o.folder, o.written, []string{}, time.Time{}, "oparl export")
+	if err != nil {
+		return errors.Wrap(err, "error deleting oparl objects")
+	}
+
+	slog.Info("exported %d organizations, %d meetings, %d papers, %d persons to oparl", len(organizationList), len(meetingList), len(paperList), len(personList))
+	return nil
+}
+
+func (o *OParl) paper(v *Vorlage, modified time.Time, bodyId string, organizations map[string]string, tops *oparlTops) (*oparlPaper, error) {
+
+	paper := &oparlPaper{
+		oparlObject: oparlObject{
+			Id:       o.url("paper/%d.json", v.VOLFDNR),
+			Type:     oparlSchema + "Paper",
+			Web:      o.app.Config.GetTargetToParse() + fmt.Sprintf(o.app.Config.GetUrlVorlageTmpl(), v.VOLFDNR),
+			Modified: modified.Format(time.RFC3339),
+		},
+		Body:      bodyId,
+		Name:      v.Betreff,
+		Reference: v.Nummer,
+		PaperType: v.Art,
+	}
+	if !v.Datum.IsZero() {
+		paper.Date = v.Datum.Format("2006-01-02")
+	}
+
+	parentName := fmt.Sprintf("%s-%d", o.app.Config.GetVorlageType(), v.VOLFDNR)
+	for _, anlage := range v.Anlagen {
+		file, err := o.file(parentName, anlage)
+		if err != nil {
+			return nil, err
+		}
+		paper.AuxiliaryFile = append(paper.AuxiliaryFile, file)
+	}
+
+	for i, beratung := range v.Beratungsfolge {
+		consultation := oparlConsultation{
+			oparlObject:   oparlObject{Id: o.url("consultation/%d-%d.json", v.VOLFDNR, i+1), Type: oparlSchema + "Consultation"},
+			Paper:         paper.Id,
+			Role:          beratung.Rolle,
+			Authoritative: beratung.Rolle == "Entscheidung",
+		}
+		if beratung.SILFDNR > 0 {
+			consultation.Meeting = o.url("meeting/%d.json", beratung.SILFDNR)
+			if top, ok := tops.byBeratung[oparlBeratung{SILFDNR: beratung.SILFDNR, VOLFDNR: v.VOLFDNR}]; ok {
+				consultation.AgendaItem = o.url("agendaitem/%d.json", top.TOLFDNR)
+			}
+		}
+		if org, ok := organizations[beratung.Gremium]; ok {
+			consultation.Organization = []string{org}
+		}
+		paper.Consultation = append(paper.Consultation, consultation)
+		if err := o.write(consultation.Id, consultation); err != nil {
+			return nil, err
+		}
+	}
+
+	return paper, o.write(paper.Id, paper)
+}
+
+// file writes the stored Anlage of the parent as File, the Basis-Anlagen are identified by their DOLFDNR
+func (o *OParl) file(parentName string, anlage AnlageInfo) (oparlFile, error) {
+
+	storagePath := anlagePath(o.app, parentName, anlage)
+	file := oparlFile{
+		oparlObject: oparlObject{Id: o.url("file/%s.json", common.Md5HashStr(storagePath)), Type: oparlSchema + "File"},
+		Name:        anlage.Name,
+		FileName:    path.Base(storagePath),
+		AccessUrl:   o.mirrorUrl(storagePath),
+		DownloadUrl: o.mirrorUrl(storagePath),
+	}
+	if anlage.DOLFDNR > 0 {
+		file.Id = o.url("file/%d.json", anlage.DOLFDNR)
+	}
+	return file, o.write(file.Id, file)
+}
+
+func (o *OParl) meetings(organizations map[string]string, tops *oparlTops, consultationIds map[string]string) (meetingList []interface{}, err error) {
+
+	objects, err := listJsons(o.app, o.app.Config.GetSitzungenFolder())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, attrs := range objects {
+		var sitzung Sitzung
+		err = readJson(o.app, attrs.Name, &sitzung)
+		if err != nil {
+			slog.Warn("ignore sitzung %s: %v", attrs.Name, err)
+			continue
+		}
+
+		meeting := oparlMeeting{
+			oparlObject: oparlObject{
+				Id:       o.url("meeting/%d.json", sitzung.SILFDNR),
+				Type:     oparlSchema + "Meeting",
+				Web:      o.app.Config.GetTargetToParse() + fmt.Sprintf(o.app.Config.GetUrlSitzungTmpl(), sitzung.SILFDNR),
+				Modified: attrs.Updated.Format(time.RFC3339),
+			},
+			Name:  sitzung.Bezeichnung,
+			Start: sitzung.Start.Format(time.RFC3339),
+		}
+		if !sitzung.Ende.IsZero() {
+			meeting.End = sitzung.Ende.Format(time.RFC3339)
+		}
+
+		if org, ok := organizations[fmt.Sprintf("%d", sitzung.GremiumID)]; ok {
+			meeting.Organization = []string{org}
+		} else if org, ok := organizations[sitzung.Gremium]; ok {
+			meeting.Organization = []string{org}
+		}
+
+		var location []string
+		for _, l := range []string{sitzung.Raum, sitzung.Ort} {
+			if l != "" {
+				location = append(location, l)
+			}
+		}
+		if len(location) > 0 {
+			meeting.Location = &oparlLocation{
+				oparlObject: oparlObject{Id: o.url("location/meeting-%d.json", sitzung.SILFDNR), Type: oparlSchema + "Location"},
+				Description: strings.Join(location, ", "),
+			}
+			if err = o.write(meeting.Location.Id, meeting.Location); err != nil {
+				return nil, err
+			}
+		}
+
+		// the Einladung is the invitation, all other Anlagen of the Sitzung are auxiliary files
+		sitzungName := fmt.Sprintf("%s-%d", o.app.Config.GetSitzungType(), sitzung.SILFDNR)
+		for _, anlage := range sitzung.Anlagen {
+			file, errFile := o.file(sitzungName, anlage)
+			if errFile != nil {
+				return nil, errFile
+			}
+			if meeting.Invitation == nil && strings.Contains(strings.ToLower(anlage.Name), "einladung") {
+				meeting.Invitation = &file
+			} else {
+				meeting.AuxiliaryFile = append(meeting.AuxiliaryFile, file)
+			}
+		}
+
+		for i, info := range sitzung.Tops {
+			item := oparlAgendaItem{
+				oparlObject: oparlObject{Id: o.url("agendaitem/%d.json", info.TOLFDNR), Type: oparlSchema + "AgendaItem"},
+				Meeting:     meeting.Id,
+				Number:      info.Nummer,
+				Order:       i + 1,
+				Name:        info.Betreff,
+				Public:      info.Oeffentlich,
+			}
+			if info.TOLFDNR == 0 {
+				item.Id = o.url("agendaitem/%d-%d.json", sitzung.SILFDNR, i+1)
+			}
+			if top, ok := tops.byId[info.TOLFDNR]; ok {
+				item.Result = top.Beschlussart
+				item.ResolutionText = top.Beschlusstext
+				topName := fmt.Sprintf("%s-%s-%d", sitzungName, o.app.Config.GetTopType(), top.TOLFDNR)
+				for _, anlage := range top.Anlagen {
+					file, errFile := o.file(topName, anlage)
+					if errFile != nil {
+						return nil, errFile
+					}
+					item.AuxiliaryFile = append(item.AuxiliaryFile, file)
+				}
+			}
+			if info.VOLFDNR > 0 {
+				item.Consultation = consultationIds[meeting.Id+"|"+o.url("paper/%d.json", info.VOLFDNR)]
+			}
+			meeting.AgendaItem = append(meeting.AgendaItem, item)
+			if err = o.write(item.Id, item); err != nil {
+				return nil, err
+			}
+		}
+
+		meetingList = append(meetingList, meeting)
+		if err = o.write(meeting.Id, meeting); err != nil {
+			return nil, err
+		}
+	}
+	return meetingList, nil
+}
+
+// writeList writes the items paginated as OParl list and returns the url of the first page
+func (o *OParl) writeList(name string, items []interface{}) (string, error) {
+
+	totalPages := (len(items) + o.pageSize - 1) / o.pageSize
+	if totalPages == 0 {
+		totalPages = 1
+	}
+	pageUrl := func(page int) string {
+		return o.url("%s/page-%d.json", name, page)
+	}
+
+	for page := 1; page <= totalPages; page++ {
+		from := (page - 1) * o.pageSize
+		to := from + o.pageSize
+		if to > len(items) {
+			to = len(items)
+		}
+
+		list := oparlList{
+			Data: append([]interface{}{}, items[from:to]...),
+			Pagination: oparlPagination{
+				TotalElements:   len(items),
+				ElementsPerPage: o.pageSize,
+				CurrentPage:     page,
+				TotalPages:      totalPages,
+			},
+			Links: oparlLinks{First: pageUrl(1), Last: pageUrl(totalPages)},
+		}
+		if page > 1 {
+			list.Links.Prev = pageUrl(page - 1)
+		}
+		if page < totalPages {
+			list.Links.Next = pageUrl(page + 1)
+		}
+
+		if err := o.write(pageUrl(page), list); err != nil {
+			return "", errors.Wrap(err, fmt.Sprintf("error writing oparl list %s", name))
+		}
+	}
+	return pageUrl(1), nil
+}
+
+// persons writes the stored Personen with their memberships in the Gremien
+func (o *OParl) persons(bodyId string, organizations map[string]string) (personList []interface{}, err error) {
+
+	objects, err := listJsons(o.app, GetPersonenFolder(o.app))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Name < objects[j].Name
+	})
+
+	for _, attrs := range objects {
+		var p Person
+		err = readJson(o.app, attrs.Name, &p)
+		if err != nil {
+			slog.Warn("ignore person %s: %v", attrs.Name, err)
+			continue
+		}
+
+		person := oparlPerson{
+			oparlObject: oparlObject{
+				Id:       o.url("person/%d.json", p.KPLFDNR),
+				Type:     oparlSchema + "Person",
+				Web:      o.app.Config.GetTargetToParse() + fmt.Sprintf(getUrlPersonTmpl(o.app), p.KPLFDNR),
+				Modified: attrs.Updated.Format(time.RFC3339),
+			},
+			Body: bodyId,
+			Name: p.Name,
+		}
+		for i, m := range p.Mitgliedschaften {
+			membership := oparlMembership{
+				oparlObject:  oparlObject{Id: o.url("membership/%d-%d.json", p.KPLFDNR, i+1), Type: oparlSchema + "Membership"},
+				Person:       person.Id,
+				Organization: organizations[m.Gremium],
+				Role:         m.Rolle,
+			}
+			if !m.Von.IsZero() {
+				membership.StartDate = m.Von.Format("2006-01-02")
+			}
+			if !m.Bis.IsZero() {
+				membership.EndDate = m.Bis.Format("2006-01-02")
+			}
+			person.Membership = append(person.Membership, membership)
+			if err = o.write(membership.Id, membership); err != nil {
+				return nil, err
+			}
+		}
+
+		personList = append(personList, person)
+		if err = o.write(person.Id, person); err != nil {
+			return nil, err
+		}
+	}
+	return personList, nil
+}
+
+// oparlTops are the stored TOPs by TOLFDNR and by the Beratung of a Vorlage in a Sitzung
+type oparlTops struct {
+	byId       map[int]*Top
+	byBeratung map[oparlBeratung]*Top
+}
+
+type oparlBeratung struct {
+	SILFDNR int
+	VOLFDNR int
+}
+
+// loadTops reads the stored TOPs, of several TOPs of a Vorlage in the same Sitzung the one with the lowest
+// TOLFDNR is linked from the Consultation
+func (o *OParl) loadTops() (*oparlTops, error) {
+
+	objects, err := listJsons(o.app, o.app.Config.GetTopFolder())
+	if err != nil {
+		return nil, err
+	}
+
+	tops := &oparlTops{byId: make(map[int]*Top), byBeratung: make(map[oparlBeratung]*Top)}
+	for _, attrs := range objects {
+		var top Top
+		err = readJson(o.app, attrs.Name, &top)
+		if err != nil {
+			slog.Warn("ignore top %s: %v", attrs.Name, err)
+			continue
+		}
+		tops.byId[top.TOLFDNR] = &top
+		if top.VOLFDNR <= 0 {
+			continue
+		}
+		key := oparlBeratung{SILFDNR: top.SILFDNR, VOLFDNR: top.VOLFDNR}
+		if first, ok := tops.byBeratung[key]; !ok || top.TOLFDNR < first.TOLFDNR {
+			tops.byBeratung[key] = &top
+		}
+	}
+	return tops, nil
+}
+
+type storedVorlage struct {
+	vorlage  *Vorlage
+	modified time.Time
+}
+
+func (o *OParl) loadVorlagen() (vorlagen []storedVorlage, err error) {
+
+	objects, err := listJsons(o.app, o.app.Config.GetVorlagenFolder())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, attrs := range objects {
+		var vorlage Vorlage
+		err = readJson(o.app, attrs.Name, &vorlage)
+		if err != nil {
+			slog.Warn("ignore vorlage %s: %v", attrs.Name, err)
+			continue
+		}
+		vorlagen = append(vorlagen, storedVorlage{vorlage: &vorlage, modified: attrs.Updated})
+	}
+
+	sort.Slice(vorlagen, func(i, j int) bool {
+		return vorlagen[i].vorlage.VOLFDNR < vorlagen[j].vorlage.VOLFDNR
+	})
+	return vorlagen, nil
+}
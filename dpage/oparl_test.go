@@ -0,0 +1,177 @@
+package dpage_test
+
+import (
+	"encoding/json"
+	"github.com/rismaster/allris-dpage/dpage"
+	"github.com/rismaster/allris-dpage/dpage/dpagetest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const oparlBaseUrl = "https://storage.googleapis.com/fetched/oparl/"
+
+// oparlObject is a stored object of the OParl export with the fields used by the tests
+type oparlObject struct {
+	Id         string `json:"id"`
+	Type       string `json:"type"`
+	Name       string `json:"name"`
+	Person     string `json:"person"`
+	AgendaItem []struct {
+		Id            string      `json:"id"`
+		Consultation  string      `json:"consultation"`
+		AuxiliaryFile []oparlFile `json:"auxiliaryFile"`
+	} `json:"agendaItem"`
+	Consultation []struct {
+		Meeting    string `json:"meeting"`
+		AgendaItem string `json:"agendaItem"`
+	} `json:"consultation"`
+	Invitation    *oparlFile  `json:"invitation"`
+	AuxiliaryFile []oparlFile `json:"auxiliaryFile"`
+	Membership    []struct {
+		Organization string `json:"organization"`
+		Role         string `json:"role"`
+		StartDate    string `json:"startDate"`
+		EndDate      string `json:"endDate"`
+	} `json:"membership"`
+	Data []oparlObject `json:"data"`
+}
+
+type oparlFile struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	FileName  string `json:"fileName"`
+	AccessUrl string `json:"accessUrl"`
+}
+
+func readOParl(t *testing.T, env *dpagetest.Env, name string) oparlObject {
+	t.Helper()
+	content, ok := env.Storage.Content(env.Config.BucketFetched, "oparl/"+name)
+	if !ok {
+		t.Fatalf("no oparl object %s", name)
+	}
+	var object oparlObject
+	err := json.Unmarshal(content, &object)
+	if err != nil {
+		t.Fatalf("error unmarshalling %s: %v", name, err)
+	}
+	return object
+}
+
+func TestOParlExport(t *testing.T) {
+
+	env := newEnv(t)
+	vl := dpage.NewVorlagenliste(env.App)
+	if err := vl.SynchronizeSince(time.Time{}, false); err != nil {
+		t.Fatalf("error synchronizing vorlagen: %v", err)
+	}
+	sl := dpage.NewSitzungsliste(env.App)
+	if err := sl.SynchronizeSince(time.Time{}, false); err != nil {
+		t.Fatalf("error synchronizing sitzungen: %v", err)
+	}
+	pl := dpage.NewPersonenliste(env.App)
+	if err := pl.SynchronizeSince(time.Time{}, false); err != nil {
+		t.Fatalf("error synchronizing personen: %v", err)
+	}
+
+	o := dpage.NewOParl(env.App)
+	if err := o.Export(false); err != nil {
+		t.Fatalf("error exporting: %v", err)
+	}
+
+	var names []string
+	for _, name := range env.Storage.Names(env.Config.BucketFetched) {
+		if strings.HasPrefix(name, "oparl/") {
+			names = append(names, name)
+		}
+	}
+	assertStrings(t, "oparl objects", names, []string{
+		"oparl/agendaitem/20001.json",
+		"oparl/agendaitem/20002.json",
+		"oparl/agendaitem/20003.json",
+		"oparl/agendaitem/20010.json",
+		"oparl/agendaitem/20011.json",
+		"oparl/body.json",
+		"oparl/body/page-1.json",
+		"oparl/consultation/4710-1.json",
+		"oparl/consultation/4711-1.json",
+		"oparl/consultation/4711-2.json",
+		"oparl/file/30c33037baab08e7a6ab53d80caa72b6.json",
+		"oparl/file/55501.json",
+		"oparl/file/55601.json",
+		"oparl/file/83dd014f87d1560b3b912ca600a5a695.json",
+		"oparl/file/ddcfb2fbca5e8b575f82f8af5d68bb4f.json",
+		"oparl/location/meeting-1001.json",
+		"oparl/location/meeting-1002.json",
+		"oparl/meeting/1001.json",
+		"oparl/meeting/1002.json",
+		"oparl/meeting/page-1.json",
+		"oparl/membership/101-1.json",
+		"oparl/membership/101-2.json",
+		"oparl/membership/101-3.json",
+		"oparl/membership/102-1.json",
+		"oparl/organization/1.json",
+		"oparl/organization/1001.json",
+		"oparl/organization/2.json",
+		"oparl/organization/page-1.json",
+		"oparl/paper/4710.json",
+		"oparl/paper/4711.json",
+		"oparl/paper/page-1.json",
+		"oparl/person/101.json",
+		"oparl/person/102.json",
+		"oparl/person/page-1.json",
+		"oparl/system.json",
+	})
+
+	body := readOParl(t, env, "body.json")
+	if body.Person != oparlBaseUrl+"person/page-1.json" {
+		t.Errorf("person list of body is %s", body.Person)
+	}
+
+	// the Consultations link the TOP of the Vorlage in each Sitzung
+	paper := readOParl(t, env, "paper/4711.json")
+	var agendaItems []string
+	for _, c := range paper.Consultation {
+		agendaItems = append(agendaItems, c.AgendaItem)
+	}
+	assertStrings(t, "agenda items of paper", agendaItems, []string{oparlBaseUrl + "agendaitem/20002.json", oparlBaseUrl + "agendaitem/20011.json"})
+
+	meeting := readOParl(t, env, "meeting/1001.json")
+	if meeting.Invitation == nil || meeting.Invitation.Name != "Einladung" || meeting.Invitation.Id != oparlBaseUrl+"file/55601.json" {
+		t.Errorf("invitation of meeting is %+v", meeting.Invitation)
+	} else if meeting.Invitation.AccessUrl != "https://storage.googleapis.com/fetched/anlagen/sitzung-1001-anlagedoc-55601-1.pdf" {
+		t.Errorf("invitation of meeting is at %s", meeting.Invitation.AccessUrl)
+	}
+	if len(meeting.AuxiliaryFile) != 0 {
+		t.Errorf("auxiliary files of meeting are %+v", meeting.AuxiliaryFile)
+	}
+	if len(meeting.AgendaItem) != 3 {
+		t.Fatalf("meeting has %d agenda items, want 3", len(meeting.AgendaItem))
+	}
+	item := meeting.AgendaItem[1]
+	if len(item.AuxiliaryFile) != 1 || item.AuxiliaryFile[0].FileName != "sitzung-1001-top-20002-anlage-245-kb-lageplan.pdf" {
+		t.Errorf("auxiliary files of %s are %+v", item.Id, item.AuxiliaryFile)
+	}
+	if item.Consultation != oparlBaseUrl+"consultation/4711-1.json" {
+		t.Errorf("consultation of %s is %s", item.Id, item.Consultation)
+	}
+
+	persons := readOParl(t, env, "person/page-1.json")
+	var personNames []string
+	for _, p := range persons.Data {
+		personNames = append(personNames, p.Name)
+	}
+	assertStrings(t, "persons", personNames, []string{"Anna Müller", "Schmidt, Peter"})
+
+	person := readOParl(t, env, "person/101.json")
+	if len(person.Membership) != 3 {
+		t.Fatalf("person has %d memberships, want 3", len(person.Membership))
+	}
+	membership := person.Membership[1]
+	if membership.Organization != oparlBaseUrl+"organization/1.json" || membership.Role != "Vorsitzende" || membership.StartDate != "2019-11-01" || membership.EndDate != "" {
+		t.Errorf("membership is %+v", membership)
+	}
+	if person.Membership[2].Organization != "" || person.Membership[2].EndDate != "2019-10-31" {
+		t.Errorf("membership in a Gremium not in the RIS is %+v", person.Membership[2])
+	}
+}
@@ -0,0 +1,87 @@
+package dpage
+
+import (
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-common/common/domtools"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var dateRegexp = regexp.MustCompile(`[0-9]{1,2}\.[0-9]{1,2}\.[0-9]{4}`)
+var sizeRegexp = regexp.MustCompile(`(.*)[(]([0-9]+ KB)[)]`)
+
+// labelValues maps the text of all label cells (td.kb1) without the colon to the text of the following cell
+func labelValues(dom *goquery.Selection) map[string]string {
+
+	values := make(map[string]string)
+	dom.Find("td.kb1").Each(func(i int, td *goquery.Selection) {
+		label := strings.TrimSpace(strings.TrimSuffix(domtools.CleanText(td.Text()), ":"))
+		value := td.NextFiltered("td")
+		if label == "" || value.Size() == 0 {
+			return
+		}
+		if _, exists := values[label]; !exists {
+			values[label] = domtools.CleanText(value.Text())
+		}
+	})
+	return values
+}
+
+// RisDates reads the dates of the RIS pages in the timezone and with the date format of the Config
+type RisDates struct {
+	location *time.Location
+	format   string
+}
+
+func NewRisDates(conf allris_common.Config) (*RisDates, error) {
+	location, err := time.LoadLocation(conf.GetTimezone())
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error loading timezone %s", conf.GetTimezone()))
+	}
+	return &RisDates{location: location, format: conf.GetDateFormat()}, nil
+}
+
+func (d *RisDates) Location() *time.Location {
+	return d.location
+}
+
+// parse finds the first date (dd.mm.yyyy) in text, the zero time if there is none
+func (d *RisDates) parse(text string) time.Time {
+
+	dateText := dateRegexp.FindString(text)
+	if dateText == "" {
+		return time.Time{}
+	}
+	date, err := time.ParseInLocation(d.format, dateText, d.location)
+	if err != nil {
+		return time.Time{}
+	}
+	return date
+}
+
+// idFromHref reads an integer parameter like VOLFDNR from a link, 0 if missing
+func idFromHref(href string, param string) int {
+
+	uri, err := url.Parse(href)
+	if err != nil {
+		return 0
+	}
+	return domtools.StringToIntOrNeg(uri.Query().Get(param))
+}
+
+// idFromName reads the number at the end of a ressource name like vorlage-4711, 0 if missing
+func idFromName(name string) int {
+
+	i := strings.LastIndex(name, "-")
+	id, err := strconv.Atoi(name[i+1:])
+	if err != nil {
+		return 0
+	}
+	return id
+}
@@ -0,0 +1,113 @@
+package dpage
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ledongthuc/pdf"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common"
+	"github.com/rismaster/allris-common/common/slog"
+	"path"
+	"strings"
+)
+
+// textEnding is the ending of the extracted text stored next to a PDF
+const textEnding = ".text.json"
+
+var pdfMagic = []byte("%PDF-")
+
+// PdfText is the text layer of a PDF with the hash of the PDF it was extracted from
+type PdfText struct {
+	Hash  string    `json:"hash"`
+	Pages []PdfPage `json:"pages"`
+}
+
+type PdfPage struct {
+	Number int    `json:"number"`
+	Text   string `json:"text"`
+}
+
+// String is the text of all pages separated by form feeds like pdftotext
+func (t *PdfText) String() string {
+	texts := make([]string, 0, len(t.Pages))
+	for _, p := range t.Pages {
+		texts = append(texts, p.Text)
+	}
+	return strings.Join(texts, "\f")
+}
+
+// textPath is the path of the text extracted from the PDF at pdfPath
+func textPath(pdfPath string) string {
+	return strings.TrimSuffix(pdfPath, path.Ext(pdfPath)) + textEnding
+}
+
+// ExtractPdfText reads the text of every page of the PDF, pages without text layer like scans are empty
+func ExtractPdfText(content []byte) (text *PdfText, err error) {
+
+	// the pdf reader panics on some broken files
+	defer func() {
+		if r := recover(); r != nil {
+			text = nil
+			err = errors.New(fmt.Sprintf("error reading pdf: %v", r))
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading pdf")
+	}
+
+	text = &PdfText{Hash: common.Md5HashB(content)}
+	fonts := make(map[string]*pdf.Font)
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		for _, name := range page.Fonts() {
+			if _, ok := fonts[name]; !ok {
+				f := page.Font(name)
+				fonts[name] = &f
+			}
+		}
+		pageText, errPage := page.GetPlainText(fonts)
+		if errPage != nil {
+			return nil, errors.Wrap(errPage, fmt.Sprintf("error reading page %d", i))
+		}
+		text.Pages = append(text.Pages, PdfPage{Number: i, Text: strings.TrimSpace(pageText)})
+	}
+	return text, nil
+}
+
+// ReadPdfText returns the stored text of the PDF at pdfPath
+func ReadPdfText(app *App, pdfPath string) (*PdfText, error) {
+	text := &PdfText{}
+	err := readJson(app, textPath(pdfPath), text)
+	if err != nil {
+		return nil, err
+	}
+	return text, nil
+}
+
+// writePdfText stores the text of a downloaded PDF next to it. Other content is ignored and the extraction
+// is skipped if the stored text is of the same PDF.
+func writePdfText(app *App, file *File) error {
+
+	content := file.GetContent()
+	if !bytes.HasPrefix(content, pdfMagic) {
+		return nil
+	}
+
+	hash := common.Md5HashB(content)
+	stored, err := ReadPdfText(app, file.GetPath())
+	if err == nil && stored.Hash == hash {
+		slog.Debug("Same Hash for Text of %s: %s", file.GetPath(), hash)
+		return nil
+	}
+
+	text, err := ExtractPdfText(content)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error extracting text of %s", file.GetPath()))
+	}
+	return writeJson(app, textPath(file.GetPath()), file.risTime, text)
+}
@@ -0,0 +1,82 @@
+package dpage
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/domtools"
+	"strings"
+	"time"
+)
+
+// Person is the parsed content of a kp020 page of a Mandatsträger
+type Person struct {
+	KPLFDNR          int              `json:"kplfdnr"`
+	Name             string           `json:"name"`
+	Fraktion         string           `json:"fraktion,omitempty"`
+	Partei           string           `json:"partei,omitempty"`
+	Mitgliedschaften []Mitgliedschaft `json:"mitgliedschaften,omitempty"`
+}
+
+// Mitgliedschaft is the membership of a Person in a Gremium, Bis is zero for current memberships
+type Mitgliedschaft struct {
+	AULFDNR int       `json:"aulfdnr,omitempty"`
+	Gremium string    `json:"gremium"`
+	Rolle   string    `json:"rolle,omitempty"`
+	Von     time.Time `json:"von"`
+	Bis     time.Time `json:"bis"`
+}
+
+func ParsePerson(html []byte, dates *RisDates) (*Person, error) {
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(html))
+	if err != nil {
+		return nil, errors.Wrap(err, "error create dom from person")
+	}
+
+	container := doc.Find("#allriscontainer")
+	if container.Size() == 0 {
+		return nil, errors.New("no allriscontainer in person")
+	}
+
+	labels := labelValues(container)
+
+	person := &Person{
+		KPLFDNR:  domtools.ExtractIntFromInput(container, "KPLFDNR"),
+		Name:     labels["Name"],
+		Fraktion: labels["Fraktion"],
+		Partei:   labels["Partei"],
+	}
+
+	if person.Name == "" {
+		title := domtools.CleanText(doc.Find("#risname h1").Text())
+		if i := strings.Index(title, " - "); i >= 0 {
+			person.Name = strings.TrimSpace(title[i+3:])
+		}
+	}
+
+	container.Find("table.tl1 tr").Each(func(i int, tr *goquery.Selection) {
+		tds := tr.Children().Filter("td")
+		if tds.Size() < 4 {
+			return
+		}
+		href, _ := tds.Eq(0).Find("a").Attr("href")
+		mitgliedschaft := Mitgliedschaft{
+			AULFDNR: idFromHref(href, "AULFDNR"),
+			Gremium: domtools.CleanText(tds.Eq(0).Text()),
+			Rolle:   domtools.CleanText(tds.Eq(1).Text()),
+			Von:     dates.parse(domtools.CleanText(tds.Eq(2).Text())),
+			Bis:     dates.parse(domtools.CleanText(tds.Eq(3).Text())),
+		}
+		if mitgliedschaft.Gremium != "" {
+			person.Mitgliedschaften = append(person.Mitgliedschaften, mitgliedschaft)
+		}
+	})
+
+	if person.Name == "" {
+		return nil, errors.New(fmt.Sprintf("no Name in person %d", person.KPLFDNR))
+	}
+
+	return person, nil
+}
@@ -0,0 +1,53 @@
+package dpage_test
+
+import (
+	"github.com/rismaster/allris-dpage/dpage"
+	"testing"
+	"time"
+)
+
+func TestParsePerson(t *testing.T) {
+
+	tests := []struct {
+		fixture string
+		want    dpage.Person
+	}{
+		{
+			fixture: "kp020-101.html",
+			want: dpage.Person{
+				KPLFDNR:  101,
+				Name:     "Anna Müller",
+				Fraktion: "SPD-Fraktion",
+				Partei:   "SPD",
+				Mitgliedschaften: []dpage.Mitgliedschaft{
+					{AULFDNR: 11, Gremium: "Rat der Stadt", Rolle: "Ratsmitglied", Von: berlin(t, "01.11.2014 00:00")},
+					{AULFDNR: 12, Gremium: "Bau- und Umweltausschuss", Rolle: "Vorsitzende", Von: berlin(t, "01.11.2019 00:00")},
+					{AULFDNR: 13, Gremium: "Finanzausschuss", Rolle: "Mitglied", Von: berlin(t, "01.11.2014 00:00"), Bis: berlin(t, "31.10.2019 00:00")},
+				},
+			},
+		},
+		{
+			fixture: "kp020-102.html",
+			want: dpage.Person{
+				KPLFDNR:  102,
+				Name:     "Schmidt, Peter",
+				Fraktion: "CDU-Fraktion",
+				Partei:   "CDU",
+				Mitgliedschaften: []dpage.Mitgliedschaft{
+					{AULFDNR: 11, Gremium: "Rat der Stadt", Rolle: "Ratsmitglied", Von: berlin(t, "01.11.2019 00:00"), Bis: time.Time{}},
+				},
+			},
+		},
+	}
+
+	dates := testDates(t)
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			person, err := dpage.ParsePerson(fixture(t, tt.fixture), dates)
+			if err != nil {
+				t.Fatalf("error parsing: %v", err)
+			}
+			assertModel(t, person, tt.want)
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package dpage
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common"
+	"github.com/rismaster/allris-common/common/slog"
+	"github.com/rismaster/allris-common/downloader"
+	"net/url"
+	"time"
+)
+
+const defaultPersonenFolder = "personen/"
+const defaultPersonType = "person"
+const defaultPersonenListeType = "personenliste"
+const defaultUrlPersonenliste = "kp040.asp"
+const defaultUrlPersonTmpl = "kp020.asp?KPLFDNR=%d"
+
+// PersonConfig can be implemented by the Config to change the folder, names and urls of the Personenliste
+type PersonConfig interface {
+	GetPersonenFolder() string
+	GetPersonType() string
+	GetUrlPersonenliste() string
+	GetUrlPersonTmpl() string
+}
+
+type Personenliste struct {
+	app *App
+}
+
+func NewPersonenliste(app *App) Personenliste {
+	return Personenliste{
+		app: app,
+	}
+}
+
+func GetPersonenFolder(app *App) string {
+	if pc, ok := app.Config.(PersonConfig); ok && pc.GetPersonenFolder() != "" {
+		return pc.GetPersonenFolder()
+	}
+	return defaultPersonenFolder
+}
+
+func getPersonType(app *App) string {
+	if pc, ok := app.Config.(PersonConfig); ok && pc.GetPersonType() != "" {
+		return pc.GetPersonType()
+	}
+	return defaultPersonType
+}
+
+func getUrlPersonenliste(app *App) string {
+	if pc, ok := app.Config.(PersonConfig); ok && pc.GetUrlPersonenliste() != "" {
+		return pc.GetUrlPersonenliste()
+	}
+	return defaultUrlPersonenliste
+}
+
+func getUrlPersonTmpl(app *App) string {
+	if pc, ok := app.Config.(PersonConfig); ok && pc.GetUrlPersonTmpl() != "" {
+		return pc.GetUrlPersonTmpl()
+	}
+	return defaultUrlPersonTmpl
+}
+
+// SynchronizeSince downloads the pages of all persons of the Personenliste and moves the stored persons missing
+// in the RIS to the tombstones. The Personenliste has no dates, so minTime is not used and every stored person
+// missing in the list is deleted. With an App of DryRun nothing is written, the changes are collected in its
+// Plan. Afterwards the digests of the changes are sent to the subscribers.
+func (pl *Personenliste) SynchronizeSince(minTime time.Time, redownload bool) error {
+	personen, err := pl.fetch(redownload)
+	if err != nil {
+		return errors.Wrap(err, "error downloading personenliste")
+	}
+
+	publishErr := PublishRisDownload(pl.app, personen)
+
+	allPersonenFromRis := make(map[string]bool)
+	for _, p := range personen {
+		pf := NewPerson(pl.app, &p)
+		allPersonenFromRis[pf.GetPath()] = true
+		allPersonenFromRis[pf.GetModelPath()] = true
+	}
+
+	childFolders := []string{pl.app.Config.GetAnlagenFolder()}
+	err = deleteFilesIfNotInAndAfter(pl.app, GetPersonenFolder(pl.app), allPersonenFromRis, childFolders, time.Time{}, "sync personenliste")
+	if err != nil {
+		return errors.Wrap(err, "error deleting personen")
+	}
+
+	_, err = PurgeTombstones(pl.app)
+	if err != nil {
+		slog.Error("error purging tombstones: %v", err)
+	}
+
+	_, err = SendDigests(pl.app)
+	if err != nil {
+		slog.Error("error sending digests: %v", err)
+	}
+	return publishErr
+}
+
+func (pl *Personenliste) fetch(redownload bool) (personen []downloader.RisRessource, err error) {
+
+	uri, err := url.Parse(pl.app.Config.GetTargetToParse() + getUrlPersonenliste(pl.app))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse url")
+	}
+
+	srcWeb := downloader.NewRisRessource("", defaultPersonenListeType, ".html", time.Now(), uri, &url.Values{}, true, redownload)
+	targetStore := NewFile(pl.app, srcWeb)
+
+	err = targetStore.Fetch(httpGet, srcWeb, "text/html")
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error downloading Personenliste from %s", uri))
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(targetStore.GetContent()))
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error create dom from %s", targetStore.GetName()))
+	}
+
+	seen := make(map[int]bool)
+	doc.Find("tr.zl11,tr.zl12").Each(func(index int, tr *goquery.Selection) {
+		href, _ := tr.Find("a[href*=\"KPLFDNR\"]").First().Attr("href")
+		kplfdnr := idFromHref(href, "KPLFDNR")
+		if kplfdnr <= 0 || seen[kplfdnr] {
+			return
+		}
+		seen[kplfdnr] = true
+
+		person, errPerson := pl.newRisRessource(kplfdnr, srcWeb)
+		if errPerson != nil {
+			slog.Error("error reading person %d: %v", kplfdnr, errPerson)
+			return
+		}
+		personen = append(personen, *person)
+	})
+
+	newHash := common.Md5HashB(targetStore.GetContent())
+	err = targetStore.WriteIfMoreActualAndDifferent(newHash)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error writing personenliste %s", srcWeb.GetName()))
+	}
+
+	slog.Info("loaded %d Personen from %s", len(personen), uri)
+	return personen, nil
+}
+
+func (pl *Personenliste) newRisRessource(kplfdnr int, plRisResource *downloader.RisRessource) (*downloader.RisRessource, error) {
+
+	uri, err := url.Parse(pl.app.Config.GetTargetToParse() + fmt.Sprintf(getUrlPersonTmpl(pl.app), kplfdnr))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse url")
+	}
+
+	return downloader.NewRisRessource(GetPersonenFolder(pl.app), fmt.Sprintf("%s-%d", getPersonType(pl.app), kplfdnr), ".html", time.Now(), uri, &url.Values{}, plRisResource.RedownloadChildren, plRisResource.RedownloadChildren), nil
+}
@@ -0,0 +1,292 @@
+package dpage
+
+import (
+	"fmt"
+	"github.com/rismaster/allris-common/common"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the actions of a Plan
+const PlanCreate = "create"
+const PlanUpdate = "update"
+const PlanDelete = "delete"
+
+// PlanCounts are the planned changes of a folder
+type PlanCounts struct {
+	Create int
+	Update int
+	Delete int
+}
+
+// Plan collects the files a dry run would create, update and delete in the fetched store
+type Plan struct {
+	mutex   sync.Mutex
+	actions map[string]string
+	refused map[string]int
+}
+
+func NewPlan() *Plan {
+	return &Plan{
+		actions: make(map[string]string),
+		refused: make(map[string]int),
+	}
+}
+
+func (p *Plan) record(path string, action string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	previous, ok := p.actions[path]
+	switch {
+	case !ok:
+		p.actions[path] = action
+	case previous == PlanCreate && action == PlanDelete:
+		delete(p.actions, path)
+	case previous == PlanCreate:
+		// created and updated is still created
+	case previous == PlanDelete && action != PlanDelete:
+		p.actions[path] = PlanUpdate
+	default:
+		p.actions[path] = action
+	}
+}
+
+// refuse notes that the deletions below prefix would be refused without confirmation
+func (p *Plan) refuse(prefix string, count int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.refused[prefix] = count
+}
+
+// Paths returns the sorted paths with the action
+func (p *Plan) Paths(action string) (result []string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for name, a := range p.actions {
+		if a == action {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Counts returns the planned changes per folder
+func (p *Plan) Counts() map[string]PlanCounts {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	result := make(map[string]PlanCounts)
+	for name, action := range p.actions {
+		folder, _ := path.Split(name)
+		counts := result[folder]
+		switch action {
+		case PlanCreate:
+			counts.Create++
+		case PlanUpdate:
+			counts.Update++
+		case PlanDelete:
+			counts.Delete++
+		}
+		result[folder] = counts
+	}
+	return result
+}
+
+// Refused returns the prefixes whose deletions exceed the deletion threshold with the number of files
+func (p *Plan) Refused() map[string]int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	result := make(map[string]int, len(p.refused))
+	for prefix, count := range p.refused {
+		result[prefix] = count
+	}
+	return result
+}
+
+// String is a summary of the plan with one line per folder
+func (p *Plan) String() string {
+
+	counts := p.Counts()
+	folders := make([]string, 0, len(counts))
+	for folder := range counts {
+		folders = append(folders, folder)
+	}
+	sort.Strings(folders)
+
+	var sb strings.Builder
+	for _, folder := range folders {
+		c := counts[folder]
+		if folder == "" {
+			folder = "/"
+		}
+		sb.WriteString(fmt.Sprintf("%s: %d create, %d update, %d delete\n", folder, c.Create, c.Update, c.Delete))
+	}
+
+	refused := p.Refused()
+	prefixes := make([]string, 0, len(refused))
+	for prefix := range refused {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		sb.WriteString(fmt.Sprintf("%s: %d deletions need confirmation\n", prefix, refused[prefix]))
+	}
+	return sb.String()
+}
+
+// PlanStore reads from a store but keeps all changes in memory and records them in the plan. Only the attrs and
+// hashes of the planned writes are kept, their content is not readable, Read returns the stored content.
+type PlanStore struct {
+	store Store
+	plan  *Plan
+
+	mutex   sync.Mutex
+	written map[string]*ObjectAttrs
+	deleted map[string]bool
+}
+
+// NewPlanStore wraps store, plan may be nil to discard the changes, e.g. of the backup store
+func NewPlanStore(store Store, plan *Plan) *PlanStore {
+	return &PlanStore{
+		store:   store,
+		plan:    plan,
+		written: make(map[string]*ObjectAttrs),
+		deleted: make(map[string]bool),
+	}
+}
+
+// planned returns a copy of the attrs of the planned write, deleted is true for a planned delete
+func (s *PlanStore) planned(path string) (attrs *ObjectAttrs, deleted bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.deleted[path] {
+		return nil, true
+	}
+	if a, ok := s.written[path]; ok {
+		c := *a
+		c.Metadata = copyMetadata(a.Metadata)
+		return &c, false
+	}
+	return nil, false
+}
+
+func (s *PlanStore) Attrs(path string) (*ObjectAttrs, error) {
+	attrs, deleted := s.planned(path)
+	if deleted {
+		return nil, ErrObjectNotExist
+	}
+	if attrs != nil {
+		return attrs, nil
+	}
+	return s.store.Attrs(path)
+}
+
+func (s *PlanStore) Read(path string) ([]byte, error) {
+	if _, deleted := s.planned(path); deleted {
+		return nil, ErrObjectNotExist
+	}
+	return s.store.Read(path)
+}
+
+func (s *PlanStore) Write(path string, content []byte, attrs *ObjectAttrs) error {
+
+	action := PlanUpdate
+	_, err := s.Attrs(path)
+	if err == ErrObjectNotExist {
+		action = PlanCreate
+	} else if err != nil {
+		return err
+	}
+
+	a := *attrs
+	a.Name = path
+	a.Updated = time.Now()
+	a.Metadata = copyMetadata(attrs.Metadata)
+	if a.Hash == "" {
+		a.Hash = common.Md5HashB(content)
+	}
+
+	s.mutex.Lock()
+	s.written[path] = &a
+	delete(s.deleted, path)
+	s.mutex.Unlock()
+
+	if s.plan != nil {
+		s.plan.record(path, action)
+	}
+	return nil
+}
+
+// Touch only touches planned writes, touching a stored object is no change of the plan
+func (s *PlanStore) Touch(path string) error {
+	_, err := s.Attrs(path)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if a, ok := s.written[path]; ok {
+		a.Updated = time.Now()
+	}
+	return nil
+}
+
+func (s *PlanStore) Delete(path string) error {
+	_, err := s.Attrs(path)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	delete(s.written, path)
+	s.deleted[path] = true
+	s.mutex.Unlock()
+
+	if s.plan != nil {
+		s.plan.record(path, PlanDelete)
+	}
+	return nil
+}
+
+func (s *PlanStore) List(prefix string) ([]*ObjectAttrs, error) {
+
+	stored, err := s.store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	byName := make(map[string]*ObjectAttrs, len(stored)+len(s.written))
+	for _, attrs := range stored {
+		byName[attrs.Name] = attrs
+	}
+	for name, attrs := range s.written {
+		if strings.HasPrefix(name, prefix) {
+			c := *attrs
+			c.Metadata = copyMetadata(attrs.Metadata)
+			byName[name] = &c
+		}
+	}
+
+	result := make([]*ObjectAttrs, 0, len(byName))
+	for name, attrs := range byName {
+		if !s.deleted[name] {
+			result = append(result, attrs)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
@@ -0,0 +1,140 @@
+package dpage_test
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-dpage/dpage"
+	"github.com/rismaster/allris-dpage/dpage/dpagetest"
+	"testing"
+	"time"
+)
+
+func TestPlanStore(t *testing.T) {
+
+	risTime := berlin(t, "12.04.2021 18:00")
+	stored := dpage.NewMemoryStore()
+	writeObject(t, stored, "vorlagen/vorlage-4710.html", "<html>4710</html>", risTime)
+	writeObject(t, stored, "vorlagen/vorlage-4711.html", "<html>4711</html>", risTime)
+	writeObject(t, stored, "vorlagen/vorlage-4712.html", "<html>4712</html>", risTime)
+	writeObject(t, stored, "sitzungen/sitzung-1001.html", "<html>1001</html>", risTime)
+
+	plan := dpage.NewPlan()
+	store := dpage.NewPlanStore(stored, plan)
+
+	// created and updated is created, created and deleted is no change
+	writeObject(t, store, "vorlagen/vorlage-4720.html", "<html>4720</html>", risTime)
+	writeObject(t, store, "vorlagen/vorlage-4720.html", "<html>4720 geändert</html>", risTime)
+	writeObject(t, store, "vorlagen/vorlage-4721.html", "<html>4721</html>", risTime)
+	if err := store.Delete("vorlagen/vorlage-4721.html"); err != nil {
+		t.Fatalf("error deleting planned write: %v", err)
+	}
+
+	// updated twice is updated, deleted and written again is updated, updated and deleted is deleted
+	writeObject(t, store, "vorlagen/vorlage-4710.html", "<html>4710 geändert</html>", risTime)
+	writeObject(t, store, "vorlagen/vorlage-4710.html", "<html>4710 nochmal</html>", risTime)
+	if err := store.Delete("vorlagen/vorlage-4711.html"); err != nil {
+		t.Fatalf("error deleting: %v", err)
+	}
+	writeObject(t, store, "vorlagen/vorlage-4711.html", "<html>4711 zurück</html>", risTime)
+	writeObject(t, store, "vorlagen/vorlage-4712.html", "<html>4712 geändert</html>", risTime)
+	if err := store.Delete("vorlagen/vorlage-4712.html"); err != nil {
+		t.Fatalf("error deleting update: %v", err)
+	}
+	if err := store.Delete("sitzungen/sitzung-1001.html"); err != nil {
+		t.Fatalf("error deleting: %v", err)
+	}
+	if err := store.Delete("sitzungen/sitzung-1001.html"); err != dpage.ErrObjectNotExist {
+		t.Errorf("second delete: %v", err)
+	}
+
+	assertStrings(t, "created", plan.Paths(dpage.PlanCreate), []string{"vorlagen/vorlage-4720.html"})
+	assertStrings(t, "updated", plan.Paths(dpage.PlanUpdate), []string{"vorlagen/vorlage-4710.html", "vorlagen/vorlage-4711.html"})
+	assertStrings(t, "deleted", plan.Paths(dpage.PlanDelete), []string{"sitzungen/sitzung-1001.html", "vorlagen/vorlage-4712.html"})
+
+	counts := plan.Counts()
+	if counts["vorlagen/"] != (dpage.PlanCounts{Create: 1, Update: 2, Delete: 1}) || counts["sitzungen/"] != (dpage.PlanCounts{Delete: 1}) {
+		t.Errorf("counts are %+v", counts)
+	}
+	want := "sitzungen/: 0 create, 0 update, 1 delete\nvorlagen/: 1 create, 2 update, 1 delete\n"
+	if plan.String() != want {
+		t.Errorf("plan is\n%s\nwant\n%s", plan, want)
+	}
+
+	// the plan store shows the planned changes, the stored objects are unchanged
+	assertStrings(t, "planned vorlagen", listNames(t, store, "vorlagen/"),
+		[]string{"vorlagen/vorlage-4710.html", "vorlagen/vorlage-4711.html", "vorlagen/vorlage-4720.html"})
+	assertStrings(t, "stored vorlagen", listNames(t, stored, "vorlagen/"),
+		[]string{"vorlagen/vorlage-4710.html", "vorlagen/vorlage-4711.html", "vorlagen/vorlage-4712.html"})
+	attrs, err := store.Attrs("vorlagen/vorlage-4710.html")
+	if err != nil || attrs.Hash != "hash of <html>4710 nochmal</html>" {
+		t.Errorf("planned attrs are %+v: %v", attrs, err)
+	}
+	if _, err = store.Read("sitzungen/sitzung-1001.html"); err != dpage.ErrObjectNotExist {
+		t.Errorf("read of planned delete: %v", err)
+	}
+	content, err := stored.Read("vorlagen/vorlage-4710.html")
+	if err != nil || string(content) != "<html>4710</html>" {
+		t.Errorf("stored content is %s: %v", content, err)
+	}
+}
+
+// deletionConfig allows two deletions per folder
+type deletionConfig struct {
+	*dpagetest.Config
+}
+
+func (c *deletionConfig) GetMaxDeletions() int { return 2 }
+
+func TestDeletionThreshold(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		delete    int
+		confirm   bool
+		dryRun    bool
+		wantErr   bool
+		remaining int
+	}{
+		{"below threshold", 2, false, false, false, 2},
+		{"above threshold", 3, false, false, true, 4},
+		{"confirmed", 3, true, false, false, 1},
+		{"dry run", 3, false, true, false, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			risTime := berlin(t, "12.04.2021 18:00")
+			app := dpage.NewApp(context.Background(), &deletionConfig{Config: dpagetest.NewConfig("")}, dpage.NewMemoryStore(), dpage.NewMemoryStore())
+			app.ConfirmDeletions = tt.confirm
+			found := make(map[string]bool)
+			for i, name := range []string{"vorlagen/vorlage-4710.html", "vorlagen/vorlage-4711.html", "vorlagen/vorlage-4712.html", "vorlagen/vorlage-4713.html"} {
+				writeObject(t, app.Fetched, name, "<html>"+name+"</html>", risTime)
+				found[name] = i >= tt.delete
+			}
+			if tt.dryRun {
+				app = app.DryRun()
+			}
+
+			err := dpage.DeleteFilesIfNotInAndAfter(app, "vorlagen/", found, nil, time.Time{}, "test")
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("error is %v", err)
+			}
+			if err != nil && errors.Cause(err) != dpage.ErrTooManyDeletions {
+				t.Errorf("error is %v, want %v", err, dpage.ErrTooManyDeletions)
+			}
+			if n := len(listNames(t, app.Fetched, "vorlagen/")); !tt.dryRun && n != tt.remaining {
+				t.Errorf("%d files remain, want %d", n, tt.remaining)
+			}
+
+			if tt.dryRun {
+				if refused := app.Plan().Refused(); refused["vorlagen/"] != tt.delete {
+					t.Errorf("refused deletions are %v", refused)
+				}
+				if deleted := app.Plan().Paths(dpage.PlanDelete); len(deleted) != tt.delete {
+					t.Errorf("planned deletions are %v", deleted)
+				}
+			}
+		})
+	}
+}
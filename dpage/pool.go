@@ -0,0 +1,249 @@
+package dpage
+
+import (
+	"github.com/rismaster/allris-common/common/slog"
+	"github.com/rismaster/allris-common/downloader"
+	"sync"
+)
+
+const defaultParallelism = 4
+const defaultParallelismPerHost = 2
+
+// PoolConfig can be implemented by the Config to change the parallelism of the downloads
+type PoolConfig interface {
+	GetDownloadParallelism() int
+	GetDownloadParallelismPerHost() int
+}
+
+// DownloadResult is the result of one downloaded RisRessource, Err is nil or a *DownloadError
+type DownloadResult struct {
+	Ris      downloader.RisRessource
+	Err      error
+	children []downloader.RisRessource
+}
+
+// Pool downloads RisRessourcen and their children with a bounded number of workers.
+// Every ressource is only scheduled once per storage path and Pool, and an Anlage linked by several parents is
+// fetched once per url and form data and written below each of them.
+type Pool struct {
+	app         *App
+	parallelism int
+	perHost     int
+	fetches     *fetchCache
+
+	mutex sync.Mutex
+	hosts map[string]chan struct{}
+	seen  map[string]bool
+}
+
+// fetchSharer is a Document which can share its fetch with the Documents of the same url and form data
+type fetchSharer interface {
+	shareFetches(fetches *fetchCache)
+}
+
+// fetchCache keeps the downloads of a Pool by fetchKey until the Pool is done, concurrent fetches of the
+// same key wait for the first one
+type fetchCache struct {
+	mutex   sync.Mutex
+	fetches map[string]*cachedFetch
+}
+
+type cachedFetch struct {
+	done     chan struct{}
+	download *downloader.Download
+	err      error
+}
+
+func newFetchCache() *fetchCache {
+	return &fetchCache{fetches: make(map[string]*cachedFetch)}
+}
+
+// fetchKey identifies a request to the RIS by method, url and the encoded form data
+func fetchKey(method string, ris *downloader.RisRessource) string {
+	key := method + " " + ris.GetUrl()
+	if ris.GetFormData() != nil && len(*ris.GetFormData()) > 0 {
+		key = key + "?" + ris.GetFormData().Encode()
+	}
+	return key
+}
+
+func (c *fetchCache) fetch(key string, fetch func() (*downloader.Download, error)) (*downloader.Download, error) {
+
+	c.mutex.Lock()
+	cached, ok := c.fetches[key]
+	if !ok {
+		cached = &cachedFetch{done: make(chan struct{})}
+		c.fetches[key] = cached
+	}
+	c.mutex.Unlock()
+
+	if ok {
+		slog.Debug("share download of %s", key)
+		<-cached.done
+		return cached.download, cached.err
+	}
+
+	cached.download, cached.err = fetch()
+	close(cached.done)
+	return cached.download, cached.err
+}
+
+func NewPool(app *App) *Pool {
+
+	parallelism := defaultParallelism
+	perHost := defaultParallelismPerHost
+	if pc, ok := app.Config.(PoolConfig); ok {
+		if pc.GetDownloadParallelism() > 0 {
+			parallelism = pc.GetDownloadParallelism()
+		}
+		if pc.GetDownloadParallelismPerHost() > 0 {
+			perHost = pc.GetDownloadParallelismPerHost()
+		}
+	}
+
+	return &Pool{
+		app:         app,
+		parallelism: parallelism,
+		perHost:     perHost,
+		fetches:     newFetchCache(),
+		hosts:       make(map[string]chan struct{}),
+		seen:        make(map[string]bool),
+	}
+}
+
+// Run downloads the ressources and all children level by level. The results are ordered like the input
+// followed by the children of each level in the order of their parents. The error is nil or a *PublishError.
+func (p *Pool) Run(risArr []downloader.RisRessource) ([]*DownloadResult, error) {
+
+	var results []*DownloadResult
+	level := p.unseen(risArr)
+	for len(level) > 0 {
+		levelResults := p.runLevel(level)
+		results = append(results, levelResults...)
+
+		var next []downloader.RisRessource
+		for _, r := range levelResults {
+			next = append(next, p.unseen(r.children)...)
+		}
+		level = next
+	}
+
+	publishErr := &PublishError{}
+	for _, r := range results {
+		publishErr.add(r.Ris, r.Err)
+	}
+
+	slog.Info("downloaded %d ok, %d failed", publishErr.Ok, len(publishErr.Failed))
+	if len(publishErr.Failed) > 0 {
+		return results, publishErr
+	}
+	return results, nil
+}
+
+func (p *Pool) runLevel(level []downloader.RisRessource) []*DownloadResult {
+
+	results := make([]*DownloadResult, len(level))
+	jobs := make(chan int)
+
+	workers := p.parallelism
+	if workers > len(level) {
+		workers = len(level)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = p.download(level[i])
+			}
+		}()
+	}
+
+	for i := range level {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+func (p *Pool) download(ris downloader.RisRessource) *DownloadResult {
+
+	result := &DownloadResult{Ris: ris}
+
+	ctx := p.app.Ctx()
+	if ctx.Err() != nil {
+		result.Err = &DownloadError{Ris: ris, Err: ctx.Err()}
+		return result
+	}
+
+	hostSlots := p.hostSlots(ris)
+	select {
+	case hostSlots <- struct{}{}:
+		defer func() { <-hostSlots }()
+	case <-ctx.Done():
+		result.Err = &DownloadError{Ris: ris, Err: ctx.Err()}
+		return result
+	}
+
+	doc, err := newDocument(p.app, &ris)
+	if err != nil {
+		result.Err = &DownloadError{Ris: ris, Err: err}
+		return result
+	}
+	if sharer, ok := doc.(fetchSharer); ok {
+		sharer.shareFetches(p.fetches)
+	}
+
+	err = doc.Download()
+	if err != nil {
+		slog.Error("error downloading %s: %v", ris.GetUrl(), err)
+		result.Err = &DownloadError{Ris: ris, Err: err}
+		return result
+	}
+
+	if container, ok := doc.(Container); ok {
+		result.children = container.Children()
+	}
+	return result
+}
+
+func (p *Pool) hostSlots(ris downloader.RisRessource) chan struct{} {
+
+	host := ""
+	if ris.Uri != nil {
+		host = ris.Uri.Host
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	slots, ok := p.hosts[host]
+	if !ok {
+		slots = make(chan struct{}, p.perHost)
+		p.hosts[host] = slots
+	}
+	return slots
+}
+
+// unseen filters ressources already scheduled in this pool by their storage path. An Anlage linked by two
+// parents has a path below each of them, so it is scheduled for both and shares the fetch.
+func (p *Pool) unseen(risArr []downloader.RisRessource) (result []downloader.RisRessource) {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, ris := range risArr {
+		key := ris.GetFolder() + ris.GetName() + ris.GetEnding()
+		if p.seen[key] {
+			slog.Debug("skip already scheduled %s (%s)", ris.GetName(), key)
+			continue
+		}
+		p.seen[key] = true
+		result = append(result, ris)
+	}
+	return result
+}
@@ -0,0 +1,146 @@
+package dpage_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/downloader"
+	"github.com/rismaster/allris-dpage/dpage"
+	"github.com/rismaster/allris-dpage/dpage/dpagetest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func risRessource(t *testing.T, env *dpagetest.Env, folder string, name string, ending string, page string) downloader.RisRessource {
+	t.Helper()
+	uri, err := url.Parse(env.Config.TargetToParse + page)
+	if err != nil {
+		t.Fatalf("error parsing url: %v", err)
+	}
+	return *downloader.NewRisRessource(folder, name, ending, time.Time{}, uri, &url.Values{}, false, false)
+}
+
+func resultNames(results []*dpage.DownloadResult) (names []string) {
+	for _, r := range results {
+		names = append(names, r.Ris.GetFolder()+r.Ris.GetName()+r.Ris.GetEnding())
+	}
+	return names
+}
+
+func TestPoolRunOrder(t *testing.T) {
+
+	env := newEnv(t)
+	results, err := dpage.NewPool(env.App).Run([]downloader.RisRessource{
+		risRessource(t, env, "vorlagen/", "vorlage-4711", ".html", "vo020.asp?VOLFDNR=4711"),
+		risRessource(t, env, "vorlagen/", "vorlage-4710", ".html", "vo020.asp?VOLFDNR=4710"),
+	})
+	if err != nil {
+		t.Fatalf("error running pool: %v", err)
+	}
+
+	want := []string{
+		"vorlagen/vorlage-4711.html",
+		"vorlagen/vorlage-4710.html",
+		"anlagen/vorlage-4711-anlage-245-kb-lageplan.pdf",
+		"anlagen/vorlage-4711-anlage-32-kb-kosten.pdf",
+		"anlagen/vorlage-4711-anlagedoc-55501-1.pdf",
+	}
+	if got := resultNames(results); !reflect.DeepEqual(got, want) {
+		t.Errorf("results are %v, want %v", got, want)
+	}
+}
+
+func TestPoolParallelismPerHost(t *testing.T) {
+
+	tests := []struct {
+		parallelism int
+		perHost     int
+		want        int
+	}{
+		{parallelism: 4, perHost: 1, want: 1},
+		{parallelism: 4, perHost: 2, want: 2},
+		{parallelism: 2, perHost: 4, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d-%d", tt.parallelism, tt.perHost), func(t *testing.T) {
+			env := newEnv(t)
+			env.Config.DownloadParallelism = tt.parallelism
+			env.Config.DownloadParallelismPerHost = tt.perHost
+			env.Allris.SetDelay(50 * time.Millisecond)
+
+			var risArr []downloader.RisRessource
+			for i := 0; i < 8; i++ {
+				risArr = append(risArr, risRessource(t, env, "anlagen/", fmt.Sprintf("anlage-%d", i), ".pdf", fmt.Sprintf("ydocs/lageplan.pdf?v=%d", i)))
+			}
+			results, err := dpage.NewPool(env.App).Run(risArr)
+			if err != nil {
+				t.Fatalf("error running pool: %v", err)
+			}
+			if len(results) != len(risArr) {
+				t.Errorf("%d results, want %d", len(results), len(risArr))
+			}
+			if got := env.Allris.MaxInFlight(); got != tt.want {
+				t.Errorf("%d requests at the same time, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPoolRunCancelled(t *testing.T) {
+
+	env := newEnv(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	app := dpage.NewApp(ctx, env.Config, env.App.Fetched, env.App.Backup)
+
+	risArr := []downloader.RisRessource{
+		risRessource(t, env, "vorlagen/", "vorlage-4711", ".html", "vo020.asp?VOLFDNR=4711"),
+		risRessource(t, env, "vorlagen/", "vorlage-4710", ".html", "vo020.asp?VOLFDNR=4710"),
+	}
+	results, err := dpage.NewPool(app).Run(risArr)
+
+	var publishErr *dpage.PublishError
+	if !errors.As(err, &publishErr) || len(publishErr.Failed) != len(risArr) {
+		t.Fatalf("error is %v, want a PublishError of %d downloads", err, len(risArr))
+	}
+	for _, r := range results {
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Errorf("error of %s is %v, want %v", r.Ris.GetName(), r.Err, context.Canceled)
+		}
+	}
+	if requests := env.Allris.Requests(); len(requests) > 0 {
+		t.Errorf("requested %v after cancel", requests)
+	}
+}
+
+func TestPoolSharesFetchOfAnlage(t *testing.T) {
+
+	env := newEnv(t)
+	_, err := dpage.NewPool(env.App).Run([]downloader.RisRessource{
+		risRessource(t, env, "anlagen/", "vorlage-4711-anlage-245-kb-lageplan", ".pdf", "ydocs/lageplan.pdf"),
+		risRessource(t, env, "anlagen/", "sitzung-1001-top-20002-anlage-245-kb-lageplan", ".pdf", "ydocs/lageplan.pdf"),
+	})
+	if err != nil {
+		t.Fatalf("error running pool: %v", err)
+	}
+
+	fetches := 0
+	for _, request := range env.Allris.Requests() {
+		if request == "ydocs/lageplan.pdf" {
+			fetches++
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("ydocs/lageplan.pdf is fetched %d times, want once", fetches)
+	}
+	want := fixture(t, "ydocs/lageplan.pdf")
+	for _, name := range []string{"anlagen/sitzung-1001-top-20002-anlage-245-kb-lageplan.pdf", "anlagen/vorlage-4711-anlage-245-kb-lageplan.pdf"} {
+		content, ok := env.Storage.Content(env.Config.BucketFetched, name)
+		if !ok || string(content) != string(want) {
+			t.Errorf("%s is not the fetched Anlage", name)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package dpage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-common/common/slog"
+	"golang.org/x/time/rate"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultRequestsPerSecond = 2
+const defaultRequestBurst = 1
+const quietHoursFormat = "15:04"
+
+// RateLimitConfig can be implemented by the Config to limit the requests to the RIS. Without it the rate
+// follows the call delay of the Config. During the quiet hours (e.g. 07:00 to 18:00, in the timezone of the
+// Config) no request is sent. A Crawl-delay of the robots.txt of the RIS further lowers the rate.
+type RateLimitConfig interface {
+	GetRequestsPerSecond() float64
+	GetRequestBurst() int
+	GetQuietHoursStart() string
+	GetQuietHoursEnd() string
+	GetIgnoreRobotsTxt() bool
+}
+
+// rateLimiter is a token bucket shared by all fetches of a Fetcher
+type rateLimiter struct {
+	conf         allris_common.Config
+	limiter      *rate.Limiter
+	quietStart   time.Duration
+	quietEnd     time.Duration
+	quiet        bool
+	ignoreRobots bool
+	robotsOnce   sync.Once
+	now          func() time.Time
+}
+
+func newRateLimiter(conf allris_common.Config) *rateLimiter {
+
+	limit := rate.Limit(defaultRequestsPerSecond)
+	if conf.GetHttpCalldelay() > 0 {
+		limit = rate.Every(conf.GetHttpCalldelay())
+	}
+	burst := defaultRequestBurst
+
+	rl := &rateLimiter{conf: conf, now: time.Now}
+	if rc, ok := conf.(RateLimitConfig); ok {
+		if rc.GetRequestsPerSecond() > 0 {
+			limit = rate.Limit(rc.GetRequestsPerSecond())
+		}
+		if rc.GetRequestBurst() > 0 {
+			burst = rc.GetRequestBurst()
+		}
+		rl.ignoreRobots = rc.GetIgnoreRobotsTxt()
+
+		start, errStart := parseTimeOfDay(rc.GetQuietHoursStart())
+		end, errEnd := parseTimeOfDay(rc.GetQuietHoursEnd())
+		if errStart == nil && errEnd == nil && start != end {
+			rl.quiet = true
+			rl.quietStart = start
+			rl.quietEnd = end
+		} else if rc.GetQuietHoursStart() != "" || rc.GetQuietHoursEnd() != "" {
+			slog.Warn("ignoring invalid quiet hours %s - %s", rc.GetQuietHoursStart(), rc.GetQuietHoursEnd())
+		}
+	}
+
+	rl.limiter = rate.NewLimiter(limit, burst)
+	return rl
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse(quietHoursFormat, value)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// wait blocks until the next request may be sent
+func (rl *rateLimiter) wait(ctx context.Context, client *http.Client) error {
+
+	// the robots.txt is read once for all fetches, a cancelled context of the first fetch must not cancel it
+	rl.robotsOnce.Do(func() {
+		if !rl.ignoreRobots {
+			rl.applyRobots(context.Background(), client)
+		}
+	})
+
+	err := rl.waitQuietHours(ctx)
+	if err != nil {
+		return err
+	}
+	return rl.limiter.Wait(ctx)
+}
+
+// waitQuietHours sleeps until the end of the quiet hours
+func (rl *rateLimiter) waitQuietHours(ctx context.Context) error {
+
+	if !rl.quiet {
+		return nil
+	}
+
+	location, err := time.LoadLocation(rl.conf.GetTimezone())
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error loading timezone %s", rl.conf.GetTimezone()))
+	}
+
+	now := rl.now().In(location)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	sinceMidnight := now.Sub(midnight)
+
+	var until time.Duration
+	if rl.quietStart < rl.quietEnd {
+		if sinceMidnight < rl.quietStart || sinceMidnight >= rl.quietEnd {
+			return nil
+		}
+		until = rl.quietEnd - sinceMidnight
+	} else {
+		// the quiet hours span midnight
+		if sinceMidnight >= rl.quietEnd && sinceMidnight < rl.quietStart {
+			return nil
+		}
+		until = rl.quietEnd - sinceMidnight
+		if until < 0 {
+			until += 24 * time.Hour
+		}
+	}
+
+	slog.Info("quiet hours, pausing requests for %s", until)
+	return sleep(ctx, until)
+}
+
+// applyRobots lowers the rate to the Crawl-delay of the robots.txt of the RIS
+func (rl *rateLimiter) applyRobots(ctx context.Context, client *http.Client) {
+
+	target, err := url.Parse(rl.conf.GetTargetToParse())
+	if err != nil {
+		slog.Warn("error parsing target %s: %v", rl.conf.GetTargetToParse(), err)
+		return
+	}
+	robotsUrl := target.Scheme + "://" + target.Host + "/robots.txt"
+
+	req, err := http.NewRequest(http.MethodGet, robotsUrl, nil)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, rl.conf.GetHttpTimeout())
+	defer cancel()
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		slog.Warn("error fetching %s: %v", robotsUrl, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		slog.Debug("no robots.txt at %s (%d)", robotsUrl, resp.StatusCode)
+		return
+	}
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		slog.Warn("error reading %s: %v", robotsUrl, err)
+		return
+	}
+
+	delay := parseCrawlDelay(content)
+	if delay > 0 && rate.Every(delay) < rl.limiter.Limit() {
+		slog.Info("using Crawl-delay %s of %s", delay, robotsUrl)
+		rl.limiter.SetLimit(rate.Every(delay))
+	}
+}
+
+// parseCrawlDelay returns the Crawl-delay of the group for all user agents (*)
+func parseCrawlDelay(robots []byte) time.Duration {
+
+	var delay time.Duration
+	inGroup := false
+	groupStarted := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(robots))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.TrimSpace(line[i+1:])
+
+		switch key {
+		case "user-agent":
+			if !groupStarted {
+				inGroup = false
+				groupStarted = true
+			}
+			if value == "*" {
+				inGroup = true
+			}
+		case "crawl-delay":
+			groupStarted = false
+			if inGroup {
+				seconds, err := strconv.ParseFloat(value, 64)
+				if err == nil && seconds > 0 {
+					delay = time.Duration(seconds * float64(time.Second))
+				}
+			}
+		default:
+			groupStarted = false
+		}
+	}
+	return delay
+}
@@ -0,0 +1,124 @@
+package dpage_test
+
+import (
+	"context"
+	"github.com/rismaster/allris-dpage/dpage"
+	"github.com/rismaster/allris-dpage/dpage/dpagetest"
+	"testing"
+	"time"
+)
+
+func TestParseCrawlDelay(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		robots string
+		want   time.Duration
+	}{
+		{"empty", "", 0},
+		{"all agents", "User-agent: *\nCrawl-delay: 5\n", 5 * time.Second},
+		{"fraction", "User-agent: *\nCrawl-delay: 0.5\n", 500 * time.Millisecond},
+		{"case and comments", "# robots\nUSER-AGENT: * # alle\ncrawl-DELAY:  10 # Sekunden\n", 10 * time.Second},
+		{"other agent", "User-agent: Googlebot\nCrawl-delay: 5\n", 0},
+		{"other agent before", "User-agent: Googlebot\nCrawl-delay: 5\n\nUser-agent: *\nDisallow: /intern/\nCrawl-delay: 2\n", 2 * time.Second},
+		{"group of several agents", "User-agent: Googlebot\nUser-agent: *\nCrawl-delay: 3\n", 3 * time.Second},
+		{"group ends", "User-agent: *\nDisallow: /intern/\nUser-agent: Googlebot\nCrawl-delay: 5\n", 0},
+		{"invalid", "User-agent: *\nCrawl-delay: bald\n", 0},
+		{"negative", "User-agent: *\nCrawl-delay: -1\n", 0},
+		{"windows line endings", "User-agent: *\r\nCrawl-delay: 4\r\n", 4 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := dpage.ParseCrawlDelay([]byte(tt.robots)); got != tt.want {
+			t.Errorf("%s: crawl delay is %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// quietHoursConfig pauses the requests between start and end
+type quietHoursConfig struct {
+	*dpagetest.Config
+	start string
+	end   string
+}
+
+func (c *quietHoursConfig) GetRequestsPerSecond() float64 { return 0 }
+func (c *quietHoursConfig) GetRequestBurst() int          { return 0 }
+func (c *quietHoursConfig) GetQuietHoursStart() string    { return c.start }
+func (c *quietHoursConfig) GetQuietHoursEnd() string      { return c.end }
+func (c *quietHoursConfig) GetIgnoreRobotsTxt() bool      { return true }
+
+func TestWaitQuietHours(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		start string
+		end   string
+		now   string
+		quiet bool
+	}{
+		{"before", "07:00", "18:00", "12.04.2021 06:59", false},
+		{"start", "07:00", "18:00", "12.04.2021 07:00", true},
+		{"during", "07:00", "18:00", "12.04.2021 12:00", true},
+		{"end", "07:00", "18:00", "12.04.2021 18:00", false},
+		{"evening", "07:00", "18:00", "12.04.2021 23:30", false},
+		{"midnight before start", "22:00", "06:00", "12.04.2021 21:59", false},
+		{"midnight start", "22:00", "06:00", "12.04.2021 22:00", true},
+		{"midnight before midnight", "22:00", "06:00", "12.04.2021 23:59", true},
+		{"midnight after midnight", "22:00", "06:00", "13.04.2021 00:00", true},
+		{"midnight in the night", "22:00", "06:00", "13.04.2021 05:59", true},
+		{"midnight end", "22:00", "06:00", "13.04.2021 06:00", false},
+		{"midnight noon", "22:00", "06:00", "13.04.2021 12:00", false},
+		{"invalid", "7 Uhr", "18:00", "12.04.2021 12:00", false},
+		{"same start and end", "07:00", "07:00", "12.04.2021 07:00", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := berlin(t, tt.now)
+			conf := &quietHoursConfig{Config: dpagetest.NewConfig(""), start: tt.start, end: tt.end}
+			rl := dpage.NewRateLimiter(conf, func() time.Time { return now })
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			err := rl.WaitQuietHours(ctx)
+			if tt.quiet && err != context.DeadlineExceeded {
+				t.Errorf("no pause during the quiet hours: %v", err)
+			}
+			if !tt.quiet && err != nil {
+				t.Errorf("pause outside of the quiet hours: %v", err)
+			}
+		})
+	}
+}
+
+func TestWaitQuietHoursUntilEnd(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		start string
+		end   string
+		now   string
+	}{
+		{"same day", "07:00", "18:00", "12.04.2021 18:00"},
+		{"spanning midnight", "22:00", "06:00", "13.04.2021 06:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the clock is 30ms before the end of the quiet hours
+			end := berlin(t, tt.now)
+			conf := &quietHoursConfig{Config: dpagetest.NewConfig(""), start: tt.start, end: tt.end}
+			rl := dpage.NewRateLimiter(conf, func() time.Time { return end.Add(-30 * time.Millisecond) })
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			start := time.Now()
+			if err := rl.WaitQuietHours(ctx); err != nil {
+				t.Fatalf("error waiting: %v", err)
+			}
+			if waited := time.Since(start); waited < 20*time.Millisecond || waited > 500*time.Millisecond {
+				t.Errorf("waited %s for the end of the quiet hours in 30ms", waited)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package dpage
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultRevisionsFolder = "revisions/"
+
+// RevisionConfig can be implemented by the Config to set the folder of the revisions in the backup store
+type RevisionConfig interface {
+	GetRevisionsFolder() string
+}
+
+// Revision is a version of a stored file. The previous versions are kept in the backup store, the last
+// revision is the current file of the fetched store.
+type Revision struct {
+	Path      string
+	Number    int
+	Hash      string
+	FetchedAt time.Time
+	Updated   time.Time
+	Current   bool
+	name      string
+}
+
+// hasRevisions is true for the html pages of the Sitzungen, TOPs and Vorlagen. The list pages, the json models
+// and the Anlagen are replaced without revision.
+func hasRevisions(app *App, filePath string) bool {
+	if path.Ext(filePath) != ".html" {
+		return false
+	}
+	for _, folder := range []string{app.Config.GetSitzungenFolder(), app.Config.GetTopFolder(), app.Config.GetVorlagenFolder()} {
+		if folder != "" && strings.HasPrefix(filePath, folder) {
+			return true
+		}
+	}
+	return false
+}
+
+func revisionsFolder(app *App, filePath string) string {
+	folder := defaultRevisionsFolder
+	if rc, ok := app.Config.(RevisionConfig); ok && rc.GetRevisionsFolder() != "" {
+		folder = rc.GetRevisionsFolder()
+	}
+	return folder + filePath + "/"
+}
+
+func revisionName(app *App, filePath string, number int) string {
+	return fmt.Sprintf("%s%06d%s", revisionsFolder(app, filePath), number, path.Ext(filePath))
+}
+
+// ListRevisions returns the revisions of the file at path, the oldest first
+func ListRevisions(app *App, filePath string) ([]*Revision, error) {
+
+	objects, err := app.Backup.List(revisionsFolder(app, filePath))
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error listing revisions of %s", filePath))
+	}
+
+	var result []*Revision
+	for _, attrs := range objects {
+		_, name := path.Split(attrs.Name)
+		number, errNumber := strconv.Atoi(strings.TrimSuffix(name, path.Ext(name)))
+		if errNumber != nil {
+			continue
+		}
+		result = append(result, &Revision{
+			Path:      filePath,
+			Number:    number,
+			Hash:      attrs.Hash,
+			FetchedAt: attrs.FetchedAt,
+			Updated:   attrs.Updated,
+			name:      attrs.Name,
+		})
+	}
+
+	attrs, err := app.Fetched.Attrs(filePath)
+	if err == ErrObjectNotExist {
+		return result, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading attrs of %s", filePath))
+	}
+
+	number := 1
+	if len(result) > 0 {
+		number = result[len(result)-1].Number + 1
+	}
+	return append(result, &Revision{
+		Path:      filePath,
+		Number:    number,
+		Hash:      attrs.Hash,
+		FetchedAt: attrs.FetchedAt,
+		Updated:   attrs.Updated,
+		Current:   true,
+		name:      filePath,
+	}), nil
+}
+
+// ReadRevision returns the content of the revision number of the file at path
+func ReadRevision(app *App, filePath string, number int) ([]byte, *Revision, error) {
+
+	revisions, err := ListRevisions(app, filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, r := range revisions {
+		if r.Number != number {
+			continue
+		}
+		var content []byte
+		if r.Current {
+			content, err = app.Fetched.Read(r.name)
+		} else {
+			content, err = app.Backup.Read(r.name)
+		}
+		if err != nil {
+			return nil, nil, errors.Wrap(err, fmt.Sprintf("error reading revision %d of %s", number, filePath))
+		}
+		return content, r, nil
+	}
+	return nil, nil, errors.Wrap(ErrObjectNotExist, fmt.Sprintf("revision %d of %s", number, filePath))
+}
+
+// saveRevision copies the stored file to its revision number in the backup store and returns the number and
+// the content
+func (file *File) saveRevision() (int, []byte, error) {
+
+	revisions, err := ListRevisions(file.app, file.GetPath())
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(revisions) == 0 || !revisions[len(revisions)-1].Current {
+		return 0, nil, errors.Wrap(ErrObjectNotExist, file.GetPath())
+	}
+	current := revisions[len(revisions)-1]
+
+	attrs, err := file.app.Fetched.Attrs(file.GetPath())
+	if err != nil {
+		return 0, nil, errors.Wrap(err, fmt.Sprintf("error reading attrs of %s", file.GetPath()))
+	}
+	content, err := file.app.Fetched.Read(file.GetPath())
+	if err != nil {
+		return 0, nil, errors.Wrap(err, fmt.Sprintf("error reading file %s", file.GetPath()))
+	}
+
+	err = file.app.Backup.Write(revisionName(file.app, file.GetPath(), current.Number), content, attrs)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, fmt.Sprintf("error writing revision %d of %s", current.Number, file.GetPath()))
+	}
+	return current.Number, content, nil
+}
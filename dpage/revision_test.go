@@ -0,0 +1,150 @@
+package dpage_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-dpage/dpage"
+	"github.com/rismaster/allris-dpage/dpage/dpagetest"
+	"sync"
+	"testing"
+)
+
+// recordingSink keeps the emitted events
+type recordingSink struct {
+	mutex  sync.Mutex
+	events []*dpage.ChangeEvent
+}
+
+func (s *recordingSink) Emit(event *dpage.ChangeEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *recordingSink) Close() error { return nil }
+
+// summary is the type and revision of each event
+func (s *recordingSink) summary() (result []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, e := range s.events {
+		result = append(result, fmt.Sprintf("%s %s %d", e.Type, e.Path, e.Revision))
+	}
+	return result
+}
+
+func TestRevisions(t *testing.T) {
+
+	app := dpage.NewApp(context.Background(), dpagetest.NewConfig(""), dpage.NewMemoryStore(), dpage.NewMemoryStore())
+	sink := &recordingSink{}
+	app.Events = sink
+	risTime := berlin(t, "12.04.2021 18:00")
+	path := "vorlagen/vorlage-4711.html"
+
+	versions := []string{"<html>erste Fassung</html>", "<html>erste Fassung</html>", "<html>zweite Fassung</html>", "<html>dritte Fassung</html>"}
+	for _, content := range versions {
+		if err := dpage.WriteFile(app, path, []byte(content), "text/html", risTime); err != nil {
+			t.Fatalf("error writing %s: %v", content, err)
+		}
+	}
+
+	assertStrings(t, "stored revisions", listNames(t, app.Backup, "revisions/"), []string{
+		"revisions/vorlagen/vorlage-4711.html/000001.html",
+		"revisions/vorlagen/vorlage-4711.html/000002.html",
+	})
+	assertStrings(t, "events", sink.summary(), []string{
+		"created vorlagen/vorlage-4711.html 1",
+		"updated vorlagen/vorlage-4711.html 2",
+		"updated vorlagen/vorlage-4711.html 3",
+	})
+
+	revisions, err := dpage.ListRevisions(app, path)
+	if err != nil {
+		t.Fatalf("error listing revisions: %v", err)
+	}
+	if len(revisions) != 3 {
+		t.Fatalf("%d revisions, want 3", len(revisions))
+	}
+	for i, r := range revisions {
+		if r.Number != i+1 || r.Path != path || r.Current != (i == 2) || r.Hash == "" {
+			t.Errorf("revision %d is %+v", i+1, r)
+		}
+	}
+
+	for number, want := range map[int]string{1: versions[0], 2: versions[2], 3: versions[3]} {
+		content, revision, err := dpage.ReadRevision(app, path, number)
+		if err != nil {
+			t.Fatalf("error reading revision %d: %v", number, err)
+		}
+		if string(content) != want || revision.Number != number {
+			t.Errorf("revision %d is %d: %s, want %s", number, revision.Number, content, want)
+		}
+	}
+	if _, _, err = dpage.ReadRevision(app, path, 4); errors.Cause(err) != dpage.ErrObjectNotExist {
+		t.Errorf("reading a missing revision: %v", err)
+	}
+
+	revisions, err = dpage.ListRevisions(app, "vorlagen/vorlage-4712.html")
+	if err != nil || len(revisions) != 0 {
+		t.Errorf("revisions of a missing file are %v: %v", revisions, err)
+	}
+}
+
+func TestRevisionsOnlyOfDocuments(t *testing.T) {
+
+	tests := []struct {
+		path        string
+		contentType string
+		revisions   bool
+	}{
+		{"sitzungen/sitzung-1001.html", "text/html", true},
+		{"tops/top-20002.html", "text/html", true},
+		{"vorlagen/vorlage-4711.html", "text/html", true},
+		{"vorlagen/vorlage-4711.json", "application/json", false},
+		{"sitzungen/sitzung-1001.json", "application/json", false},
+		{"vorlagenliste-0.html", "text/html", false},
+		{"anlagen/vorlage-4711-anlage-245-kb-lageplan.pdf", "application/pdf", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			app := dpage.NewApp(context.Background(), dpagetest.NewConfig(""), dpage.NewMemoryStore(), dpage.NewMemoryStore())
+			sink := &recordingSink{}
+			app.Events = sink
+			risTime := berlin(t, "12.04.2021 18:00")
+
+			for _, content := range []string{"erste Fassung", "zweite Fassung"} {
+				if err := dpage.WriteFile(app, tt.path, []byte(content), tt.contentType, risTime); err != nil {
+					t.Fatalf("error writing %s: %v", content, err)
+				}
+			}
+
+			stored := listNames(t, app.Backup, "revisions/")
+			if tt.revisions != (len(stored) == 1) {
+				t.Errorf("stored revisions are %v", stored)
+			}
+			revisions, err := dpage.ListRevisions(app, tt.path)
+			if err != nil || len(revisions) == 0 || !revisions[len(revisions)-1].Current {
+				t.Fatalf("revisions are %v: %v", revisions, err)
+			}
+			content, _, err := dpage.ReadRevision(app, tt.path, revisions[len(revisions)-1].Number)
+			if err != nil || string(content) != "zweite Fassung" {
+				t.Errorf("current revision is %s: %v", content, err)
+			}
+
+			// only documents of the syncs are emitted, their update has a revision if it is kept
+			events := sink.summary()
+			if len(events) > 0 {
+				want := fmt.Sprintf("updated %s 0", tt.path)
+				if tt.revisions {
+					want = fmt.Sprintf("updated %s 2", tt.path)
+				}
+				if len(events) != 2 || events[1] != want {
+					t.Errorf("events are %v, want the update %s", events, want)
+				}
+			}
+		})
+	}
+}
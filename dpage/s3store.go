@@ -0,0 +1,180 @@
+package dpage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// the user metadata keys as returned by S3, canonicalized like http headers
+const s3MetadataHash = "Hash"
+const s3MetadataFetchedAt = "Fetched-At"
+const s3MetadataRisTime = "Ris-Time"
+
+// S3Store stores the objects in a bucket of a S3 compatible storage like MinIO
+type S3Store struct {
+	ctx    context.Context
+	client *minio.Client
+	bucket string
+}
+
+func NewS3Store(ctx context.Context, client *minio.Client, bucket string) *S3Store {
+	return &S3Store{
+		ctx:    ctx,
+		client: client,
+		bucket: bucket,
+	}
+}
+
+func isS3NotExist(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return code == "NoSuchKey" || code == "NotFound"
+}
+
+func (s *S3Store) Attrs(path string) (*ObjectAttrs, error) {
+
+	info, err := s.client.StatObject(s.ctx, s.bucket, path, minio.StatObjectOptions{})
+	if isS3NotExist(err) {
+		return nil, ErrObjectNotExist
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading attrs of %s", path))
+	}
+	return s3ObjectAttrs(info), nil
+}
+
+func s3ObjectAttrs(info minio.ObjectInfo) *ObjectAttrs {
+
+	attrs := &ObjectAttrs{
+		Name:        info.Key,
+		ContentType: info.ContentType,
+		Updated:     info.LastModified,
+		Metadata:    make(map[string]string),
+	}
+	for k, v := range info.UserMetadata {
+		switch http.CanonicalHeaderKey(k) {
+		case s3MetadataHash:
+			attrs.Hash = v
+		case s3MetadataFetchedAt:
+			attrs.FetchedAt, _ = time.Parse(time.RFC3339, v)
+		case s3MetadataRisTime:
+			attrs.RisTime, _ = time.Parse(time.RFC3339, v)
+		default:
+			attrs.Metadata[k] = v
+		}
+	}
+	return attrs
+}
+
+func (s *S3Store) Read(path string) ([]byte, error) {
+
+	obj, err := s.client.GetObject(s.ctx, s.bucket, path, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading %s", path))
+	}
+	defer obj.Close()
+
+	content, err := ioutil.ReadAll(obj)
+	if isS3NotExist(err) {
+		return nil, ErrObjectNotExist
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading %s", path))
+	}
+	return content, nil
+}
+
+func (s *S3Store) userMetadata(attrs *ObjectAttrs) map[string]string {
+
+	metadata := copyMetadata(attrs.Metadata)
+	metadata[s3MetadataHash] = attrs.Hash
+	metadata[s3MetadataFetchedAt] = attrs.FetchedAt.Format(time.RFC3339)
+	metadata[s3MetadataRisTime] = attrs.RisTime.Format(time.RFC3339)
+	return metadata
+}
+
+func (s *S3Store) Write(path string, content []byte, attrs *ObjectAttrs) error {
+
+	_, err := s.client.PutObject(s.ctx, s.bucket, path, bytes.NewReader(content), int64(len(content)), minio.PutObjectOptions{
+		ContentType:  attrs.ContentType,
+		UserMetadata: s.userMetadata(attrs),
+	})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error writing %s", path))
+	}
+	return nil
+}
+
+// Touch copies the object onto itself, objects can not be modified in place. The replaced metadata contains
+// the content type, S3 would reset it otherwise.
+func (s *S3Store) Touch(path string) error {
+
+	attrs, err := s.Attrs(path)
+	if err != nil {
+		return err
+	}
+
+	metadata := s.userMetadata(attrs)
+	metadata["Content-Type"] = attrs.ContentType
+	_, err = s.client.CopyObject(s.ctx, minio.CopyDestOptions{
+		Bucket:          s.bucket,
+		Object:          path,
+		UserMetadata:    metadata,
+		ReplaceMetadata: true,
+	}, minio.CopySrcOptions{
+		Bucket: s.bucket,
+		Object: path,
+	})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error touching %s", path))
+	}
+	return nil
+}
+
+func (s *S3Store) Delete(path string) error {
+
+	_, err := s.Attrs(path)
+	if err != nil {
+		return err
+	}
+
+	err = s.client.RemoveObject(s.ctx, s.bucket, path, minio.RemoveObjectOptions{})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error deleting %s", path))
+	}
+	return nil
+}
+
+// List reads the attributes of every object, the listing of S3 contains no user metadata
+func (s *S3Store) List(prefix string) (result []*ObjectAttrs, err error) {
+
+	// cancel the listing if it is not read to the end
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+
+	for info := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if info.Err != nil {
+			return nil, errors.Wrap(info.Err, fmt.Sprintf("error listing %s", prefix))
+		}
+
+		attrs, errAttrs := s.Attrs(info.Key)
+		if errAttrs == ErrObjectNotExist {
+			continue
+		}
+		if errAttrs != nil {
+			return nil, errAttrs
+		}
+		result = append(result, attrs)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
@@ -0,0 +1,450 @@
+package dpage
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/analysis/lang/de"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/pkg/errors"
+	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-common/common/domtools"
+	"github.com/rismaster/allris-common/common/slog"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+const defaultSearchSize = 20
+const searchFacetSize = 20
+
+// the facets of a SearchResult
+const FacetType = "type"
+const FacetGremium = "gremium"
+const FacetYear = "year"
+
+// SearchConfig can be implemented by the Config to keep a search index of the Vorlagen, Sitzungen, TOPs and
+// Anlagen in a local directory, it is updated by the syncs
+type SearchConfig interface {
+	GetSearchIndexPath() string
+}
+
+// SearchDocument is a document of the search index, its id is the path of the model or text in the fetched
+// store. Hash is the hash of the stored files it was built from.
+type SearchDocument struct {
+	Type    string    `json:"type"`
+	Path    string    `json:"path"`
+	Gremium string    `json:"gremium"`
+	Datum   time.Time `json:"datum"`
+	Year    string    `json:"year"`
+	VOLFDNR int       `json:"volfdnr"`
+	SILFDNR int       `json:"silfdnr"`
+	Betreff string    `json:"betreff"`
+	Text    string    `json:"text"`
+	Hash    string    `json:"hash"`
+}
+
+// SearchRequest is a query string like `"Radweg Hauptstraße"` filtered by type, Gremium and date
+type SearchRequest struct {
+	Query   string
+	Type    string
+	Gremium string
+	From    time.Time
+	To      time.Time
+	Size    int
+	Offset  int
+}
+
+type SearchHit struct {
+	Path      string
+	Type      string
+	Gremium   string
+	Datum     time.Time
+	VOLFDNR   int
+	SILFDNR   int
+	Betreff   string
+	Score     float64
+	Fragments map[string][]string
+}
+
+type FacetCount struct {
+	Term  string
+	Count int
+}
+
+// SearchResult contains the hits with highlighted fragments of Betreff and text and the counts of the
+// facets type, gremium and year
+type SearchResult struct {
+	Total  uint64
+	Hits   []*SearchHit
+	Facets map[string][]FacetCount
+}
+
+// SearchIndex is a bleve index of the fetched documents
+type SearchIndex struct {
+	index bleve.Index
+}
+
+func newSearchMapping() mapping.IndexMapping {
+
+	keywordField := bleve.NewTextFieldMapping()
+	keywordField.Analyzer = keyword.Name
+
+	textField := bleve.NewTextFieldMapping()
+	textField.Analyzer = de.AnalyzerName
+
+	storedOnly := bleve.NewTextFieldMapping()
+	storedOnly.Index = false
+
+	doc := bleve.NewDocumentMapping()
+	doc.AddFieldMappingsAt("type", keywordField)
+	doc.AddFieldMappingsAt("path", storedOnly)
+	doc.AddFieldMappingsAt("gremium", keywordField)
+	doc.AddFieldMappingsAt("datum", bleve.NewDateTimeFieldMapping())
+	doc.AddFieldMappingsAt("year", keywordField)
+	doc.AddFieldMappingsAt("volfdnr", bleve.NewNumericFieldMapping())
+	doc.AddFieldMappingsAt("silfdnr", bleve.NewNumericFieldMapping())
+	doc.AddFieldMappingsAt("betreff", textField)
+	doc.AddFieldMappingsAt("text", textField)
+	doc.AddFieldMappingsAt("hash", storedOnly)
+
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping = doc
+	m.DefaultAnalyzer = de.AnalyzerName
+	return m
+}
+
+// OpenSearchIndex opens the index at indexPath or creates it
+func OpenSearchIndex(indexPath string) (*SearchIndex, error) {
+
+	index, err := bleve.Open(indexPath)
+	if err == bleve.ErrorIndexPathDoesNotExist {
+		index, err = bleve.New(indexPath, newSearchMapping())
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error opening search index %s", indexPath))
+	}
+	return &SearchIndex{index: index}, nil
+}
+
+// NewMemSearchIndex creates an index in memory
+func NewMemSearchIndex() (*SearchIndex, error) {
+	index, err := bleve.NewMemOnly(newSearchMapping())
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating search index")
+	}
+	return &SearchIndex{index: index}, nil
+}
+
+// newSearchIndex returns the index of the SearchConfig and the key to release it. Bleve locks the index, so
+// all Apps of the process share it and the last App closes it.
+func newSearchIndex(conf allris_common.Config) (*SearchIndex, string, error) {
+	sc, ok := conf.(SearchConfig)
+	if !ok || sc.GetSearchIndexPath() == "" {
+		return nil, "", nil
+	}
+
+	key := "index:" + sc.GetSearchIndexPath()
+	index, err := shared.acquire(key, func() (io.Closer, error) {
+		return OpenSearchIndex(sc.GetSearchIndexPath())
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return index.(*SearchIndex), key, nil
+}
+
+func (idx *SearchIndex) Close() error {
+	return idx.index.Close()
+}
+
+// searchId is the id of the document of a stored file: the model next to a html page or the text of a PDF
+func searchId(app *App, filePath string) string {
+
+	folder, _ := path.Split(filePath)
+	if folder == app.Config.GetAnlagenFolder() {
+		if strings.HasSuffix(filePath, textEnding) {
+			return filePath
+		}
+		return ""
+	}
+	if strings.HasSuffix(filePath, jsonEnding) {
+		return filePath
+	}
+	if strings.HasSuffix(filePath, htmlEnding) {
+		return strings.TrimSuffix(filePath, htmlEnding) + jsonEnding
+	}
+	return ""
+}
+
+// apply updates the index with a change of the fetched store, errors are logged
+func (idx *SearchIndex) apply(app *App, event *ChangeEvent) {
+
+	if idx == nil {
+		return
+	}
+	switch event.DocumentType {
+	case DocumentVorlage, DocumentSitzung, DocumentTop, DocumentAnlage:
+	default:
+		return
+	}
+	id := searchId(app, event.Path)
+	if id == "" {
+		return
+	}
+
+	if event.Type == EventDeleted {
+		if id == event.Path {
+			err := idx.index.Delete(id)
+			if err != nil {
+				slog.Error("error deleting %s from search index: %v", id, err)
+			}
+		}
+		return
+	}
+
+	_, err := idx.update(app, id)
+	if err != nil {
+		slog.Error("error indexing %s: %v", id, err)
+	}
+}
+
+// update indexes the document id if the hash of its stored files changed and returns if it was indexed
+func (idx *SearchIndex) update(app *App, id string) (bool, error) {
+
+	doc, err := newSearchDocument(app, id)
+	if errors.Cause(err) == ErrObjectNotExist {
+		// the model of a new page is written after the page
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	req := bleve.NewSearchRequest(bleve.NewDocIDQuery([]string{id}))
+	req.Fields = []string{"hash"}
+	res, err := idx.index.Search(req)
+	if err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("error searching %s", id))
+	}
+	if len(res.Hits) > 0 && res.Hits[0].Fields["hash"] == doc.Hash {
+		return false, nil
+	}
+
+	err = idx.index.Index(id, doc)
+	if err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("error indexing %s", id))
+	}
+	return true, nil
+}
+
+// newSearchDocument builds the document of a model with the text of its html page or of the text of a PDF
+// with the Betreff, Gremium and date of the Vorlage or Sitzung it belongs to
+func newSearchDocument(app *App, id string) (*SearchDocument, error) {
+
+	attrs, err := app.Fetched.Attrs(id)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading attrs of %s", id))
+	}
+
+	documentType, _ := documentType(app, id)
+	doc := &SearchDocument{Type: documentType, Path: id, Hash: attrs.Hash}
+
+	switch documentType {
+	case DocumentVorlage:
+		v := &Vorlage{}
+		if err = readJson(app, id, v); err != nil {
+			return nil, err
+		}
+		doc.VOLFDNR, doc.Betreff, doc.Gremium, doc.Datum = v.VOLFDNR, v.Betreff, v.Federfuehrend, v.Datum
+	case DocumentSitzung:
+		si := &Sitzung{}
+		if err = readJson(app, id, si); err != nil {
+			return nil, err
+		}
+		doc.SILFDNR, doc.Betreff, doc.Gremium, doc.Datum = si.SILFDNR, si.Bezeichnung, si.Gremium, si.Start
+	case DocumentTop:
+		t := &Top{}
+		if err = readJson(app, id, t); err != nil {
+			return nil, err
+		}
+		doc.VOLFDNR, doc.SILFDNR, doc.Betreff = t.VOLFDNR, t.SILFDNR, strings.TrimSpace(t.Nummer+" "+t.Betreff)
+		if t.SILFDNR == 0 {
+			doc.SILFDNR = risIdOf(id, app.Config.GetSitzungType())
+		}
+		si := &Sitzung{}
+		if readJson(app, sitzungModelPath(app, doc.SILFDNR), si) == nil {
+			doc.Gremium, doc.Datum = si.Gremium, si.Start
+		}
+	case DocumentAnlage:
+		text := &PdfText{}
+		if err = readJson(app, id, text); err != nil {
+			return nil, err
+		}
+		doc.Text = text.String()
+		doc.addParent(app, id)
+	default:
+		return nil, errors.Wrap(ErrUnknownFolder, id)
+	}
+
+	if documentType != DocumentAnlage {
+		htmlPath := strings.TrimSuffix(id, jsonEnding) + htmlEnding
+		htmlAttrs, errHtml := app.Fetched.Attrs(htmlPath)
+		if errHtml != nil {
+			return nil, errors.Wrap(errHtml, fmt.Sprintf("error reading attrs of %s", htmlPath))
+		}
+		doc.Hash += "-" + htmlAttrs.Hash
+		doc.Text, err = pageText(app, htmlPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !doc.Datum.IsZero() {
+		doc.Year = doc.Datum.Format("2006")
+	}
+	return doc, nil
+}
+
+// addParent copies the ids, Betreff, Gremium and date of the Vorlage or Sitzung of an Anlage
+func (doc *SearchDocument) addParent(app *App, id string) {
+
+	doc.VOLFDNR = risIdOf(id, app.Config.GetVorlageType())
+	if doc.VOLFDNR != 0 {
+		v := &Vorlage{}
+		if readJson(app, vorlageModelPath(app, doc.VOLFDNR), v) == nil {
+			doc.Betreff, doc.Gremium, doc.Datum = v.Betreff, v.Federfuehrend, v.Datum
+		}
+		return
+	}
+
+	doc.SILFDNR = risIdOf(id, app.Config.GetSitzungType())
+	if doc.SILFDNR != 0 {
+		si := &Sitzung{}
+		if readJson(app, sitzungModelPath(app, doc.SILFDNR), si) == nil {
+			doc.Betreff, doc.Gremium, doc.Datum = si.Bezeichnung, si.Gremium, si.Start
+		}
+	}
+}
+
+func vorlageModelPath(app *App, volfdnr int) string {
+	return fmt.Sprintf("%s%s-%d%s", app.Config.GetVorlagenFolder(), app.Config.GetVorlageType(), volfdnr, jsonEnding)
+}
+
+func sitzungModelPath(app *App, silfdnr int) string {
+	return fmt.Sprintf("%s%s-%d%s", app.Config.GetSitzungenFolder(), app.Config.GetSitzungType(), silfdnr, jsonEnding)
+}
+
+// pageText is the text of the allriscontainer of a stored html page
+func pageText(app *App, htmlPath string) (string, error) {
+
+	content, err := app.Fetched.Read(htmlPath)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("error reading %s", htmlPath))
+	}
+	dom, err := goquery.NewDocumentFromReader(bytes.NewReader(content))
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("error create dom from %s", htmlPath))
+	}
+	return domtools.CleanText(dom.Find("#allriscontainer").Text()), nil
+}
+
+// Reindex indexes all stored Vorlagen, Sitzungen, TOPs and Anlagen whose files changed since they were
+// indexed and returns their number
+func (idx *SearchIndex) Reindex(app *App) (int, error) {
+
+	indexed := 0
+	folders := []string{app.Config.GetVorlagenFolder(), app.Config.GetSitzungenFolder(), app.Config.GetTopFolder(), app.Config.GetAnlagenFolder()}
+	for _, folder := range folders {
+		objects, err := listJsons(app, folder)
+		if err != nil {
+			return indexed, errors.Wrap(err, fmt.Sprintf("error listing %s", folder))
+		}
+		for _, attrs := range objects {
+			if searchId(app, attrs.Name) != attrs.Name {
+				continue
+			}
+			updated, errUpdate := idx.update(app, attrs.Name)
+			if errUpdate != nil {
+				return indexed, errUpdate
+			}
+			if updated {
+				indexed++
+			}
+		}
+	}
+	return indexed, nil
+}
+
+// Search runs the query string with the filters of the request, an empty query matches all documents
+func (idx *SearchIndex) Search(request *SearchRequest) (*SearchResult, error) {
+
+	var q query.Query = bleve.NewMatchAllQuery()
+	if request.Query != "" {
+		q = bleve.NewQueryStringQuery(request.Query)
+	}
+
+	conjuncts := []query.Query{q}
+	if request.Type != "" {
+		tq := bleve.NewTermQuery(request.Type)
+		tq.SetField("type")
+		conjuncts = append(conjuncts, tq)
+	}
+	if request.Gremium != "" {
+		gq := bleve.NewTermQuery(request.Gremium)
+		gq.SetField("gremium")
+		conjuncts = append(conjuncts, gq)
+	}
+	if !request.From.IsZero() || !request.To.IsZero() {
+		dq := bleve.NewDateRangeQuery(request.From, request.To)
+		dq.SetField("datum")
+		conjuncts = append(conjuncts, dq)
+	}
+
+	size := request.Size
+	if size <= 0 {
+		size = defaultSearchSize
+	}
+	req := bleve.NewSearchRequestOptions(bleve.NewConjunctionQuery(conjuncts...), size, request.Offset, false)
+	req.Fields = []string{"type", "path", "gremium", "datum", "volfdnr", "silfdnr", "betreff"}
+	req.Highlight = bleve.NewHighlight()
+	req.Highlight.AddField("betreff")
+	req.Highlight.AddField("text")
+	for _, facet := range []string{FacetType, FacetGremium, FacetYear} {
+		req.AddFacet(facet, bleve.NewFacetRequest(facet, searchFacetSize))
+	}
+
+	res, err := idx.index.Search(req)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error searching %s", request.Query))
+	}
+
+	result := &SearchResult{Total: res.Total, Facets: make(map[string][]FacetCount)}
+	for _, hit := range res.Hits {
+		h := &SearchHit{Score: hit.Score, Fragments: hit.Fragments}
+		h.Path, _ = hit.Fields["path"].(string)
+		h.Type, _ = hit.Fields["type"].(string)
+		h.Gremium, _ = hit.Fields["gremium"].(string)
+		h.Betreff, _ = hit.Fields["betreff"].(string)
+		if datum, ok := hit.Fields["datum"].(string); ok {
+			h.Datum, _ = time.Parse(time.RFC3339, datum)
+		}
+		if volfdnr, ok := hit.Fields["volfdnr"].(float64); ok {
+			h.VOLFDNR = int(volfdnr)
+		}
+		if silfdnr, ok := hit.Fields["silfdnr"].(float64); ok {
+			h.SILFDNR = int(silfdnr)
+		}
+		result.Hits = append(result.Hits, h)
+	}
+	for name, facet := range res.Facets {
+		for _, term := range facet.Terms {
+			result.Facets[name] = append(result.Facets[name], FacetCount{Term: term.Term, Count: term.Count})
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,240 @@
+package dpage_test
+
+import (
+	"context"
+	"github.com/rismaster/allris-dpage/dpage"
+	"github.com/rismaster/allris-dpage/dpage/dpagetest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// searchPage is a stored page with the text in the allriscontainer like in ALLRIS
+func searchPage(text string) []byte {
+	return []byte(`<html><body><div id="navigation">Navigation</div><div id="allriscontainer"><p>` + text + `</p></div></body></html>`)
+}
+
+// writeSearchDocuments writes the pages and models of two Vorlagen, two Sitzungen, a TOP and an Anlage
+func writeSearchDocuments(t *testing.T, app *dpage.App) {
+	t.Helper()
+
+	pages := []struct {
+		path  string
+		text  string
+		model interface{}
+	}{
+		{"vorlagen/vorlage-4711", "Der Radweg an der Hauptstraße wird erneuert.",
+			&dpage.Vorlage{VOLFDNR: 4711, Nummer: "VO/2021/0815", Betreff: "Neubau Radweg Hauptstraße", Federfuehrend: "Bauausschuss", Datum: berlin(t, "01.03.2021 00:00")}},
+		{"vorlagen/vorlage-4710", "Aufstellung des Bebauungsplans für das Gewerbegebiet.",
+			&dpage.Vorlage{VOLFDNR: 4710, Nummer: "VO/2020/0412", Betreff: "Bebauungsplan Nr. 12", Federfuehrend: "Bauausschuss", Datum: berlin(t, "15.06.2020 00:00")}},
+		{"sitzungen/sitzung-1001", "Tagesordnung: Radweg, Bebauungsplan",
+			&dpage.Sitzung{SILFDNR: 1001, Gremium: "Bauausschuss", Bezeichnung: "12. Sitzung des Bauausschusses", Start: berlin(t, "12.04.2021 18:00")}},
+		{"sitzungen/sitzung-1002", "Tagesordnung: Sanierung der Grundschule",
+			&dpage.Sitzung{SILFDNR: 1002, Gremium: "Schulausschuss", Bezeichnung: "3. Sitzung des Schulausschusses", Start: berlin(t, "20.04.2021 18:00")}},
+		{"tops/sitzung-1001-top-20002", "Beschluss über den Radweg",
+			&dpage.Top{TOLFDNR: 20002, SILFDNR: 1001, Nummer: "7", Betreff: "Radweg Hauptstraße", VOLFDNR: 4711}},
+	}
+	for _, p := range pages {
+		if err := dpage.WriteFile(app, p.path+".html", searchPage(p.text), "text/html", time.Now()); err != nil {
+			t.Fatalf("error writing %s: %v", p.path, err)
+		}
+		writeModel(t, app, p.path+".json", p.model)
+	}
+	writeModel(t, app, "anlagen/vorlage-4711-anlagedoc-55501-1.text.json", &dpage.PdfText{Pages: []dpage.PdfPage{
+		{Number: 1, Text: "Kostenschätzung"},
+		{Number: 2, Text: "Die Fahrradstraße kostet 120.000 Euro."},
+	}})
+}
+
+func newSearchApp(t *testing.T) *dpage.App {
+	t.Helper()
+	app := dpage.NewApp(context.Background(), dpagetest.NewConfig(""), dpage.NewMemoryStore(), dpage.NewMemoryStore())
+	index, err := dpage.NewMemSearchIndex()
+	if err != nil {
+		t.Fatalf("error creating index: %v", err)
+	}
+	t.Cleanup(func() { _ = index.Close() })
+	app.Index = index
+	return app
+}
+
+func hitPaths(result *dpage.SearchResult) (paths []string) {
+	for _, hit := range result.Hits {
+		paths = append(paths, hit.Path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestSearch(t *testing.T) {
+
+	app := newSearchApp(t)
+	writeSearchDocuments(t, app)
+
+	tests := []struct {
+		name    string
+		request dpage.SearchRequest
+		want    []string
+	}{
+		{"all", dpage.SearchRequest{}, []string{
+			"anlagen/vorlage-4711-anlagedoc-55501-1.text.json",
+			"sitzungen/sitzung-1001.json",
+			"sitzungen/sitzung-1002.json",
+			"tops/sitzung-1001-top-20002.json",
+			"vorlagen/vorlage-4710.json",
+			"vorlagen/vorlage-4711.json",
+		}},
+		// the Anlage has the Betreff of its Vorlage
+		{"query", dpage.SearchRequest{Query: "radweg"}, []string{
+			"anlagen/vorlage-4711-anlagedoc-55501-1.text.json",
+			"sitzungen/sitzung-1001.json",
+			"tops/sitzung-1001-top-20002.json",
+			"vorlagen/vorlage-4711.json",
+		}},
+		{"stemmed query", dpage.SearchRequest{Query: "Sanierungen"}, []string{"sitzungen/sitzung-1002.json"}},
+		{"text of anlage", dpage.SearchRequest{Query: "Kostenschätzung"}, []string{"anlagen/vorlage-4711-anlagedoc-55501-1.text.json"}},
+		{"type", dpage.SearchRequest{Query: "radweg", Type: dpage.DocumentVorlage}, []string{"vorlagen/vorlage-4711.json"}},
+		{"gremium", dpage.SearchRequest{Type: dpage.DocumentSitzung, Gremium: "Schulausschuss"}, []string{"sitzungen/sitzung-1002.json"}},
+		{"gremium of top", dpage.SearchRequest{Type: dpage.DocumentTop, Gremium: "Bauausschuss"}, []string{"tops/sitzung-1001-top-20002.json"}},
+		{"from", dpage.SearchRequest{Type: dpage.DocumentVorlage, From: berlin(t, "01.01.2021 00:00")}, []string{"vorlagen/vorlage-4711.json"}},
+		{"to", dpage.SearchRequest{Type: dpage.DocumentVorlage, To: berlin(t, "01.01.2021 00:00")}, []string{"vorlagen/vorlage-4710.json"}},
+		{"no hit", dpage.SearchRequest{Query: "Schwimmbad"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := tt.request
+			result, err := app.Index.Search(&request)
+			if err != nil {
+				t.Fatalf("error searching: %v", err)
+			}
+			assertStrings(t, "hits", hitPaths(result), tt.want)
+			if result.Total != uint64(len(tt.want)) {
+				t.Errorf("total is %d, want %d", result.Total, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestSearchHitsAndFacets(t *testing.T) {
+
+	app := newSearchApp(t)
+	writeSearchDocuments(t, app)
+
+	result, err := app.Index.Search(&dpage.SearchRequest{Query: "radweg", Type: dpage.DocumentVorlage})
+	if err != nil {
+		t.Fatalf("error searching: %v", err)
+	}
+	if len(result.Hits) != 1 {
+		t.Fatalf("%d hits, want 1", len(result.Hits))
+	}
+	hit := result.Hits[0]
+	if hit.Type != dpage.DocumentVorlage || hit.VOLFDNR != 4711 || hit.Betreff != "Neubau Radweg Hauptstraße" || hit.Gremium != "Bauausschuss" || hit.Score <= 0 {
+		t.Errorf("hit is %+v", hit)
+	}
+	assertTime(t, "datum", hit.Datum, berlin(t, "01.03.2021 00:00"))
+	for _, field := range []string{"betreff", "text"} {
+		if len(hit.Fragments[field]) == 0 || !strings.Contains(hit.Fragments[field][0], "<mark>Radweg</mark>") {
+			t.Errorf("fragments of %s are %v", field, hit.Fragments[field])
+		}
+	}
+
+	// the facets count all matching documents, not only the hits of the page
+	result, err = app.Index.Search(&dpage.SearchRequest{Size: 1})
+	if err != nil {
+		t.Fatalf("error searching: %v", err)
+	}
+	if len(result.Hits) != 1 || result.Total != 6 {
+		t.Errorf("%d hits of %d, want 1 of 6", len(result.Hits), result.Total)
+	}
+	facets := make(map[string]int)
+	for name, counts := range result.Facets {
+		for _, c := range counts {
+			facets[name+" "+c.Term] = c.Count
+		}
+	}
+	want := map[string]int{
+		"type vorlage":           2,
+		"type sitzung":           2,
+		"type top":               1,
+		"type anlage":            1,
+		"gremium Bauausschuss":   5,
+		"gremium Schulausschuss": 1,
+		"year 2021":              5,
+		"year 2020":              1,
+	}
+	for facet, count := range want {
+		if facets[facet] != count {
+			t.Errorf("facet %s is %d, want %d", facet, facets[facet], count)
+		}
+	}
+
+	next, err := app.Index.Search(&dpage.SearchRequest{Size: 1, Offset: 1})
+	if err != nil || len(next.Hits) != 1 || next.Hits[0].Path == result.Hits[0].Path {
+		t.Errorf("second page is %v: %v", hitPaths(next), err)
+	}
+}
+
+func TestReindex(t *testing.T) {
+
+	app := newSearchApp(t)
+	writeSearchDocuments(t, app)
+
+	// a new index of the stored documents
+	index, err := dpage.NewMemSearchIndex()
+	if err != nil {
+		t.Fatalf("error creating index: %v", err)
+	}
+	defer index.Close()
+	app.Index = index
+
+	indexed, err := index.Reindex(app)
+	if err != nil {
+		t.Fatalf("error reindexing: %v", err)
+	}
+	if indexed != 6 {
+		t.Errorf("%d documents indexed, want 6", indexed)
+	}
+
+	// unchanged documents are skipped by their hash
+	indexed, err = index.Reindex(app)
+	if err != nil || indexed != 0 {
+		t.Errorf("%d unchanged documents indexed again: %v", indexed, err)
+	}
+
+	// a changed page is indexed again with the unchanged model
+	app.Index = nil
+	if err = dpage.WriteFile(app, "sitzungen/sitzung-1002.html", searchPage("Tagesordnung: Neubau der Sporthalle"), "text/html", time.Now()); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	indexed, err = index.Reindex(app)
+	if err != nil || indexed != 1 {
+		t.Errorf("%d changed documents indexed: %v", indexed, err)
+	}
+	result, err := index.Search(&dpage.SearchRequest{Query: "Sporthalle"})
+	if err != nil {
+		t.Fatalf("error searching: %v", err)
+	}
+	assertStrings(t, "hits of changed page", hitPaths(result), []string{"sitzungen/sitzung-1002.json"})
+	result, _ = index.Search(&dpage.SearchRequest{Query: "Grundschule"})
+	assertStrings(t, "hits of old text", hitPaths(result), nil)
+}
+
+func TestSearchIndexDelete(t *testing.T) {
+
+	app := newSearchApp(t)
+	writeSearchDocuments(t, app)
+
+	if err := dpage.TombstoneFile(app, "vorlagen/vorlage-4711.json", "test"); err != nil {
+		t.Fatalf("error deleting: %v", err)
+	}
+	if err := dpage.TombstoneFile(app, "anlagen/vorlage-4711-anlagedoc-55501-1.text.json", "test"); err != nil {
+		t.Fatalf("error deleting: %v", err)
+	}
+	result, err := app.Index.Search(&dpage.SearchRequest{Query: "radweg kostenschätzung"})
+	if err != nil {
+		t.Fatalf("error searching: %v", err)
+	}
+	assertStrings(t, "hits after delete", hitPaths(result), []string{"sitzungen/sitzung-1001.json", "tops/sitzung-1001-top-20002.json"})
+}
@@ -0,0 +1,99 @@
+package dpage
+
+import (
+	allris_common "github.com/rismaster/allris-common"
+	"io"
+	"net/url"
+	"sync"
+)
+
+// shared are the resources used by all Apps of the process, e.g. by concurrent Download calls
+var shared = &sharedResources{
+	closers:      make(map[string]*sharedCloser),
+	fetchers:     make(map[string]*Fetcher),
+	memoryStores: make(map[string]*MemoryStore),
+}
+
+type sharedResources struct {
+	mutex        sync.Mutex
+	closers      map[string]*sharedCloser
+	fetchers     map[string]*Fetcher
+	memoryStores map[string]*MemoryStore
+}
+
+// sharedCloser is a resource like the search index which is opened by the first App and closed with the last
+type sharedCloser struct {
+	closer io.Closer
+	refs   int
+}
+
+// acquire returns the resource of the key, it is opened if no other App uses it
+func (s *sharedResources) acquire(key string, open func() (io.Closer, error)) (io.Closer, error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if c, ok := s.closers[key]; ok {
+		c.refs++
+		return c.closer, nil
+	}
+	closer, err := open()
+	if err != nil {
+		return nil, err
+	}
+	s.closers[key] = &sharedCloser{closer: closer, refs: 1}
+	return closer, nil
+}
+
+// release closes the resource of the key if no other App uses it
+func (s *sharedResources) release(key string) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c, ok := s.closers[key]
+	if !ok {
+		return nil
+	}
+	c.refs--
+	if c.refs > 0 {
+		return nil
+	}
+	delete(s.closers, key)
+	return c.closer.Close()
+}
+
+// fetcher returns the Fetcher of the target host of the Config, so all syncs and downloads of the process
+// share its rate limiter, robots.txt and circuit breaker. The settings of the first Config of a host are used.
+func (s *sharedResources) fetcher(conf allris_common.Config) *Fetcher {
+
+	key := conf.GetTargetToParse()
+	if target, err := url.Parse(key); err == nil && target.Host != "" {
+		key = target.Host
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	f, ok := s.fetchers[key]
+	if !ok {
+		f = newFetcher(conf)
+		s.fetchers[key] = f
+	}
+	return f
+}
+
+// memoryStore returns the MemoryStore of the bucket, it keeps the mirror of the memory store type between the
+// Apps of the process
+func (s *sharedResources) memoryStore(bucket string) *MemoryStore {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	store, ok := s.memoryStores[bucket]
+	if !ok {
+		store = NewMemoryStore()
+		s.memoryStores[bucket] = store
+	}
+	return store
+}
@@ -0,0 +1,151 @@
+package dpage
+
+import (
+	"bytes"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/domtools"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeRegexp = regexp.MustCompile(`([0-9]{1,2}):([0-9]{2})`)
+
+// Sitzung is the parsed content of a row of the Sitzungsliste or of a si010 page
+type Sitzung struct {
+	SILFDNR     int          `json:"silfdnr"`
+	GremiumID   int          `json:"gremiumId,omitempty"`
+	Gremium     string       `json:"gremium"`
+	Bezeichnung string       `json:"bezeichnung"`
+	Start       time.Time    `json:"start"`
+	Ende        time.Time    `json:"ende"`
+	Ort         string       `json:"ort,omitempty"`
+	Raum        string       `json:"raum,omitempty"`
+	Status      string       `json:"status,omitempty"`
+	Oeffentlich bool         `json:"oeffentlich"`
+	Tops        []TopInfo    `json:"tops,omitempty"`
+	Anlagen     []AnlageInfo `json:"anlagen,omitempty"`
+}
+
+// TopInfo is a TOP as listed in the Tagesordnung of a Sitzung
+type TopInfo struct {
+	TOLFDNR     int    `json:"tolfdnr,omitempty"`
+	Nummer      string `json:"nummer"`
+	Betreff     string `json:"betreff"`
+	Oeffentlich bool   `json:"oeffentlich"`
+	VOLFDNR     int    `json:"volfdnr,omitempty"`
+}
+
+func ParseSitzung(html []byte, dates *RisDates) (*Sitzung, error) {
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(html))
+	if err != nil {
+		return nil, errors.Wrap(err, "error create dom from sitzung")
+	}
+
+	container := doc.Find("#allriscontainer")
+	if container.Size() == 0 {
+		return nil, errors.New("no allriscontainer in sitzung")
+	}
+
+	labels := labelValues(container)
+	start, ende := parseZeit(dates.parse(labels["Datum"]), labels["Zeit"])
+
+	sitzung := &Sitzung{
+		SILFDNR:     domtools.ExtractIntFromInput(container, "SILFDNR"),
+		Gremium:     labels["Gremium"],
+		Bezeichnung: labels["Bezeichnung"],
+		Start:       start,
+		Ende:        ende,
+		Ort:         labels["Ort"],
+		Raum:        labels["Raum"],
+		Status:      labels["Status"],
+		Anlagen:     parseAnlagen(container),
+	}
+
+	if sitzung.Bezeichnung == "" {
+		title := domtools.CleanText(doc.Find("#risname h1").Text())
+		if i := strings.Index(title, " - "); i >= 0 {
+			sitzung.Bezeichnung = strings.TrimSpace(title[i+3:])
+		}
+	}
+
+	container.Find("table.tl1 tr").Each(func(i int, tr *goquery.Selection) {
+		tds := tr.Children().Filter("td")
+		if tds.Size() < 2 {
+			return
+		}
+		top := parseTopNummer(domtools.CleanText(tds.Eq(0).Text()))
+		if top.Nummer == "" {
+			return
+		}
+		lnk := tr.Find("a[href*=\"to020\"]")
+		if lnk.Size() > 0 {
+			href, _ := lnk.Attr("href")
+			top.TOLFDNR = idFromHref(href, "TOLFDNR")
+			top.Betreff = domtools.CleanText(lnk.Text())
+		} else {
+			top.Betreff = domtools.CleanText(tds.Eq(1).Text())
+		}
+		vorlageHref, _ := tr.Find("a[href*=\"vo020\"]").Attr("href")
+		top.VOLFDNR = idFromHref(vorlageHref, "VOLFDNR")
+		sitzung.Tops = append(sitzung.Tops, top)
+	})
+
+	if sitzung.Status != "" {
+		sitzung.Oeffentlich = !strings.HasPrefix(strings.ToLower(sitzung.Status), "nicht")
+	} else {
+		for _, top := range sitzung.Tops {
+			sitzung.Oeffentlich = sitzung.Oeffentlich || top.Oeffentlich
+		}
+	}
+
+	if sitzung.Start.IsZero() {
+		return nil, errors.New("no Datum in sitzung " + strconv.Itoa(sitzung.SILFDNR))
+	}
+
+	return sitzung, nil
+}
+
+// parseTopNummer reads the number of a TOP like "Ö 3.1" or "N 12"
+func parseTopNummer(text string) TopInfo {
+
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return TopInfo{}
+	}
+	switch fields[0] {
+	case "Ö", "Ö.":
+		return TopInfo{Nummer: strings.Join(fields[1:], " "), Oeffentlich: true}
+	case "N", "N.", "NÖ":
+		return TopInfo{Nummer: strings.Join(fields[1:], " "), Oeffentlich: false}
+	}
+	return TopInfo{Nummer: text, Oeffentlich: true}
+}
+
+// parseZeit reads start and end of a text like "18:00 - 20:15 Uhr" on the given day, the end is zero if missing
+func parseZeit(day time.Time, text string) (start time.Time, ende time.Time) {
+
+	if day.IsZero() {
+		return start, ende
+	}
+	start = day
+
+	times := timeRegexp.FindAllStringSubmatch(text, 2)
+	for i, t := range times {
+		hour, _ := strconv.Atoi(t[1])
+		minute, _ := strconv.Atoi(t[2])
+		d := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, day.Location())
+		if i == 0 {
+			start = d
+		} else {
+			if d.Before(start) {
+				d = d.AddDate(0, 0, 1)
+			}
+			ende = d
+		}
+	}
+	return start, ende
+}
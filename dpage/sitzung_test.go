@@ -0,0 +1,116 @@
+package dpage_test
+
+import (
+	"github.com/rismaster/allris-dpage/dpage"
+	"testing"
+	"time"
+)
+
+func TestParseSitzung(t *testing.T) {
+
+	tests := []struct {
+		fixture string
+		want    dpage.Sitzung
+	}{
+		{
+			fixture: "si010-1001.html",
+			want: dpage.Sitzung{
+				SILFDNR:     1001,
+				Gremium:     "Bau- und Umweltausschuss",
+				Bezeichnung: "12. Sitzung des Bau- und Umweltausschusses",
+				Start:       berlin(t, "12.04.2021 18:00"),
+				Ende:        berlin(t, "12.04.2021 20:15"),
+				Ort:         "Rathaus, Markt 1",
+				Raum:        "Ratssaal",
+				Status:      "öffentlich/nichtöffentlich",
+				Oeffentlich: true,
+				Tops: []dpage.TopInfo{
+					{TOLFDNR: 20001, Nummer: "1", Betreff: "Eröffnung der Sitzung", Oeffentlich: true},
+					{TOLFDNR: 20002, Nummer: "2", Betreff: "Neubau Radweg Hauptstraße", Oeffentlich: true, VOLFDNR: 4711},
+					{TOLFDNR: 20003, Nummer: "3", Betreff: "Grundstücksangelegenheiten"},
+				},
+				Anlagen: []dpage.AnlageInfo{{Name: "Einladung", DOLFDNR: 55601}},
+			},
+		},
+		{
+			fixture: "si010-1002.html",
+			want: dpage.Sitzung{
+				SILFDNR:     1002,
+				Gremium:     "Rat der Stadt",
+				Bezeichnung: "8. Sitzung des Rates",
+				Start:       berlin(t, "29.04.2021 17:00"),
+				Ende:        berlin(t, "29.04.2021 21:00"),
+				Ort:         "Rathaus, Markt 1",
+				Raum:        "Ratssaal",
+				Status:      "öffentlich",
+				Oeffentlich: true,
+				Tops: []dpage.TopInfo{
+					{TOLFDNR: 20010, Nummer: "1", Betreff: "Bericht zur Haushaltslage", Oeffentlich: true, VOLFDNR: 4710},
+					{TOLFDNR: 20011, Nummer: "2", Betreff: "Neubau Radweg Hauptstraße", Oeffentlich: true, VOLFDNR: 4711},
+				},
+			},
+		},
+	}
+
+	dates := testDates(t)
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			sitzung, err := dpage.ParseSitzung(fixture(t, tt.fixture), dates)
+			if err != nil {
+				t.Fatalf("error parsing: %v", err)
+			}
+			assertModel(t, sitzung, tt.want)
+		})
+	}
+}
+
+func TestParseZeit(t *testing.T) {
+
+	day := berlin(t, "12.04.2021 00:00")
+	tests := []struct {
+		text  string
+		start time.Time
+		ende  time.Time
+	}{
+		{text: "18:00 - 20:15 Uhr", start: berlin(t, "12.04.2021 18:00"), ende: berlin(t, "12.04.2021 20:15")},
+		{text: "9:30 Uhr", start: berlin(t, "12.04.2021 09:30")},
+		{text: "22:00 - 01:30 Uhr", start: berlin(t, "12.04.2021 22:00"), ende: berlin(t, "13.04.2021 01:30")},
+		{text: "", start: day},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			start, ende := dpage.ParseZeit(day, tt.text)
+			assertTime(t, "start", start, tt.start)
+			assertTime(t, "ende", ende, tt.ende)
+		})
+	}
+
+	start, ende := dpage.ParseZeit(time.Time{}, "18:00 - 20:15 Uhr")
+	if !start.IsZero() || !ende.IsZero() {
+		t.Errorf("times without day are %s and %s", start, ende)
+	}
+}
+
+func TestParseTopNummer(t *testing.T) {
+
+	tests := []struct {
+		text string
+		want dpage.TopInfo
+	}{
+		{text: "Ö 3.1", want: dpage.TopInfo{Nummer: "3.1", Oeffentlich: true}},
+		{text: "N 12", want: dpage.TopInfo{Nummer: "12"}},
+		{text: "NÖ 4", want: dpage.TopInfo{Nummer: "4"}},
+		{text: "7", want: dpage.TopInfo{Nummer: "7", Oeffentlich: true}},
+		{text: "", want: dpage.TopInfo{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got := dpage.ParseTopNummer(tt.text)
+			if got != tt.want {
+				t.Errorf("top is %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,9 +5,8 @@ import (
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/pkg/errors"
-	"github.com/rismaster/allris-common/application"
 	"github.com/rismaster/allris-common/common"
-	"github.com/rismaster/allris-common/common/files"
+	"github.com/rismaster/allris-common/common/domtools"
 	"github.com/rismaster/allris-common/common/slog"
 	"github.com/rismaster/allris-common/downloader"
 	"log"
@@ -17,110 +16,204 @@ import (
 	"time"
 )
 
+// alleGremienOption is the option of the GRA select for the Sitzungen of all Gremien
+const alleGremienOption = 99999999
+
 type Sitzungsliste struct {
-	app *application.AppContext
+	app *App
 }
 
-type Gremium struct {
+// gremiumOption is a Gremium of the GRA select of the Sitzungsliste
+type gremiumOption struct {
 	option   int
-	children []downloader.RisRessource
+	name     string
+	children []*Sitzung
 }
 
-func NewSitzungsliste(app *application.AppContext) Sitzungsliste {
+func NewSitzungsliste(app *App) Sitzungsliste {
 	return Sitzungsliste{
 		app: app,
 	}
 }
 
+// Sitzungen returns the Sitzungen of the Sitzungsliste starting after minTime, parsed from the rows of the list
+func (sl *Sitzungsliste) Sitzungen(minTime time.Time, redownload bool) ([]*Sitzung, error) {
+	sitzungen, _, err := sl.fetchLongSitzungsListe(minTime, redownload)
+	return sitzungen, err
+}
+
+// Kalendereintraege returns the rows of the Sitzungsliste without Sitzung starting after minTime
+func (sl *Sitzungsliste) Kalendereintraege(minTime time.Time, redownload bool) ([]*Kalendereintrag, error) {
+	_, kalender, err := sl.fetchLongSitzungsListe(minTime, redownload)
+	return kalender, err
+}
+
+// SynchronizeSince downloads the Sitzungen and Kalendereintraege of the RIS starting after minTime and moves the
+// stored ones missing in the RIS to the tombstones. With an App of DryRun nothing is written, the changes are
+// collected in its Plan. Afterwards the iCalendar feeds of the changed Gremien are updated and the digests of
+// the changes are sent to the subscribers.
 func (sl *Sitzungsliste) SynchronizeSince(minTime time.Time, redownload bool) error {
-	sitzungenRis, err := sl.fetchLongSitzungsListe(minTime, redownload)
+	sitzungen, kalender, err := sl.fetchLongSitzungsListe(minTime, redownload)
 	if err != nil {
 		return errors.Wrap(err, "error fetching long sitzungsliste")
 	}
+
+	allKalenderFromRis, err := writeKalender(sl.app, kalender)
+	if err != nil {
+		return err
+	}
+	err = deleteFilesIfNotInAndAfter(sl.app, GetKalenderFolder(sl.app), allKalenderFromRis, []string{}, minTime, "sync sitzungsliste")
+	if err != nil {
+		return errors.Wrap(err, "error deleting kalendereintraege")
+	}
+
+	sitzungenRis := sl.toRisRessourcen(sitzungen, redownload)
 	allSitzungenFromRis := make(map[string]bool)
 	for _, sitzungRis := range sitzungenRis {
 		slog.Info("found sitzung: %s (%s)", sitzungRis.GetName(), sitzungRis.GetCreated())
 		sitzung := NewSitzung(sl.app, &sitzungRis)
 		allSitzungenFromRis[sitzung.GetPath()] = true
+		allSitzungenFromRis[sitzung.GetModelPath()] = true
 	}
 
-	err = PublishRisDownload(sl.app, sitzungenRis)
-	if err != nil {
-		return err
-	}
+	publishErr := PublishRisDownload(sl.app, sitzungenRis)
 
 	childFolders := []string{sl.app.Config.GetAnlagenFolder(), sl.app.Config.GetTopFolder()}
-	err = files.DeleteFilesIfNotInAndAfter(sl.app, sl.app.Config.GetSitzungenFolder(), allSitzungenFromRis, childFolders, minTime)
+	err = deleteFilesIfNotInAndAfter(sl.app, sl.app.Config.GetSitzungenFolder(), allSitzungenFromRis, childFolders, minTime, "sync sitzungsliste")
 	if err != nil {
 		return errors.Wrap(err, "error deleting vorlagen")
 	}
-	return nil
+
+	err = sl.updateICal()
+	if err != nil {
+		slog.Error("error updating ical feeds: %v", err)
+	}
+
+	err = updateBeratungsgraph(sl.app)
+	if err != nil {
+		slog.Error("error updating beratungsgraph: %v", err)
+	}
+
+	_, err = PurgeTombstones(sl.app)
+	if err != nil {
+		slog.Error("error purging tombstones: %v", err)
+	}
+
+	_, err = SendDigests(sl.app)
+	if err != nil {
+		slog.Error("error sending digests: %v", err)
+	}
+	return publishErr
 }
 
+// DownloadLastNPerGremium downloads the last countPerGremium Sitzungen of every Gremium and stores the
+// Kalendereintraege of their lists. The stored Kalendereintraege missing in the lists are moved to the tombstones
+// if the lists of all Gremien were loaded, their ids change with the title or the start.
 func (sl *Sitzungsliste) DownloadLastNPerGremium(countPerGremium int, redownload bool) error {
-	sitzungenRis, err := sl.downloadMax(countPerGremium, redownload)
+	sitzungen, kalender, complete, err := sl.downloadMax(countPerGremium, redownload)
 	if err != nil {
 		return errors.Wrap(err, "error downloading vorlagen %+v")
 	}
 
-	err = PublishRisDownload(sl.app, sitzungenRis)
+	allKalenderFromRis, err := writeKalender(sl.app, kalender)
 	if err != nil {
 		return err
 	}
+	if complete && len(kalender) > 0 {
+		err = deleteFilesIfNotInAndAfter(sl.app, GetKalenderFolder(sl.app), allKalenderFromRis, []string{}, firstKalenderStart(kalender), "download sitzungen per gremium")
+		if err != nil {
+			return errors.Wrap(err, "error deleting kalendereintraege")
+		}
+	}
 
-	return nil
+	publishErr := PublishRisDownload(sl.app, sl.toRisRessourcen(sitzungen, redownload))
+
+	err = sl.updateICal()
+	if err != nil {
+		slog.Error("error updating ical feeds: %v", err)
+	}
+	return publishErr
+}
+
+func (sl *Sitzungsliste) toRisRessourcen(sitzungen []*Sitzung, redownload bool) (risArr []downloader.RisRessource) {
+
+	for _, sitzung := range sitzungen {
+		ris, err := sl.newRisRessource(sitzung, redownload)
+		if err != nil {
+			slog.Error("error creating ressource of sitzung %d: %v", sitzung.SILFDNR, err)
+			continue
+		}
+		risArr = append(risArr, *ris)
+	}
+	return risArr
 }
 
-func (sl *Sitzungsliste) downloadMax(countPerGremium int, redownload bool) (sitzungen []downloader.RisRessource, err error) {
+func (sl *Sitzungsliste) newRisRessource(sitzung *Sitzung, redownload bool) (*downloader.RisRessource, error) {
+
+	uri, err := url.Parse(sl.app.Config.GetTargetToParse() + fmt.Sprintf(sl.app.Config.GetUrlSitzungTmpl(), sitzung.SILFDNR))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse url")
+	}
+
+	sName := fmt.Sprintf("%s-%d", sl.app.Config.GetSitzungType(), sitzung.SILFDNR)
+
+	return downloader.NewRisRessource(sl.app.Config.GetSitzungenFolder(), sName, ".html", sitzung.Start, uri, &url.Values{}, redownload, redownload), nil
+}
+
+// downloadMax returns the last countPerGremium Sitzungen and all Kalendereintraege of the Gremien, complete is
+// false if the list of a Gremium could not be loaded
+func (sl *Sitzungsliste) downloadMax(countPerGremium int, redownload bool) (sitzungen []*Sitzung, kalender []*Kalendereintrag, complete bool, err error) {
 
 	gremien, err := sl.fetchGremiumOptions(redownload)
 	if err != nil {
-		return nil, err
+		return nil, nil, false, err
 	}
 
+	complete = true
 	for _, gremium := range gremien {
 		slog.Info("Gremium %d", gremium.option)
 		errSizungsliste := sl.fetchSitzungsListe(gremium, redownload)
 		if errSizungsliste != nil {
 			slog.Error("error loading sitzungsliste for gremium %d, Reason: %v", gremium.option, errSizungsliste)
+			complete = false
 		}
 		j := 0
 		for _, s := range gremium.children {
-			if j >= countPerGremium {
-				break
-			}
-			if s.GetUrl() != "" {
+			s.GremiumID = gremium.option
+			if s.SILFDNR <= 0 {
+				kalender = append(kalender, newKalendereintrag(s))
+			} else if j < countPerGremium {
 				sitzungen = append(sitzungen, s)
 				j++
 			}
 		}
 	}
 
-	return sitzungen, nil
+	return sitzungen, kalender, complete, nil
 }
 
-func (sl *Sitzungsliste) fetchLongSitzungsListe(minTime time.Time, redownload bool) (sitzungen []downloader.RisRessource, err error) {
+func (sl *Sitzungsliste) fetchLongSitzungsListe(minTime time.Time, redownload bool) (sitzungen []*Sitzung, kalender []*Kalendereintrag, err error) {
 
 	formData := url.Values{}
-	formData.Add("GRA", "99999999")
+	formData.Add("GRA", strconv.Itoa(alleGremienOption))
 	formData.Add("filtGRA", "filter")
 
 	uri, err := url.Parse(sl.app.Config.GetTargetToParse() + sl.app.Config.GetUrlSitzungsLangeliste())
 	if err != nil {
-		return nil, errors.Wrap(err, "cannot parse url")
+		return nil, nil, errors.Wrap(err, "cannot parse url")
 	}
 
 	srcWeb := downloader.NewRisRessource("", sl.app.Config.GetAlleSitzungenType(), ".html", time.Now(), uri, &formData, true, redownload)
-	targetStore := files.NewFile(sl.app, srcWeb)
+	targetStore := NewFile(sl.app, srcWeb)
 
-	err = targetStore.Fetch(files.HttpPost, srcWeb, "text/html")
+	err = targetStore.Fetch(httpPost, srcWeb, "text/html")
 	if err != nil {
-		return nil, errors.Wrap(err, fmt.Sprintf("error downloading allesitzungen from %s", sl.app.Config.GetUrlSitzungsLangeliste()))
+		return nil, nil, errors.Wrap(err, fmt.Sprintf("error downloading allesitzungen from %s", sl.app.Config.GetUrlSitzungsLangeliste()))
 	}
 
 	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(targetStore.GetContent()))
 	if err != nil {
-		return nil, errors.Wrap(err, fmt.Sprintf("error create dom from %s", targetStore.GetName()))
+		return nil, nil, errors.Wrap(err, fmt.Sprintf("error create dom from %s", targetStore.GetName()))
 	}
 
 	selector := "tr.zl11,tr.zl12"
@@ -128,28 +221,60 @@ func (sl *Sitzungsliste) fetchLongSitzungsListe(minTime time.Time, redownload bo
 
 		if selection.Children().Size() >= 8 {
 
-			sitzung, err := sl.parseElement(selection, srcWeb)
+			sitzung, err := sl.parseElement(selection)
 			if err != nil {
 				log.Printf("error Parse sitzung element %v", err)
 			}
-			if sitzung.GetUrl() != "" && sitzung.GetCreated().After(minTime) {
-				sitzungen = append(sitzungen, *sitzung)
+			if sitzung == nil || !sitzung.Start.After(minTime) {
+				return
+			}
+			if sitzung.SILFDNR > 0 {
+				sitzungen = append(sitzungen, sitzung)
+			} else {
+				kalender = append(kalender, newKalendereintrag(sitzung))
 			}
 		}
 	})
 	if len(sitzungen) == 0 {
-		return nil, errors.New("keine Sitzungen (allesitzungen.html)")
+		return nil, nil, errors.New("keine Sitzungen (allesitzungen.html)")
+	}
+
+	err = sl.setKalenderGremien(kalender, redownload)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	newHash := common.Md5HashB(targetStore.GetContent())
 	err = targetStore.WriteIfMoreActualAndDifferent(newHash)
 	if err != nil {
-		return nil, errors.Wrap(err, fmt.Sprintf("error writing allesitzungen %s", srcWeb.GetName()))
+		return nil, nil, errors.Wrap(err, fmt.Sprintf("error writing allesitzungen %s", srcWeb.GetName()))
 	}
-	return sitzungen, nil
+	return sitzungen, kalender, nil
 }
 
-func (sl *Sitzungsliste) fetchSitzungsListe(gremium *Gremium, redownload bool) (err error) {
+// setKalenderGremien sets the GremiumID of the Kalendereintraege of the long list by the name of their Gremium
+// in the GRA select, so they are stored like the Kalendereintraege of the lists per Gremium
+func (sl *Sitzungsliste) setKalenderGremien(kalender []*Kalendereintrag, redownload bool) error {
+
+	if len(kalender) == 0 {
+		return nil
+	}
+	gremien, err := sl.fetchGremiumOptions(redownload)
+	if err != nil {
+		return errors.Wrap(err, "error fetching gremien of kalendereintraege")
+	}
+
+	for _, eintrag := range kalender {
+		for _, gremium := range gremien {
+			if eintrag.GremiumID == 0 && strings.EqualFold(gremium.name, eintrag.Gremium) {
+				eintrag.GremiumID = gremium.option
+			}
+		}
+	}
+	return nil
+}
+
+func (sl *Sitzungsliste) fetchSitzungsListe(gremium *gremiumOption, redownload bool) (err error) {
 	graStr := strconv.Itoa(gremium.option)
 
 	formData := url.Values{}
@@ -162,9 +287,9 @@ func (sl *Sitzungsliste) fetchSitzungsListe(gremium *Gremium, redownload bool) (
 	}
 
 	srcWeb := downloader.NewRisRessource("", fmt.Sprintf("%s-%d", sl.app.Config.GetGremienListeType(), gremium.option), ".html", time.Now(), uri, &formData, true, redownload)
-	targetStore := files.NewFile(sl.app, srcWeb)
+	targetStore := NewFile(sl.app, srcWeb)
 
-	err = targetStore.Fetch(files.HttpPost, srcWeb, "text/html")
+	err = targetStore.Fetch(httpPost, srcWeb, "text/html")
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("error downloading Sitzungsliste from %s", sl.app.Config.GetUrlSitzungsliste()))
 	}
@@ -179,12 +304,12 @@ func (sl *Sitzungsliste) fetchSitzungsListe(gremium *Gremium, redownload bool) (
 
 		if selection.Children().Size() >= 8 {
 
-			sitzung, err := sl.parseElement(selection, srcWeb)
+			sitzung, err := sl.parseElement(selection)
 			if err != nil {
 				log.Printf("error Parse sitzung element %v", err)
 			}
 			if sitzung != nil {
-				gremium.children = append(gremium.children, *sitzung)
+				gremium.children = append(gremium.children, sitzung)
 			}
 		}
 	})
@@ -200,7 +325,8 @@ func (sl *Sitzungsliste) fetchSitzungsListe(gremium *Gremium, redownload bool) (
 	return nil
 }
 
-func (sl *Sitzungsliste) parseElement(e *goquery.Selection, slRisResource *downloader.RisRessource) (*downloader.RisRessource, error) {
+// parseElement parses a row of the Sitzungsliste, rows without SILFDNR are Kalender-Eintraege
+func (sl *Sitzungsliste) parseElement(e *goquery.Selection) (*Sitzung, error) {
 
 	lnkTr := e.Find(":nth-child(2) a")
 	lnk, _ := lnkTr.Attr("href")
@@ -212,15 +338,37 @@ func (sl *Sitzungsliste) parseElement(e *goquery.Selection, slRisResource *downl
 	silfdnr := lnkUrlAttr.Query().Get("SILFDNR")
 	name := strings.TrimSpace(lnkTr.First().Text())
 	dateText := strings.TrimSpace(e.Find(":nth-child(6) a").Text())
-	timeTr := strings.Split(strings.TrimSpace(e.Find(":nth-child(7)").Text()), " - ")[0]
-	dateTimetxt := fmt.Sprintf("%s %s:00", dateText, timeTr)
+	times := strings.Split(strings.TrimSpace(e.Find(":nth-child(7)").Text()), " - ")
+	if times[0] == "" {
+		// Kalender-Eintraege can be without time
+		times[0] = "00:00"
+	}
+	dateTimetxt := fmt.Sprintf("%s %s:00", dateText, times[0])
 
-	localTz, _ := time.LoadLocation(sl.app.Config.GetTimezone())
+	dates, err := sl.app.Dates()
+	if err != nil {
+		return nil, err
+	}
+	localTz := dates.Location()
 	risTime, err := time.ParseInLocation(sl.app.Config.GetDateFormatWithTime(), dateTimetxt, localTz)
 	if err != nil {
 		return nil, err
 	}
 
+	columns := e.Children()
+	sitzung := &Sitzung{
+		Bezeichnung: name,
+		Gremium:     domtools.CleanText(columns.Eq(2).Text()),
+		Raum:        domtools.CleanText(columns.Eq(7).Text()),
+		Start:       risTime,
+	}
+	if len(times) > 1 {
+		ende, errEnde := time.ParseInLocation(sl.app.Config.GetDateFormatWithTime(), fmt.Sprintf("%s %s:00", dateText, strings.TrimSpace(times[1])), localTz)
+		if errEnde == nil {
+			sitzung.Ende = ende
+		}
+	}
+
 	if silfdnr != "" {
 		slog.Info("Sitzung erzeugt: %s - %s / %s", lnk, name, dateText)
 
@@ -229,22 +377,14 @@ func (sl *Sitzungsliste) parseElement(e *goquery.Selection, slRisResource *downl
 			return nil, errors.Wrap(err1, "cannot create int from silfdnr")
 		}
 
-		uri, err2 := url.Parse(sl.app.Config.GetTargetToParse() + fmt.Sprintf(sl.app.Config.GetUrlSitzungTmpl(), silfdnrInt))
-		if err2 != nil {
-			return nil, errors.Wrap(err2, "cannot parse url")
-		}
-
-		sName := fmt.Sprintf("%s-%d", sl.app.Config.GetSitzungType(), silfdnrInt)
-
-		return downloader.NewRisRessource(sl.app.Config.GetSitzungenFolder(), sName, ".html", risTime, uri, &url.Values{}, slRisResource.RedownloadChildren, slRisResource.RedownloadChildren), nil
-		//return NewSitzung(sl.app, res), nil
+		sitzung.SILFDNR = silfdnrInt
+		return sitzung, nil
 	} else if dateText != "" {
 		sName2 := e.Find(":nth-child(2)").Text()
 		slog.Info("Kalender-Eintrag: :%s %s", dateTimetxt, sName2)
 
-		return downloader.NewRisRessource("", sName2, "", risTime, nil, &url.Values{}, slRisResource.RedownloadChildren, slRisResource.RedownloadChildren), nil
-
-		//return NewSitzung(sl.app, res), nil
+		sitzung.Bezeichnung = sName2
+		return sitzung, nil
 	} else {
 		slog.Debug("Empty: %s", name)
 	}
@@ -253,7 +393,7 @@ func (sl *Sitzungsliste) parseElement(e *goquery.Selection, slRisResource *downl
 
 }
 
-func (sl *Sitzungsliste) fetchGremiumOptions(redownload bool) (gremien []*Gremium, err error) {
+func (sl *Sitzungsliste) fetchGremiumOptions(redownload bool) (gremien []*gremiumOption, err error) {
 
 	uri, err := url.Parse(sl.app.Config.GetTargetToParse() + sl.app.Config.GetUrlSitzungsliste())
 	if err != nil {
@@ -262,9 +402,9 @@ func (sl *Sitzungsliste) fetchGremiumOptions(redownload bool) (gremien []*Gremiu
 
 	srcWeb := downloader.NewRisRessource("", sl.app.Config.GetGremienOptionsType(), ".html", time.Now(), uri, &url.Values{}, true, redownload)
 
-	targetStore := files.NewFile(sl.app, srcWeb)
+	targetStore := NewFile(sl.app, srcWeb)
 
-	err = targetStore.Fetch(files.HttpGet, srcWeb, "text/html")
+	err = targetStore.Fetch(httpGet, srcWeb, "text/html")
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("error downloading Gremienliste from %s", sl.app.Config.GetUrlSitzungsliste()))
 	}
@@ -274,15 +414,15 @@ func (sl *Sitzungsliste) fetchGremiumOptions(redownload bool) (gremien []*Gremiu
 		return nil, errors.Wrap(err, fmt.Sprintf("error create dom from %s", targetStore.GetName()))
 	}
 
-	var options []*Gremium
+	var options []*gremiumOption
 	doc.Find("select[name=\"GRA\"] option").Each(func(i int, s *goquery.Selection) {
 		optStr, ok := s.Attr("value")
 		if ok {
 			opt, intErr := strconv.Atoi(optStr)
 			if intErr != nil {
 				slog.Warn("error parsing opt value ignored: %s reason: %v", optStr, intErr)
-			} else if opt < 1000 {
-				gremium := &Gremium{option: opt}
+			} else if opt != alleGremienOption {
+				gremium := &gremiumOption{option: opt, name: domtools.CleanText(s.Text())}
 				options = append(options, gremium)
 			}
 		}
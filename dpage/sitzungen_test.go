@@ -0,0 +1,132 @@
+package dpage_test
+
+import (
+	"encoding/json"
+	"github.com/rismaster/allris-dpage/dpage"
+	"testing"
+	"time"
+)
+
+func TestSitzungslisteSynchronizeSince(t *testing.T) {
+
+	env := newEnv(t)
+	sl := dpage.NewSitzungsliste(env.App)
+	err := sl.SynchronizeSince(time.Time{}, false)
+	if err != nil {
+		t.Fatalf("error synchronizing: %v", err)
+	}
+
+	assertNames(t, env, []string{
+		"allesitzungen.html",
+		"anlagen/sitzung-1001-anlagedoc-55601-1.pdf",
+		"anlagen/sitzung-1001-anlagedoc-55601-1.text.json",
+		"anlagen/sitzung-1001-top-20002-anlage-245-kb-lageplan.pdf",
+		"anlagen/sitzung-1001-top-20002-anlage-245-kb-lageplan.text.json",
+		"beratungsfolgen.json",
+		"gremienoptions.html",
+		"ical/alle.ics",
+		"ical/gremium-1.ics",
+		"ical/gremium-1001.ics",
+		"ical/gremium-2.ics",
+		"kalender/kalender-202104191700-ed53f4c4.json",
+		"kalender/kalender-202105031000-de461bc2.json",
+		"sitzungen/sitzung-1001.html",
+		"sitzungen/sitzung-1001.json",
+		"sitzungen/sitzung-1002.html",
+		"sitzungen/sitzung-1002.json",
+		"tops/sitzung-1001-top-20001.html",
+		"tops/sitzung-1001-top-20001.json",
+		"tops/sitzung-1001-top-20002.html",
+		"tops/sitzung-1001-top-20002.json",
+		"tops/sitzung-1001-top-20003.html",
+		"tops/sitzung-1001-top-20003.json",
+		"tops/sitzung-1002-top-20010.html",
+		"tops/sitzung-1002-top-20010.json",
+		"tops/sitzung-1002-top-20011.html",
+		"tops/sitzung-1002-top-20011.json",
+	})
+
+	tests := []struct {
+		path    string
+		silfdnr int
+		gremium string
+		start   string
+		tops    int
+	}{
+		{"sitzungen/sitzung-1001.json", 1001, "Bau- und Umweltausschuss", "12.04.2021 18:00", 3},
+		{"sitzungen/sitzung-1002.json", 1002, "Rat der Stadt", "29.04.2021 17:00", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			content, ok := env.Storage.Content(env.Config.BucketFetched, tt.path)
+			if !ok {
+				t.Fatalf("no model %s", tt.path)
+			}
+			var sitzung dpage.Sitzung
+			err := json.Unmarshal(content, &sitzung)
+			if err != nil {
+				t.Fatalf("error unmarshalling model: %v", err)
+			}
+			if sitzung.SILFDNR != tt.silfdnr || sitzung.Gremium != tt.gremium || len(sitzung.Tops) != tt.tops {
+				t.Errorf("model is %d %s with %d tops", sitzung.SILFDNR, sitzung.Gremium, len(sitzung.Tops))
+			}
+			assertTime(t, "start", sitzung.Start, berlin(t, tt.start))
+		})
+	}
+
+	content, _ := env.Storage.Content(env.Config.BucketFetched, "tops/sitzung-1001-top-20002.json")
+	var top dpage.Top
+	err = json.Unmarshal(content, &top)
+	if err != nil {
+		t.Fatalf("error unmarshalling top: %v", err)
+	}
+	if top.VOLFDNR != 4711 || top.Beschlussart != "ungeändert beschlossen" {
+		t.Errorf("top is %+v", top)
+	}
+}
+
+func TestKalenderGremiumInBothModes(t *testing.T) {
+
+	env := newEnv(t)
+	sl := dpage.NewSitzungsliste(env.App)
+	err := sl.SynchronizeSince(time.Time{}, false)
+	if err != nil {
+		t.Fatalf("error synchronizing: %v", err)
+	}
+
+	tests := []struct {
+		path      string
+		gremiumID int
+	}{
+		{"kalender/kalender-202104191700-ed53f4c4.json", 1},
+		{"kalender/kalender-202105031000-de461bc2.json", 1001},
+	}
+	synchronized := make(map[string]string)
+	for _, tt := range tests {
+		content, ok := env.Storage.Content(env.Config.BucketFetched, tt.path)
+		if !ok {
+			t.Fatalf("no kalendereintrag %s", tt.path)
+		}
+		var eintrag dpage.Kalendereintrag
+		err = json.Unmarshal(content, &eintrag)
+		if err != nil {
+			t.Fatalf("error unmarshalling %s: %v", tt.path, err)
+		}
+		if eintrag.GremiumID != tt.gremiumID {
+			t.Errorf("gremium of %s is %d, want %d", tt.path, eintrag.GremiumID, tt.gremiumID)
+		}
+		synchronized[tt.path] = string(content)
+	}
+
+	// the lists per Gremium must not rewrite the Kalendereintraege of the long list
+	err = sl.DownloadLastNPerGremium(10, false)
+	if err != nil {
+		t.Fatalf("error downloading per gremium: %v", err)
+	}
+	for path, want := range synchronized {
+		content, _ := env.Storage.Content(env.Config.BucketFetched, path)
+		if string(content) != want {
+			t.Errorf("%s is rewritten as %s, was %s", path, content, want)
+		}
+	}
+}
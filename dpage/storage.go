@@ -0,0 +1,92 @@
+package dpage
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common"
+	"github.com/rismaster/allris-common/common/slog"
+	"strings"
+	"time"
+)
+
+const jsonEnding = ".json"
+const htmlEnding = ".html"
+
+// writeJson stores v as json in the fetched store, e.g. the parsed model next to the html of a document
+func writeJson(app *App, path string, risTime time.Time, v interface{}) error {
+
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error marshalling %s", path))
+	}
+	return writeObject(app, path, "application/json", risTime, content)
+}
+
+// writeObject writes the content to the fetched store if the object not exists or has another hash
+func writeObject(app *App, path string, contentType string, risTime time.Time, content []byte) error {
+
+	hash := common.Md5HashB(content)
+
+	changedBy := "Create"
+	attrs, err := app.Fetched.Attrs(path)
+	if err == nil {
+		if attrs.Hash == hash {
+			slog.Debug("Same Hash for File %s: %s", path, hash)
+			return nil
+		}
+		changedBy = "Update"
+	} else if err != ErrObjectNotExist {
+		return errors.Wrap(err, fmt.Sprintf("error reading attrs of %s", path))
+	}
+
+	slog.Info("%s File: %s", changedBy, path)
+
+	err = app.Fetched.Write(path, content, &ObjectAttrs{
+		ContentType: contentType,
+		Hash:        hash,
+		RisTime:     risTime,
+		FetchedAt:   time.Now(),
+		Metadata:    map[string]string{metadataChangedBy: changedBy},
+	})
+	if err != nil {
+		return err
+	}
+
+	eventType := EventCreated
+	if changedBy == "Update" {
+		eventType = EventUpdated
+	}
+	app.emit(newChangeEvent(app, eventType, path, hash))
+	return nil
+}
+
+// listJsons returns the attributes of all json objects in the fetched store starting with prefix
+func listJsons(app *App, prefix string) (result []*ObjectAttrs, err error) {
+
+	objects, err := app.Fetched.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, attrs := range objects {
+		if strings.HasSuffix(attrs.Name, jsonEnding) {
+			result = append(result, attrs)
+		}
+	}
+	return result, nil
+}
+
+// readJson unmarshals the json object at path into v
+func readJson(app *App, path string, v interface{}) error {
+
+	content, err := app.Fetched.Read(path)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error reading %s", path))
+	}
+
+	err = json.Unmarshal(content, v)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error unmarshalling %s", path))
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package dpage
+
+import (
+	"github.com/pkg/errors"
+	"time"
+)
+
+var ErrObjectNotExist = errors.New("object does not exist")
+
+// ObjectAttrs are the attributes of a stored object
+type ObjectAttrs struct {
+	Name        string
+	ContentType string
+	// Hash is the hash of the content as calculated by the writer, e.g. without links for html pages
+	Hash string
+	// RisTime is the time the ressource was created in the RIS
+	RisTime   time.Time
+	FetchedAt time.Time
+	Updated   time.Time
+	// Metadata are additional attributes like ChangedBy
+	Metadata map[string]string
+}
+
+// Store is a bucket of stored objects, e.g. the fetched documents or their backups. Reading a missing
+// object returns ErrObjectNotExist.
+type Store interface {
+	Attrs(path string) (*ObjectAttrs, error)
+	Read(path string) ([]byte, error)
+	// Write creates or replaces the object, the Name and Updated of attrs are ignored
+	Write(path string, content []byte, attrs *ObjectAttrs) error
+	// Touch sets Updated of the object to now
+	Touch(path string) error
+	Delete(path string) error
+	// List returns the attributes of all objects starting with prefix sorted by name
+	List(prefix string) ([]*ObjectAttrs, error)
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	result := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		result[k] = v
+	}
+	return result
+}
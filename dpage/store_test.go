@@ -0,0 +1,197 @@
+package dpage_test
+
+import (
+	"context"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/rismaster/allris-dpage/dpage"
+	"github.com/rismaster/allris-dpage/dpage/dpagetest"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// storeConfig is a dpagetest.Config with the StoreConfig of dpage
+type storeConfig struct {
+	*dpagetest.Config
+	storeType  string
+	root       string
+	s3Endpoint string
+}
+
+func (c *storeConfig) GetStoreType() string   { return c.storeType }
+func (c *storeConfig) GetStoreRoot() string   { return c.root }
+func (c *storeConfig) GetS3Endpoint() string  { return c.s3Endpoint }
+func (c *storeConfig) GetS3AccessKey() string { return "access" }
+func (c *storeConfig) GetS3SecretKey() string { return "secret" }
+func (c *storeConfig) GetS3UseSSL() bool      { return false }
+
+func writeObject(t *testing.T, store dpage.Store, path string, content string, risTime time.Time) {
+	t.Helper()
+	err := store.Write(path, []byte(content), &dpage.ObjectAttrs{
+		ContentType: "text/html",
+		Hash:        "hash of " + content,
+		RisTime:     risTime,
+		FetchedAt:   risTime.Add(time.Hour),
+		Metadata:    map[string]string{"Deleted-By": "test"},
+	})
+	if err != nil {
+		t.Fatalf("error writing %s: %v", path, err)
+	}
+}
+
+func listNames(t *testing.T, store dpage.Store, prefix string) (names []string) {
+	t.Helper()
+	objects, err := store.List(prefix)
+	if err != nil {
+		t.Fatalf("error listing %s: %v", prefix, err)
+	}
+	for _, attrs := range objects {
+		names = append(names, attrs.Name)
+	}
+	return names
+}
+
+// testStore checks the contract of the Store interface. The metadata keys are canonical http header keys,
+// S3 returns them canonicalized.
+func testStore(t *testing.T, store dpage.Store) {
+
+	path := "vorlagen/vorlage-4711.html"
+	if _, err := store.Attrs(path); err != dpage.ErrObjectNotExist {
+		t.Errorf("attrs of missing object: %v", err)
+	}
+	if _, err := store.Read(path); err != dpage.ErrObjectNotExist {
+		t.Errorf("read of missing object: %v", err)
+	}
+	if err := store.Delete(path); err != dpage.ErrObjectNotExist {
+		t.Errorf("delete of missing object: %v", err)
+	}
+
+	risTime := berlin(t, "12.04.2021 18:00")
+	writeObject(t, store, path, "<html>4711</html>", risTime)
+	writeObject(t, store, "vorlagen/vorlage-4710.html", "<html>4710</html>", risTime)
+	writeObject(t, store, "sitzungen/sitzung-1001.html", "<html>1001</html>", risTime)
+
+	attrs, err := store.Attrs(path)
+	if err != nil {
+		t.Fatalf("error reading attrs: %v", err)
+	}
+	if attrs.Name != path || attrs.ContentType != "text/html" || attrs.Hash != "hash of <html>4711</html>" || attrs.Metadata["Deleted-By"] != "test" {
+		t.Errorf("attrs are %+v", attrs)
+	}
+	assertTime(t, "ris time", attrs.RisTime, risTime)
+	assertTime(t, "fetched at", attrs.FetchedAt, risTime.Add(time.Hour))
+	if attrs.Updated.IsZero() {
+		t.Errorf("updated is zero")
+	}
+
+	content, err := store.Read(path)
+	if err != nil || string(content) != "<html>4711</html>" {
+		t.Errorf("content is %s: %v", content, err)
+	}
+
+	err = store.Touch(path)
+	if err != nil {
+		t.Fatalf("error touching: %v", err)
+	}
+	touched, err := store.Attrs(path)
+	if err != nil {
+		t.Fatalf("error reading touched attrs: %v", err)
+	}
+	if touched.Updated.Before(attrs.Updated) || touched.ContentType != attrs.ContentType || touched.Hash != attrs.Hash || touched.Metadata["Deleted-By"] != "test" {
+		t.Errorf("touched attrs are %+v, were %+v", touched, attrs)
+	}
+	assertTime(t, "touched ris time", touched.RisTime, risTime)
+
+	assertStrings(t, "vorlagen", listNames(t, store, "vorlagen/"), []string{"vorlagen/vorlage-4710.html", "vorlagen/vorlage-4711.html"})
+	assertStrings(t, "all objects", listNames(t, store, ""), []string{"sitzungen/sitzung-1001.html", "vorlagen/vorlage-4710.html", "vorlagen/vorlage-4711.html"})
+	assertStrings(t, "objects of missing folder", listNames(t, store, "tops/"), nil)
+
+	writeObject(t, store, path, "<html>4711 geändert</html>", risTime)
+	content, err = store.Read(path)
+	if err != nil || string(content) != "<html>4711 geändert</html>" {
+		t.Errorf("replaced content is %s: %v", content, err)
+	}
+
+	err = store.Delete(path)
+	if err != nil {
+		t.Fatalf("error deleting: %v", err)
+	}
+	if _, err = store.Attrs(path); err != dpage.ErrObjectNotExist {
+		t.Errorf("attrs of deleted object: %v", err)
+	}
+	assertStrings(t, "vorlagen after delete", listNames(t, store, "vorlagen/"), []string{"vorlagen/vorlage-4710.html"})
+}
+
+func TestLocalStore(t *testing.T) {
+	testStore(t, dpage.NewLocalStore(t.TempDir()))
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, dpage.NewMemoryStore())
+}
+
+func TestS3Store(t *testing.T) {
+
+	server := dpagetest.NewS3Server()
+	defer server.Close()
+
+	client, err := minio.New(server.Endpoint(), &minio.Options{Creds: credentials.NewStaticV4("access", "secret", "")})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	testStore(t, dpage.NewS3Store(context.Background(), client, "fetched"))
+}
+
+func TestNewAppWithConfigStores(t *testing.T) {
+
+	s3 := dpagetest.NewS3Server()
+	defer s3.Close()
+
+	root := t.TempDir()
+	tests := []struct {
+		storeType string
+		stored    func() bool
+	}{
+		{"local", func() bool {
+			content, err := ioutil.ReadFile(filepath.Join(root, "fetched", "vorlagen", "vorlage-4711.html"))
+			return err == nil && string(content) == "<html>4711</html>"
+		}},
+		{"memory", func() bool {
+			// the memory stores of a bucket are shared by the Apps of the process
+			app, err := dpage.NewAppWithConfig(context.Background(), &storeConfig{Config: dpagetest.NewConfig(""), storeType: "memory"})
+			if err != nil {
+				return false
+			}
+			defer app.Close()
+			_, err = app.Fetched.Attrs("vorlagen/vorlage-4711.html")
+			return err == nil
+		}},
+		{"s3", func() bool {
+			names := s3.Names("fetched")
+			return len(names) == 1 && names[0] == "vorlagen/vorlage-4711.html"
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.storeType, func(t *testing.T) {
+			conf := &storeConfig{Config: dpagetest.NewConfig(""), storeType: tt.storeType, root: root, s3Endpoint: s3.Endpoint()}
+			app, err := dpage.NewAppWithConfig(context.Background(), conf)
+			if err != nil {
+				t.Fatalf("error creating app: %v", err)
+			}
+			defer app.Close()
+
+			writeObject(t, app.Fetched, "vorlagen/vorlage-4711.html", "<html>4711</html>", time.Now())
+			if !tt.stored() {
+				t.Errorf("object is not in the %s store", tt.storeType)
+			}
+		})
+	}
+
+	_, err := dpage.NewAppWithConfig(context.Background(), &storeConfig{Config: dpagetest.NewConfig(""), storeType: "ftp"})
+	if err == nil {
+		t.Errorf("no error for unknown store type")
+	}
+}
@@ -0,0 +1,232 @@
+package dpage
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultTombstoneFolder = "tombstones/"
+const defaultTombstoneRetention = 30 * 24 * time.Hour
+
+// the name of a tombstone is the path of the deleted file with the time of the deletion, so a file deleted again
+// after a restore or a new download keeps both tombstones
+const tombstoneTimeSeparator = "~"
+const tombstoneTimeFormat = "20060102T150405.000000000Z"
+
+// the metadata keys of a tombstone, canonicalized like http headers to survive S3
+const metadataDeletedAt = "Deleted-At"
+const metadataDeletedBy = "Deleted-By"
+
+// TombstoneConfig can be implemented by the Config to set the folder of the tombstones in the backup store and
+// how long they are kept
+type TombstoneConfig interface {
+	GetTombstoneFolder() string
+	GetTombstoneRetention() time.Duration
+}
+
+// Tombstone is a file deleted from the fetched store, kept with its content in the backup store until the
+// retention is over
+type Tombstone struct {
+	// Path is the path of the deleted file in the fetched store
+	Path      string
+	DeletedAt time.Time
+	// Reason is the sync or parent document which deleted the file
+	Reason string
+	Attrs  *ObjectAttrs
+}
+
+func tombstoneFolder(app *App) string {
+	if tc, ok := app.Config.(TombstoneConfig); ok && tc.GetTombstoneFolder() != "" {
+		return tc.GetTombstoneFolder()
+	}
+	return defaultTombstoneFolder
+}
+
+func tombstoneRetention(app *App) time.Duration {
+	if tc, ok := app.Config.(TombstoneConfig); ok && tc.GetTombstoneRetention() > 0 {
+		return tc.GetTombstoneRetention()
+	}
+	return defaultTombstoneRetention
+}
+
+// tombstoneName is the name of the tombstone of the file at path in the backup store
+func tombstoneName(app *App, path string, deletedAt time.Time) string {
+	return tombstoneFolder(app) + path + tombstoneTimeSeparator + deletedAt.UTC().Format(tombstoneTimeFormat)
+}
+
+func newTombstone(app *App, attrs *ObjectAttrs) *Tombstone {
+	deletedAt, _ := time.Parse(time.RFC3339, attrs.Metadata[metadataDeletedAt])
+	path := strings.TrimPrefix(attrs.Name, tombstoneFolder(app))
+	// tombstones written by older versions of dpage are named like the deleted file
+	if i := strings.LastIndex(path, tombstoneTimeSeparator); i >= 0 {
+		if t, err := time.Parse(tombstoneTimeFormat, path[i+len(tombstoneTimeSeparator):]); err == nil {
+			path = path[:i]
+			deletedAt = t
+		}
+	}
+	return &Tombstone{
+		Path:      path,
+		DeletedAt: deletedAt,
+		Reason:    attrs.Metadata[metadataDeletedBy],
+		Attrs:     attrs,
+	}
+}
+
+// tombstone moves the stored file at path to the tombstones
+func tombstone(app *App, path string, reason string) error {
+
+	attrs, err := app.Fetched.Attrs(path)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error reading attrs of %s", path))
+	}
+	content, err := app.Fetched.Read(path)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error reading file %s", path))
+	}
+
+	deletedAt := time.Now()
+	attrs.Metadata[metadataDeletedAt] = deletedAt.Format(time.RFC3339)
+	attrs.Metadata[metadataDeletedBy] = reason
+	err = app.Backup.Write(tombstoneName(app, path, deletedAt), content, attrs)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error writing tombstone of %s", path))
+	}
+
+	err = app.Fetched.Delete(path)
+	if err != nil && err != ErrObjectNotExist {
+		return errors.Wrap(err, fmt.Sprintf("error deleting file %s", path))
+	}
+
+	event := newChangeEvent(app, EventDeleted, path, attrs.Hash)
+	if event != nil {
+		event.Reason = reason
+	}
+	app.emit(event)
+	app.Catalog.remove(path)
+	return nil
+}
+
+// ListTombstones returns the tombstones of the deleted files whose path starts with prefix, the tombstones of a
+// path ordered by the time of deletion
+func ListTombstones(app *App, prefix string) ([]*Tombstone, error) {
+
+	objects, err := app.Backup.List(tombstoneFolder(app) + prefix)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error listing tombstones of %s", prefix))
+	}
+
+	result := make([]*Tombstone, 0, len(objects))
+	for _, attrs := range objects {
+		result = append(result, newTombstone(app, attrs))
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Path != result[j].Path {
+			return result[i].Path < result[j].Path
+		}
+		return result[i].DeletedAt.Before(result[j].DeletedAt)
+	})
+	return result, nil
+}
+
+// latestTombstone returns the tombstone of the last deletion of the file at path
+func latestTombstone(app *App, path string) (*Tombstone, error) {
+
+	tombstones, err := ListTombstones(app, path)
+	if err != nil {
+		return nil, err
+	}
+	var latest *Tombstone
+	for _, t := range tombstones {
+		if t.Path == path {
+			latest = t
+		}
+	}
+	if latest == nil {
+		return nil, ErrObjectNotExist
+	}
+	return latest, nil
+}
+
+// RestoreTombstone writes the last deleted version of the file at path back to the fetched store and removes
+// its tombstone, older tombstones of the path are kept until purged. A file which exists again in the fetched
+// store is not overwritten. The restored file is emitted as created with the reason restore, so it is indexed
+// and catalogued again.
+func RestoreTombstone(app *App, path string) error {
+
+	latest, err := latestTombstone(app, path)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error reading tombstone of %s", path))
+	}
+	name := latest.Attrs.Name
+	attrs, err := app.Backup.Attrs(name)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error reading tombstone of %s", path))
+	}
+
+	_, err = app.Fetched.Attrs(path)
+	if err == nil {
+		return errors.New(fmt.Sprintf("error restoring %s: file exists", path))
+	}
+	if err != ErrObjectNotExist {
+		return errors.Wrap(err, fmt.Sprintf("error reading attrs of %s", path))
+	}
+
+	content, err := app.Backup.Read(name)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error reading tombstone of %s", path))
+	}
+
+	delete(attrs.Metadata, metadataDeletedAt)
+	delete(attrs.Metadata, metadataDeletedBy)
+	attrs.Metadata[metadataChangedBy] = "Restore"
+	err = app.Fetched.Write(path, content, attrs)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error restoring %s", path))
+	}
+
+	slog.Info("restored %s", path)
+	event := newChangeEvent(app, EventCreated, path, attrs.Hash)
+	if event != nil {
+		event.Reason = "restore"
+	}
+	app.emit(event)
+	restored := newFileFromAttrs(app, attrs)
+	restored.content = content
+	app.Catalog.record(app, restored, true)
+
+	err = app.Backup.Delete(name)
+	if err != nil && err != ErrObjectNotExist {
+		return errors.Wrap(err, fmt.Sprintf("error deleting tombstone of %s", path))
+	}
+	return nil
+}
+
+// PurgeTombstones deletes the tombstones older than the retention and returns their number
+func PurgeTombstones(app *App) (int, error) {
+
+	tombstones, err := ListTombstones(app, "")
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	minTime := time.Now().Add(-tombstoneRetention(app))
+	for _, t := range tombstones {
+		if t.DeletedAt.IsZero() || t.DeletedAt.After(minTime) {
+			continue
+		}
+		err = app.Backup.Delete(t.Attrs.Name)
+		if err != nil && err != ErrObjectNotExist {
+			return purged, errors.Wrap(err, fmt.Sprintf("error purging tombstone of %s", t.Path))
+		}
+		purged++
+	}
+	if purged > 0 {
+		slog.Info("purged %d tombstones", purged)
+	}
+	return purged, nil
+}
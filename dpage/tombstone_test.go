@@ -0,0 +1,140 @@
+package dpage_test
+
+import (
+	"context"
+	"github.com/rismaster/allris-dpage/dpage"
+	"github.com/rismaster/allris-dpage/dpage/dpagetest"
+	"testing"
+	"time"
+)
+
+// tombstoneConfig keeps the tombstones for an hour
+type tombstoneConfig struct {
+	*dpagetest.Config
+}
+
+func (c *tombstoneConfig) GetTombstoneFolder() string           { return "geloescht/" }
+func (c *tombstoneConfig) GetTombstoneRetention() time.Duration { return time.Hour }
+
+func newTombstoneApp() *dpage.App {
+	return dpage.NewApp(context.Background(), &tombstoneConfig{Config: dpagetest.NewConfig("")}, dpage.NewMemoryStore(), dpage.NewMemoryStore())
+}
+
+func tombstoneReasons(t *testing.T, app *dpage.App, prefix string) (result []string) {
+	t.Helper()
+	tombstones, err := dpage.ListTombstones(app, prefix)
+	if err != nil {
+		t.Fatalf("error listing tombstones: %v", err)
+	}
+	for _, ts := range tombstones {
+		result = append(result, ts.Path+" "+ts.Reason)
+	}
+	return result
+}
+
+func TestTombstones(t *testing.T) {
+
+	app := newTombstoneApp()
+	risTime := berlin(t, "12.04.2021 18:00")
+	path := "vorlagen/vorlage-4711.html"
+
+	// the file is deleted twice, e.g. after it was found again in the RIS
+	start := time.Now()
+	writeObject(t, app.Fetched, path, "<html>erste Fassung</html>", risTime)
+	if err := dpage.TombstoneFile(app, path, "vorlagen sync"); err != nil {
+		t.Fatalf("error deleting: %v", err)
+	}
+	writeObject(t, app.Fetched, path, "<html>zweite Fassung</html>", risTime)
+	if err := dpage.TombstoneFile(app, path, "vorlagen sync 2"); err != nil {
+		t.Fatalf("error deleting again: %v", err)
+	}
+	writeObject(t, app.Fetched, "vorlagen/vorlage-4710.html", "<html>4710</html>", risTime)
+	if err := dpage.TombstoneFile(app, "vorlagen/vorlage-4710.html", "vorlagen sync"); err != nil {
+		t.Fatalf("error deleting: %v", err)
+	}
+
+	if _, err := app.Fetched.Attrs(path); err != dpage.ErrObjectNotExist {
+		t.Errorf("deleted file exists: %v", err)
+	}
+	if names := listNames(t, app.Backup, "geloescht/"); len(names) != 3 {
+		t.Errorf("tombstones are %v", names)
+	}
+	assertStrings(t, "tombstones", tombstoneReasons(t, app, "vorlagen/"), []string{
+		"vorlagen/vorlage-4710.html vorlagen sync",
+		"vorlagen/vorlage-4711.html vorlagen sync",
+		"vorlagen/vorlage-4711.html vorlagen sync 2",
+	})
+	assertStrings(t, "tombstones of path", tombstoneReasons(t, app, path), []string{
+		"vorlagen/vorlage-4711.html vorlagen sync",
+		"vorlagen/vorlage-4711.html vorlagen sync 2",
+	})
+	tombstones, _ := dpage.ListTombstones(app, path)
+	for _, ts := range tombstones {
+		if ts.DeletedAt.Before(start.Truncate(time.Second)) || ts.DeletedAt.After(time.Now()) {
+			t.Errorf("tombstone of %s is deleted at %s", ts.Path, ts.DeletedAt)
+		}
+	}
+
+	// the restore writes the last version back and keeps the older tombstone
+	if err := dpage.RestoreTombstone(app, path); err != nil {
+		t.Fatalf("error restoring: %v", err)
+	}
+	content, err := app.Fetched.Read(path)
+	if err != nil || string(content) != "<html>zweite Fassung</html>" {
+		t.Errorf("restored content is %s: %v", content, err)
+	}
+	attrs, err := app.Fetched.Attrs(path)
+	if err != nil || attrs.Metadata["Deleted-At"] != "" || attrs.Metadata["Deleted-By"] != "" {
+		t.Errorf("restored attrs are %+v: %v", attrs, err)
+	}
+	assertTime(t, "restored ris time", attrs.RisTime, risTime)
+	assertStrings(t, "tombstones after restore", tombstoneReasons(t, app, path), []string{"vorlagen/vorlage-4711.html vorlagen sync"})
+
+	if err = dpage.RestoreTombstone(app, path); err == nil {
+		t.Errorf("no error restoring over an existing file")
+	}
+	if err = dpage.RestoreTombstone(app, "vorlagen/vorlage-4712.html"); err == nil {
+		t.Errorf("no error restoring a file without tombstone")
+	}
+}
+
+func TestPurgeTombstones(t *testing.T) {
+
+	app := newTombstoneApp()
+	risTime := berlin(t, "12.04.2021 18:00")
+
+	writeObject(t, app.Fetched, "vorlagen/vorlage-4711.html", "<html>4711</html>", risTime)
+	if err := dpage.TombstoneFile(app, "vorlagen/vorlage-4711.html", "vorlagen sync"); err != nil {
+		t.Fatalf("error deleting: %v", err)
+	}
+
+	// tombstones written by older versions of dpage are named like the deleted file
+	for name, deletedAt := range map[string]time.Time{
+		"geloescht/vorlagen/vorlage-4700.html": time.Now().Add(-2 * time.Hour),
+		"geloescht/vorlagen/vorlage-4701.html": time.Now().Add(-30 * time.Minute),
+		"geloescht/vorlagen/vorlage-4702.html": {},
+	} {
+		metadata := map[string]string{"Deleted-By": "alte Fassung"}
+		if !deletedAt.IsZero() {
+			metadata["Deleted-At"] = deletedAt.Format(time.RFC3339)
+		}
+		err := app.Backup.Write(name, []byte("<html></html>"), &dpage.ObjectAttrs{ContentType: "text/html", RisTime: risTime, Metadata: metadata})
+		if err != nil {
+			t.Fatalf("error writing %s: %v", name, err)
+		}
+	}
+
+	purged, err := dpage.PurgeTombstones(app)
+	if err != nil {
+		t.Fatalf("error purging: %v", err)
+	}
+	if purged != 1 {
+		t.Errorf("%d tombstones purged, want 1", purged)
+	}
+	// a tombstone without time of deletion is kept
+	assertStrings(t, "tombstones after purge", tombstoneReasons(t, app, ""), []string{
+		"vorlagen/vorlage-4701.html alte Fassung",
+		"vorlagen/vorlage-4702.html alte Fassung",
+		"vorlagen/vorlage-4711.html vorlagen sync",
+	})
+}
@@ -0,0 +1,128 @@
+package dpage
+
+import (
+	"bytes"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/domtools"
+	"golang.org/x/net/html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var jaRegexp = regexp.MustCompile(`(?i)\bja\b[\s:-]*([0-9]+)`)
+var neinRegexp = regexp.MustCompile(`(?i)\bnein\b[\s:-]*([0-9]+)`)
+var enthaltungRegexp = regexp.MustCompile(`(?i)\benthaltung(?:en)?\b[\s:-]*([0-9]+)`)
+
+// Top is the parsed content of a to020 page
+type Top struct {
+	TOLFDNR       int          `json:"tolfdnr"`
+	SILFDNR       int          `json:"silfdnr,omitempty"`
+	Nummer        string       `json:"nummer"`
+	Betreff       string       `json:"betreff"`
+	VOLFDNR       int          `json:"volfdnr,omitempty"`
+	Oeffentlich   bool         `json:"oeffentlich"`
+	Beschlussart  string       `json:"beschlussart,omitempty"`
+	Beschlusstext string       `json:"beschlusstext,omitempty"`
+	Abstimmung    *Abstimmung  `json:"abstimmung,omitempty"`
+	Anlagen       []AnlageInfo `json:"anlagen,omitempty"`
+}
+
+// Abstimmung is the result of a vote on a TOP
+type Abstimmung struct {
+	Ja         int `json:"ja"`
+	Nein       int `json:"nein"`
+	Enthaltung int `json:"enthaltung"`
+}
+
+func ParseTop(html []byte) (*Top, error) {
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(html))
+	if err != nil {
+		return nil, errors.Wrap(err, "error create dom from top")
+	}
+
+	container := doc.Find("#allriscontainer")
+	if container.Size() == 0 {
+		return nil, errors.New("no allriscontainer in top")
+	}
+
+	labels := labelValues(container)
+	info := parseTopNummer(labels["TOP"])
+
+	sitzungHref, _ := container.Find("a[href*=\"si010\"]").Attr("href")
+	vorlageHref, _ := container.Find("a[href*=\"vo020\"]").Attr("href")
+
+	top := &Top{
+		TOLFDNR:       domtools.ExtractIntFromInput(container, "TOLFDNR"),
+		SILFDNR:       idFromHref(sitzungHref, "SILFDNR"),
+		Nummer:        info.Nummer,
+		Betreff:       labels["Betreff"],
+		VOLFDNR:       idFromHref(vorlageHref, "VOLFDNR"),
+		Oeffentlich:   info.Oeffentlich,
+		Beschlussart:  labels["Beschlussart"],
+		Beschlusstext: sectionText(container, "allrisBS"),
+		Anlagen:       parseAnlagen(container),
+	}
+
+	if top.Beschlussart == "" {
+		top.Beschlussart = labels["Beschluss"]
+	}
+
+	abstimmung := labels["Abstimmungsergebnis"]
+	if abstimmung == "" {
+		abstimmung = top.Beschlusstext
+	}
+	top.Abstimmung = parseAbstimmung(abstimmung)
+
+	if top.Betreff == "" {
+		return nil, errors.New("no Betreff in top " + strconv.Itoa(top.TOLFDNR))
+	}
+
+	return top, nil
+}
+
+// parseAbstimmung reads the counts of a text like "Ja: 9 Nein: 2 Enthaltungen: 1", nil if there is no count
+func parseAbstimmung(text string) *Abstimmung {
+
+	ja := jaRegexp.FindStringSubmatch(text)
+	nein := neinRegexp.FindStringSubmatch(text)
+	enthaltung := enthaltungRegexp.FindStringSubmatch(text)
+	if ja == nil && nein == nil && enthaltung == nil {
+		return nil
+	}
+
+	abstimmung := &Abstimmung{}
+	if ja != nil {
+		abstimmung.Ja, _ = strconv.Atoi(ja[1])
+	}
+	if nein != nil {
+		abstimmung.Nein, _ = strconv.Atoi(nein[1])
+	}
+	if enthaltung != nil {
+		abstimmung.Enthaltung, _ = strconv.Atoi(enthaltung[1])
+	}
+	return abstimmung
+}
+
+// sectionText is the text after the anchor <a name="..."> up to the next allris anchor (allrisSV, allrisBV, allrisBS)
+func sectionText(dom *goquery.Selection, anchor string) string {
+
+	start := dom.Find("a[name=\"" + anchor + "\"]")
+	if start.Size() == 0 {
+		return ""
+	}
+
+	var texts []string
+	for node := start.Get(0).NextSibling; node != nil; node = node.NextSibling {
+		if node.Type == html.ElementNode && node.Data == "a" && strings.HasPrefix(domtools.GetAttrFromNode(node, "name"), "allris") {
+			break
+		}
+		text := domtools.GetChildTextFromNode(node)
+		if text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
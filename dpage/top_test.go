@@ -0,0 +1,82 @@
+package dpage_test
+
+import (
+	"github.com/rismaster/allris-dpage/dpage"
+	"testing"
+)
+
+func TestParseTop(t *testing.T) {
+
+	tests := []struct {
+		fixture string
+		want    dpage.Top
+	}{
+		{
+			fixture: "to020-20001.html",
+			want:    dpage.Top{TOLFDNR: 20001, SILFDNR: 1001, Nummer: "1", Betreff: "Eröffnung der Sitzung", Oeffentlich: true},
+		},
+		{
+			fixture: "to020-20002.html",
+			want: dpage.Top{
+				TOLFDNR:       20002,
+				SILFDNR:       1001,
+				Nummer:        "2",
+				Betreff:       "Neubau Radweg Hauptstraße",
+				VOLFDNR:       4711,
+				Oeffentlich:   true,
+				Beschlussart:  "ungeändert beschlossen",
+				Beschlusstext: "Der Ausschuss beschließt den Neubau des Radweges. Die Verwaltung wird beauftragt.",
+				Abstimmung:    &dpage.Abstimmung{Ja: 9, Nein: 2, Enthaltung: 1},
+				Anlagen:       []dpage.AnlageInfo{{Name: "Lageplan", Href: "ydocs/lageplan.pdf", Size: "245 KB"}},
+			},
+		},
+		{
+			fixture: "to020-20003.html",
+			want:    dpage.Top{TOLFDNR: 20003, SILFDNR: 1001, Nummer: "3", Betreff: "Grundstücksangelegenheiten"},
+		},
+		{
+			fixture: "to020-20010.html",
+			want: dpage.Top{
+				TOLFDNR:      20010,
+				SILFDNR:      1002,
+				Nummer:       "1",
+				Betreff:      "Bericht zur Haushaltslage",
+				VOLFDNR:      4710,
+				Oeffentlich:  true,
+				Beschlussart: "zur Kenntnis genommen",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			top, err := dpage.ParseTop(fixture(t, tt.fixture))
+			if err != nil {
+				t.Fatalf("error parsing: %v", err)
+			}
+			assertModel(t, top, tt.want)
+		})
+	}
+}
+
+func TestParseAbstimmung(t *testing.T) {
+
+	tests := []struct {
+		text string
+		want *dpage.Abstimmung
+	}{
+		{text: "Ja: 9, Nein: 2, Enthaltungen: 1", want: &dpage.Abstimmung{Ja: 9, Nein: 2, Enthaltung: 1}},
+		{text: "einstimmig Ja 30 Nein 0", want: &dpage.Abstimmung{Ja: 30}},
+		{text: "Enthaltung - 3", want: &dpage.Abstimmung{Enthaltung: 3}},
+		{text: "einstimmig beschlossen", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got := dpage.ParseAbstimmung(tt.text)
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("abstimmung is %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package dpage
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/domtools"
+	"strings"
+	"time"
+)
+
+// Vorlage is the parsed content of a vo020 page
+type Vorlage struct {
+	VOLFDNR        int          `json:"volfdnr"`
+	Nummer         string       `json:"nummer"`
+	Betreff        string       `json:"betreff"`
+	Art            string       `json:"art"`
+	Status         string       `json:"status"`
+	Federfuehrend  string       `json:"federfuehrend"`
+	Bearbeiter     string       `json:"bearbeiter"`
+	Datum          time.Time    `json:"datum"`
+	Beratungsfolge []Beratung   `json:"beratungsfolge"`
+	Anlagen        []AnlageInfo `json:"anlagen"`
+	Fraktionen     []string     `json:"fraktionen,omitempty"`
+}
+
+// Beratung is one step of the Beratungsfolge of a Vorlage
+type Beratung struct {
+	SILFDNR      int       `json:"silfdnr,omitempty"`
+	Gremium      string    `json:"gremium"`
+	Datum        time.Time `json:"datum"`
+	Rolle        string    `json:"rolle"`
+	Beschlussart string    `json:"beschlussart,omitempty"`
+}
+
+// AnlageInfo describes an Anlage linked on a page, either by Href or as do027 form with DOLFDNR
+type AnlageInfo struct {
+	Name    string `json:"name"`
+	Href    string `json:"href,omitempty"`
+	Size    string `json:"size,omitempty"`
+	DOLFDNR int    `json:"dolfdnr,omitempty"`
+}
+
+func ParseVorlage(html []byte, dates *RisDates) (*Vorlage, error) {
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(html))
+	if err != nil {
+		return nil, errors.Wrap(err, "error create dom from vorlage")
+	}
+
+	container := doc.Find("#allriscontainer")
+	if container.Size() == 0 {
+		return nil, errors.New("no allriscontainer in vorlage")
+	}
+
+	labels := labelValues(container)
+
+	vorlage := &Vorlage{
+		VOLFDNR:       domtools.ExtractIntFromInput(container, "VOLFDNR"),
+		Nummer:        labels["Vorlage-Nr."],
+		Betreff:       labels["Betreff"],
+		Art:           labels["Vorlage-Art"],
+		Status:        labels["Status"],
+		Federfuehrend: labels["Federführend"],
+		Bearbeiter:    labels["Bearbeiter/-in"],
+		Datum:         dates.parse(labels["Datum"]),
+		Anlagen:       parseAnlagen(container),
+	}
+
+	if vorlage.Nummer == "" {
+		title := domtools.CleanText(doc.Find("#risname h1").Text())
+		if i := strings.Index(title, " - "); i >= 0 {
+			vorlage.Nummer = strings.TrimSpace(title[i+3:])
+		}
+	}
+
+	container.Find("table.tl1 tr").Each(func(i int, tr *goquery.Selection) {
+		tds := tr.Children().Filter("td")
+		if tds.Size() < 3 {
+			return
+		}
+		href, _ := tr.Find("a[href*=\"si010\"]").Attr("href")
+		beratung := Beratung{
+			SILFDNR: idFromHref(href, "SILFDNR"),
+			Datum:   dates.parse(domtools.CleanText(tds.Eq(0).Text())),
+			Gremium: domtools.CleanText(tds.Eq(1).Text()),
+			Rolle:   domtools.CleanText(tds.Eq(2).Text()),
+		}
+		if tds.Size() > 3 {
+			beratung.Beschlussart = domtools.CleanText(tds.Eq(3).Text())
+		}
+		if beratung.Gremium != "" {
+			vorlage.Beratungsfolge = append(vorlage.Beratungsfolge, beratung)
+		}
+	})
+
+	if vorlage.Betreff == "" {
+		return nil, errors.New(fmt.Sprintf("no Betreff in vorlage %d", vorlage.VOLFDNR))
+	}
+
+	return vorlage, nil
+}
+
+// parseAnlagen reads the linked Anlagen (last table.tk1) and the Basis-Anlagen (do027 forms) of a page
+func parseAnlagen(dom *goquery.Selection) (anlagen []AnlageInfo) {
+
+	anlagen = append(anlagen, parseLinkedAnlagen(dom)...)
+
+	dom.Find(".me1 > table.tk1").First().Find("form").Each(func(i int, form *goquery.Selection) {
+		dolfdnr := domtools.ExtractIntFromInput(form, "DOLFDNR")
+		if dolfdnr <= 0 {
+			return
+		}
+		name, _ := form.Find("input[type=\"submit\"]").Attr("value")
+		anlagen = append(anlagen, AnlageInfo{
+			Name:    domtools.CleanText(name),
+			DOLFDNR: dolfdnr,
+		})
+	})
+	return anlagen
+}
+
+func parseLinkedAnlagen(dom *goquery.Selection) (anlagen []AnlageInfo) {
+
+	theAnlagenTables := dom.Find("table.tk1")
+	if theAnlagenTables.Size() <= 1 {
+		return anlagen
+	}
+
+	trs := theAnlagenTables.Last().Find("tr")
+	if trs.Size() < 2 || trs.Next().Children().Size() < 2 {
+		return anlagen
+	}
+
+	trs.Each(func(i int, selection *goquery.Selection) {
+		tds := selection.Find("td")
+		if i > 2 && tds.Size() >= 3 {
+
+			lnk := tds.Get(2).FirstChild
+			if lnk != nil {
+				description := domtools.GetChildTextFromNode(lnk)
+				anlage := AnlageInfo{
+					Name: description,
+					Href: domtools.GetAttrFromNode(lnk, "href"),
+				}
+				groups := sizeRegexp.FindAllStringSubmatch(description, -1)
+				if len(groups) > 0 && len(groups[0]) > 2 {
+					anlage.Name = domtools.CleanText(groups[0][1])
+					anlage.Size = domtools.CleanText(groups[0][2])
+				}
+				anlagen = append(anlagen, anlage)
+			}
+		}
+	})
+	return anlagen
+}
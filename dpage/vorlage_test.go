@@ -0,0 +1,71 @@
+package dpage_test
+
+import (
+	"github.com/rismaster/allris-dpage/dpage"
+	"testing"
+)
+
+func TestParseVorlage(t *testing.T) {
+
+	tests := []struct {
+		fixture string
+		want    dpage.Vorlage
+	}{
+		{
+			fixture: "vo020-4710.html",
+			want: dpage.Vorlage{
+				VOLFDNR:       4710,
+				Nummer:        "VO/2021/0810",
+				Betreff:       "Bericht zur Haushaltslage",
+				Art:           "Mitteilungsvorlage",
+				Status:        "öffentlich",
+				Federfuehrend: "Fachbereich Finanzen",
+				Bearbeiter:    "Schulz, Anna",
+				Datum:         berlin(t, "15.02.2021 00:00"),
+				Beratungsfolge: []dpage.Beratung{
+					{SILFDNR: 1002, Gremium: "Rat der Stadt", Datum: berlin(t, "29.04.2021 00:00"), Rolle: "Kenntnisnahme"},
+				},
+			},
+		},
+		{
+			fixture: "vo020-4711.html",
+			want: dpage.Vorlage{
+				VOLFDNR:       4711,
+				Nummer:        "VO/2021/0815",
+				Betreff:       "Neubau Radweg Hauptstraße",
+				Art:           "Beschlussvorlage",
+				Status:        "öffentlich",
+				Federfuehrend: "Fachbereich Bauen",
+				Bearbeiter:    "Meyer, Klaus",
+				Datum:         berlin(t, "01.03.2021 00:00"),
+				Beratungsfolge: []dpage.Beratung{
+					{SILFDNR: 1001, Gremium: "Bau- und Umweltausschuss", Datum: berlin(t, "12.04.2021 00:00"), Rolle: "Vorberatung", Beschlussart: "ungeändert beschlossen"},
+					{SILFDNR: 1002, Gremium: "Rat der Stadt", Datum: berlin(t, "29.04.2021 00:00"), Rolle: "Entscheidung"},
+				},
+				Anlagen: []dpage.AnlageInfo{
+					{Name: "Lageplan", Href: "ydocs/lageplan.pdf", Size: "245 KB"},
+					{Name: "Kostenaufstellung", Href: "ydocs/kosten.pdf", Size: "32 KB"},
+					{Name: "Vorlage (Beschlussvorlage)", DOLFDNR: 55501},
+				},
+			},
+		},
+	}
+
+	dates := testDates(t)
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			vorlage, err := dpage.ParseVorlage(fixture(t, tt.fixture), dates)
+			if err != nil {
+				t.Fatalf("error parsing: %v", err)
+			}
+			assertModel(t, vorlage, tt.want)
+		})
+	}
+}
+
+func TestParseVorlageWithoutContainer(t *testing.T) {
+	_, err := dpage.ParseVorlage([]byte("<html><body><p>Die Seite ist nicht verfügbar</p></body></html>"), testDates(t))
+	if err == nil {
+		t.Error("no error for a page without allriscontainer")
+	}
+}
@@ -5,10 +5,8 @@ import (
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/pkg/errors"
-	"github.com/rismaster/allris-common/application"
 	"github.com/rismaster/allris-common/common"
 	"github.com/rismaster/allris-common/common/domtools"
-	"github.com/rismaster/allris-common/common/files"
 	"github.com/rismaster/allris-common/common/slog"
 	"github.com/rismaster/allris-common/downloader"
 	"net/url"
@@ -16,38 +14,54 @@ import (
 )
 
 type Vorlagenliste struct {
-	app *application.AppContext
+	app *App
 }
 
-func NewVorlagenliste(app *application.AppContext) Vorlagenliste {
+func NewVorlagenliste(app *App) Vorlagenliste {
 	return Vorlagenliste{
 		app: app,
 	}
 }
 
+// SynchronizeSince downloads the Vorlagen of the RIS created after minTime and moves the stored Vorlagen missing
+// in the RIS to the tombstones. With an App of DryRun nothing is written, the changes are collected in its Plan.
+// Afterwards the digests of the changes are sent to the subscribers.
 func (vl *Vorlagenliste) SynchronizeSince(minTime time.Time, redownload bool) error {
 	vorlagen, err := vl.downloadFromMin(minTime, redownload)
 	if err != nil {
 		return errors.Wrap(err, "error downloading vorlagen")
 	}
 
-	err = PublishRisDownload(vl.app, vorlagen)
-	if err != nil {
-		return err
-	}
+	publishErr := PublishRisDownload(vl.app, vorlagen)
 
 	allVorlagenFromRis := make(map[string]bool)
 	for _, v := range vorlagen {
 		vf := NewVorlage(vl.app, &v)
 		allVorlagenFromRis[vf.GetPath()] = true
+		allVorlagenFromRis[vf.GetModelPath()] = true
 	}
 
 	childFolders := []string{vl.app.Config.GetAnlagenFolder(), vl.app.Config.GetTopFolder()}
-	err = files.DeleteFilesIfNotInAndAfter(vl.app, vl.app.Config.GetVorlagenFolder(), allVorlagenFromRis, childFolders, minTime)
+	err = deleteFilesIfNotInAndAfter(vl.app, vl.app.Config.GetVorlagenFolder(), allVorlagenFromRis, childFolders, minTime, "sync vorlagenliste")
 	if err != nil {
 		return errors.Wrap(err, "error deleting vorlagen")
 	}
-	return nil
+
+	err = updateBeratungsgraph(vl.app)
+	if err != nil {
+		slog.Error("error updating beratungsgraph: %v", err)
+	}
+
+	_, err = PurgeTombstones(vl.app)
+	if err != nil {
+		slog.Error("error purging tombstones: %v", err)
+	}
+
+	_, err = SendDigests(vl.app)
+	if err != nil {
+		slog.Error("error sending digests: %v", err)
+	}
+	return publishErr
 }
 
 func (vl *Vorlagenliste) downloadFromMin(minTime time.Time, redownload bool) (results []downloader.RisRessource, err error) {
@@ -86,9 +100,9 @@ func (vl *Vorlagenliste) fetch(ressourceUrl string, page int, risCreatedSince ti
 	}
 
 	srcWeb := downloader.NewRisRessource("", fmt.Sprintf("%s-%d", vl.app.Config.GetVorlagenListeType(), page), ".html", time.Now(), uri, &url.Values{}, true, redownload)
-	targetStore := files.NewFile(vl.app, srcWeb)
+	targetStore := NewFile(vl.app, srcWeb)
 
-	err = targetStore.Fetch(files.HttpGet, srcWeb, "text/html")
+	err = targetStore.Fetch(httpGet, srcWeb, "text/html")
 	if err != nil {
 		return false, nil, errors.Wrap(err, fmt.Sprintf("error downloading Vorlagenliste from %s", ressourceUrl))
 	}
@@ -141,20 +155,26 @@ func (vl *Vorlagenliste) parseElement(e *goquery.Selection, vlRisResource *downl
 	volfdnr := domtools.ExtractIntFromInput(dom, "VOLFDNR")
 	dateText := domtools.GetChildTextFromNode(dom.Get(3))
 
-	location, err := time.LoadLocation(vl.app.Config.GetTimezone())
+	dates, err := vl.app.Dates()
 	if err != nil {
 		return nil, err
 	}
 
-	risCreatedSince, err := time.ParseInLocation(vl.app.Config.GetDateFormat(), dateText, location)
+	risCreatedSince, err := time.ParseInLocation(vl.app.Config.GetDateFormat(), dateText, dates.Location())
 	if err != nil {
 		return nil, errors.New("false html format no created date of Vorgangsliste")
 	}
 
-	uri, err := url.Parse(vl.app.Config.GetTargetToParse() + fmt.Sprintf(vl.app.Config.GetUrlVorlageTmpl(), volfdnr))
+	return newVorlageRessource(vl.app, volfdnr, risCreatedSince, vlRisResource.RedownloadChildren)
+}
+
+// newVorlageRessource is the RisRessource of the vo020 page of the Vorlage with the VOLFDNR
+func newVorlageRessource(app *App, volfdnr int, risCreated time.Time, redownload bool) (*downloader.RisRessource, error) {
+
+	uri, err := url.Parse(app.Config.GetTargetToParse() + fmt.Sprintf(app.Config.GetUrlVorlageTmpl(), volfdnr))
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot parse url")
 	}
 
-	return downloader.NewRisRessource(vl.app.Config.GetVorlagenFolder(), fmt.Sprintf("%s-%d", vl.app.Config.GetVorlageType(), volfdnr), ".html", risCreatedSince, uri, &url.Values{}, vlRisResource.RedownloadChildren, vlRisResource.RedownloadChildren), nil
+	return downloader.NewRisRessource(app.Config.GetVorlagenFolder(), fmt.Sprintf("%s-%d", app.Config.GetVorlageType(), volfdnr), ".html", risCreated, uri, &url.Values{}, redownload, redownload), nil
 }
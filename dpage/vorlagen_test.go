@@ -0,0 +1,76 @@
+package dpage_test
+
+import (
+	"encoding/json"
+	"github.com/rismaster/allris-dpage/dpage"
+	"testing"
+	"time"
+)
+
+func TestVorlagenlisteSynchronizeSince(t *testing.T) {
+
+	anlagen := []string{
+		"anlagen/vorlage-4711-anlage-245-kb-lageplan.pdf",
+		"anlagen/vorlage-4711-anlage-245-kb-lageplan.text.json",
+		"anlagen/vorlage-4711-anlage-32-kb-kosten.pdf",
+		"anlagen/vorlage-4711-anlage-32-kb-kosten.text.json",
+		"anlagen/vorlage-4711-anlagedoc-55501-1.pdf",
+		"anlagen/vorlage-4711-anlagedoc-55501-1.text.json",
+		"beratungsfolgen.json",
+	}
+
+	tests := []struct {
+		name    string
+		minTime time.Time
+		want    []string
+	}{
+		{
+			name:    "all",
+			minTime: time.Time{},
+			want: append(append([]string{}, anlagen...),
+				"vorlagen/vorlage-4710.html",
+				"vorlagen/vorlage-4710.json",
+				"vorlagen/vorlage-4711.html",
+				"vorlagen/vorlage-4711.json",
+				"vorlagenliste-0.html",
+				"vorlagenliste-1.html"),
+		},
+		{
+			name:    "since",
+			minTime: time.Date(2021, 2, 20, 0, 0, 0, 0, time.UTC),
+			want: append(append([]string{}, anlagen...),
+				"vorlagen/vorlage-4711.html",
+				"vorlagen/vorlage-4711.json",
+				"vorlagenliste-0.html"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newEnv(t)
+			vl := dpage.NewVorlagenliste(env.App)
+			err := vl.SynchronizeSince(tt.minTime, false)
+			if err != nil {
+				t.Fatalf("error synchronizing: %v", err)
+			}
+			assertNames(t, env, tt.want)
+
+			content, ok := env.Storage.Content(env.Config.BucketFetched, "vorlagen/vorlage-4711.json")
+			if !ok {
+				t.Fatal("no model of vorlage 4711")
+			}
+			var vorlage dpage.Vorlage
+			err = json.Unmarshal(content, &vorlage)
+			if err != nil {
+				t.Fatalf("error unmarshalling model: %v", err)
+			}
+			if vorlage.VOLFDNR != 4711 || vorlage.Nummer != "VO/2021/0815" || vorlage.Betreff != "Neubau Radweg Hauptstraße" {
+				t.Errorf("model is %d %s %s", vorlage.VOLFDNR, vorlage.Nummer, vorlage.Betreff)
+			}
+			if len(vorlage.Beratungsfolge) != 2 || len(vorlage.Anlagen) != 3 {
+				t.Errorf("model has %d beratungen and %d anlagen, want 2 and 3", len(vorlage.Beratungsfolge), len(vorlage.Anlagen))
+			}
+			assertTime(t, "datum", vorlage.Datum, berlin(t, "01.03.2021 00:00"))
+		})
+	}
+}
@@ -3,16 +3,19 @@ module github.com/rismaster/allris-dpage
 go 1.15
 
 require (
-	cloud.google.com/go v0.81.0 // indirect
-	cloud.google.com/go/pubsub v1.3.1 // indirect
-	cloud.google.com/go/storage v1.15.0 // indirect
+	cloud.google.com/go/pubsub v1.3.1
+	cloud.google.com/go/storage v1.16.1
 	github.com/PuerkitoBio/goquery v1.6.1
-	github.com/google/go-cmp v0.5.5 // indirect
-	github.com/mailgun/mailgun-go/v4 v4.5.1 // indirect
+	github.com/blevesearch/bleve/v2 v2.0.5
+	github.com/ledongthuc/pdf v0.0.0-20220302134840-0c2507a12d80
+	github.com/mailgun/mailgun-go/v4 v4.5.1
+	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/microcosm-cc/bluemonday v1.0.9 // indirect
+	github.com/minio/minio-go/v7 v7.0.12
 	github.com/pkg/errors v0.9.1
 	github.com/rismaster/allris-common v0.0.0-20211117134923-0c3b7051e1c9
-	golang.org/x/net v0.0.0-20210503060351-7fd8e65b6420 // indirect
-	google.golang.org/api v0.45.0 // indirect
-	h12.io/socks v1.0.2 // indirect
+	golang.org/x/net v0.0.0-20210503060351-7fd8e65b6420
+	golang.org/x/time v0.0.0-20210723032227-1f47c861a9ac
+	google.golang.org/api v0.54.0
+	h12.io/socks v1.0.2
 )